@@ -0,0 +1,430 @@
+package advisor
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/opcode"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+	"github.com/qw4990/index_advisor/optimizer"
+	"github.com/qw4990/index_advisor/utils"
+)
+
+// maxOrderedCandidateWidth is the max number of columns in ordered candidates, which is the upper bound of the max
+// index width.
+const maxOrderedCandidateWidth = 5
+
+// predicateType is how a column is used in a query block.
+type predicateType int
+
+const (
+	predicateEQ    predicateType = iota // `a = 1`
+	predicateIN                         // `a in (1, 2)`
+	predicateRange                      // `a > 1`, `a between 1 and 2` or `a like 'x%'`
+	predicateSort                       // `order by a` or `group by a`
+)
+
+// columnPredicate is a column with how it's used in a query block.
+type columnPredicate struct {
+	col    utils.Column
+	tp     predicateType
+	values int // the number of values of IN predicates
+}
+
+// classifyPredicate returns the column predicate if the expression is an equality, IN or range predicate between
+// an indexable column and constants.
+func classifyPredicate(r *columnResolver, expr ast.ExprNode) (columnPredicate, bool) {
+	return classifyColumnPredicate(expr, r.indexableColumn)
+}
+
+// classifyColumnPredicate is like classifyPredicate, but columns are got by the given function.
+func classifyColumnPredicate(expr ast.ExprNode, column func(ast.ExprNode) (utils.Column, bool)) (columnPredicate, bool) {
+	switch x := expr.(type) {
+	case *ast.ParenthesesExpr:
+		return classifyColumnPredicate(x.Expr, column)
+	case *ast.BinaryOperationExpr:
+		var tp predicateType
+		switch x.Op {
+		case opcode.EQ:
+			tp = predicateEQ
+		case opcode.LT, opcode.LE, opcode.GT, opcode.GE:
+			tp = predicateRange
+		default:
+			return columnPredicate{}, false
+		}
+		if col, ok := column(x.L); ok && isConstExpr(x.R) {
+			return columnPredicate{col: col, tp: tp}, true
+		}
+		if col, ok := column(x.R); ok && isConstExpr(x.L) {
+			return columnPredicate{col: col, tp: tp}, true
+		}
+	case *ast.PatternInExpr:
+		if x.Not || x.Sel != nil {
+			return columnPredicate{}, false
+		}
+		for _, item := range x.List {
+			if !isConstExpr(item) {
+				return columnPredicate{}, false
+			}
+		}
+		if col, ok := column(x.Expr); ok {
+			return columnPredicate{col: col, tp: predicateIN, values: len(x.List)}, true
+		}
+	case *ast.BetweenExpr:
+		if x.Not || !isConstExpr(x.Left) || !isConstExpr(x.Right) {
+			return columnPredicate{}, false
+		}
+		if col, ok := column(x.Expr); ok {
+			return columnPredicate{col: col, tp: predicateRange}, true
+		}
+	case *ast.PatternLikeExpr: // only `like 'x%'` can be converted to a range
+		pattern, ok := x.Pattern.(*driver.ValueExpr)
+		if x.Not || !ok {
+			return columnPredicate{}, false
+		}
+		if p := pattern.GetString(); p == "" || p[0] == '%' || p[0] == '_' {
+			return columnPredicate{}, false
+		}
+		if col, ok := column(x.Expr); ok {
+			return columnPredicate{col: col, tp: predicateRange}, true
+		}
+	}
+	return columnPredicate{}, false
+}
+
+// columnPredicateVisitor collects column predicates of each query block.
+type columnPredicateVisitor struct {
+	resolver *columnResolver
+	blocks   [][]columnPredicate
+}
+
+func (v *columnPredicateVisitor) Enter(n ast.Node) (node ast.Node, skipChildren bool) {
+	sel, ok := n.(*ast.SelectStmt)
+	if !ok {
+		return n, false
+	}
+	var filters []ast.ExprNode // ON conditions of outer joins are not filters
+	if sel.Where != nil {
+		filters = utils.FlattenCNF(sel.Where)
+	}
+	if sel.From != nil {
+		filters, _ = collectOnConditions(sel.From.TableRefs, filters, nil)
+	}
+	var preds []columnPredicate
+	for _, expr := range filters {
+		if p, ok := classifyPredicate(v.resolver, expr); ok {
+			preds = append(preds, p)
+		}
+	}
+	if sel.OrderBy != nil {
+		preds = append(preds, v.sortColumns(sel.OrderBy.Items, true)...)
+	} else if sel.GroupBy != nil {
+		preds = append(preds, v.sortColumns(sel.GroupBy.Items, false)...)
+	}
+	v.blocks = append(v.blocks, preds)
+	return n, false
+}
+
+// sortColumns returns the sort columns if an index can provide the order, which means all items are columns of
+// the same table, and they are in the same direction if the order matters.
+func (v *columnPredicateVisitor) sortColumns(items []*ast.ByItem, ordered bool) []columnPredicate {
+	var preds []columnPredicate
+	for _, item := range items {
+		col, ok := v.resolver.indexableColumn(item.Expr)
+		if !ok || (len(preds) > 0 && columnTable(col).Key() != columnTable(preds[0].col).Key()) ||
+			(ordered && item.Desc != items[0].Desc) {
+			return nil
+		}
+		preds = append(preds, columnPredicate{col: col, tp: predicateSort})
+	}
+	return preds
+}
+
+func (v *columnPredicateVisitor) Leave(n ast.Node) (node ast.Node, ok bool) {
+	return n, true
+}
+
+// columnNDVs is the number of distinct values of columns, the key is `schema.table.column`.
+type columnNDVs map[string]float64
+
+// loadColumnNDVs reads NDVs of columns of these tables from `show stats_histograms`, columns without stats are
+// ignored.
+func loadColumnNDVs(op optimizer.WhatIfOptimizer, tables []utils.TableName) (columnNDVs, error) {
+	ndvs := make(columnNDVs)
+	for _, t := range tables {
+		rows, err := op.Query(fmt.Sprintf(`show stats_histograms where db_name='%s' and table_name='%s'`, t.SchemaName, t.TableName))
+		if err != nil {
+			return nil, err
+		}
+		err = readColumnNDVs(rows, ndvs)
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ndvs, nil
+}
+
+func readColumnNDVs(rows *sql.Rows, ndvs columnNDVs) error {
+	return readRows(rows, func(row map[string]string) {
+		ndv, err := strconv.ParseFloat(row["distinct_count"], 64)
+		if row["is_index"] != "0" || err != nil {
+			return
+		}
+		// partitioned tables have a row for each partition and maybe a global one, use the largest NDV
+		col := utils.NewColumn(row["db_name"], row["table_name"], row["column_name"])
+		ndvs[col.Key()] = utils.Max(ndvs[col.Key()], ndv)
+	})
+}
+
+// readRows calls f with each row, which maps lower-case column names to values.
+func readRows(rows *sql.Rows, f func(row map[string]string)) error {
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		values := make([]sql.NullString, len(cols))
+		dest := make([]interface{}, len(cols))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		row := make(map[string]string)
+		for i, col := range cols {
+			row[strings.ToLower(col)] = values[i].String
+		}
+		f(row)
+	}
+	return rows.Err()
+}
+
+// selectivity estimates the fraction of rows kept by the predicate according to NDVs, 1 if the NDV is unknown.
+func (ndvs columnNDVs) selectivity(p columnPredicate) float64 {
+	ndv := ndvs[p.col.Key()]
+	if ndv <= 0 {
+		return 1
+	}
+	switch p.tp {
+	case predicateEQ:
+		return 1 / ndv
+	case predicateIN:
+		return utils.Min(1, float64(p.values)/ndv)
+	}
+	return 1
+}
+
+// orderColumns orders columns of the same table like a DBA does: equality and IN columns first ordered by their
+// selectivity, then the most selective range column, and then the sort columns. Columns are deduplicated, and a
+// column is placed at its best position, e.g. `a` is an equality column for `a = 1 and a > 0 order by a`.
+func orderColumns(preds []columnPredicate, ndvs columnNDVs) []utils.Column {
+	var eqs, ranges, sorts []columnPredicate
+	best := make(map[string]columnPredicate) // column -> its best predicate
+	for _, p := range preds {
+		if b, ok := best[p.col.Key()]; !ok || p.tp < b.tp || (p.tp == b.tp && p.tp == predicateIN && p.values < b.values) {
+			best[p.col.Key()] = p
+		}
+	}
+	used := utils.NewSet[utils.Column]()
+	for _, p := range preds {
+		if used.Contains(p.col) || best[p.col.Key()] != p {
+			continue
+		}
+		used.Add(p.col)
+		switch p.tp {
+		case predicateEQ, predicateIN:
+			eqs = append(eqs, p)
+		case predicateRange:
+			ranges = append(ranges, p)
+		case predicateSort:
+			sorts = append(sorts, p)
+		}
+	}
+	sort.SliceStable(eqs, func(i, j int) bool {
+		return ndvs.selectivity(eqs[i]) < ndvs.selectivity(eqs[j])
+	})
+	sort.SliceStable(ranges, func(i, j int) bool { // a range on a column with more distinct values is more selective
+		return ndvs[ranges[i].col.Key()] > ndvs[ranges[j].col.Key()]
+	})
+
+	var cols []utils.Column
+	for _, p := range eqs {
+		cols = append(cols, p.col)
+	}
+	if len(ranges) > 0 {
+		cols = append(cols, ranges[0].col)
+	}
+	for _, p := range sorts {
+		cols = append(cols, p.col)
+	}
+	if len(cols) > maxOrderedCandidateWidth {
+		cols = cols[:maxOrderedCandidateWidth]
+	}
+	return cols
+}
+
+// orderedCandidates generates a well-ordered composite candidate for each table in each query block.
+func orderedCandidates(blocks [][]columnPredicate, ndvs columnNDVs) []utils.Index {
+	var candidates []utils.Index
+	for _, preds := range blocks {
+		var tables []string
+		tablePreds := make(map[string][]columnPredicate)
+		for _, p := range preds {
+			t := columnTable(p.col).Key()
+			if _, ok := tablePreds[t]; !ok {
+				tables = append(tables, t)
+			}
+			tablePreds[t] = append(tablePreds[t], p)
+		}
+		for _, t := range tables {
+			cols := orderColumns(tablePreds[t], ndvs)
+			if len(cols) < 2 { // single-column candidates are generated from indexable columns already
+				continue
+			}
+			candidates = append(candidates, utils.NewIndexWithColumns(tempIndexName(cols...), cols...))
+		}
+	}
+	return candidates
+}
+
+// OrderedCandidatesSelection generates well-ordered composite candidate indexes for the workload according to how
+// columns are used in queries and their NDVs in stats, and adds them to the workload's candidate indexes.
+func OrderedCandidatesSelection(workloadInfo *utils.WorkloadInfo, op optimizer.WhatIfOptimizer) error {
+	tables := utils.NewSet[utils.TableName]()
+	for _, col := range workloadInfo.IndexableColumns.ToList() {
+		tables.Add(columnTable(col))
+	}
+	ndvs, err := loadColumnNDVs(op, tables.ToList())
+	if err != nil {
+		utils.Warningf("failed to read NDVs of columns, candidates are ordered without them: %v", err)
+		ndvs = make(columnNDVs)
+	}
+
+	views := utils.NewViewResolver(workloadInfo.TableSchemas, workloadInfo.Views)
+	if workloadInfo.CandidateIndexes == nil {
+		workloadInfo.CandidateIndexes = utils.NewSet[utils.Index]()
+	}
+	for _, q := range workloadInfo.Queries.ToList() {
+		stmt, err := utils.ParseOneSQL(q.Text)
+		if err != nil {
+			return err
+		}
+		v := &columnPredicateVisitor{resolver: newColumnResolver(q.SchemaName, views)}
+		v.resolver.resolve(stmt)
+		stmt.Accept(v)
+		for _, c := range orderedCandidates(v.blocks, ndvs) {
+			utils.Debugf("query %v: ordered candidate %v", q.Alias, c.Key())
+			workloadInfo.CandidateIndexes.Add(c)
+		}
+	}
+	return nil
+}
+
+// orderLimitColumns returns columns of equality filters and `ORDER BY` items of the query if it's like
+// `select ... where a = 1 order by b limit 10`, ok is false if the query has no `LIMIT`, or an index cannot provide the
+// order because `ORDER BY` items are not columns of the same table in the same direction.
+func orderLimitColumns(q utils.Query, views *utils.ViewResolver) (eqCols, orderCols []utils.Column, ok bool, err error) {
+	stmt, err := utils.ParseOneSQL(q.Text)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	sel, isSelect := stmt.(*ast.SelectStmt)
+	if !isSelect || sel.Limit == nil || sel.OrderBy == nil {
+		return nil, nil, false, nil
+	}
+	v := &columnPredicateVisitor{resolver: newColumnResolver(q.SchemaName, views)}
+	v.resolver.resolve(stmt)
+	sorts := v.sortColumns(sel.OrderBy.Items, true)
+	if len(sorts) == 0 {
+		return nil, nil, false, nil
+	}
+
+	var filters []ast.ExprNode
+	if sel.Where != nil {
+		filters = utils.FlattenCNF(sel.Where)
+	}
+	if sel.From != nil {
+		filters, _ = collectOnConditions(sel.From.TableRefs, filters, nil)
+	}
+	table := columnTable(sorts[0].col).Key()
+	for _, expr := range filters {
+		if p, ok := classifyPredicate(v.resolver, expr); ok && p.tp == predicateEQ &&
+			columnTable(p.col).Key() == table && !containsColumn(eqCols, p.col) {
+			eqCols = append(eqCols, p.col)
+		}
+	}
+	for _, p := range sorts {
+		if !containsColumn(eqCols, p.col) && !containsColumn(orderCols, p.col) {
+			orderCols = append(orderCols, p.col)
+		}
+	}
+	return eqCols, orderCols, true, nil
+}
+
+// coveringIndexes returns a covering index for each table in the query, which contains all columns of the table
+// referenced anywhere in the query, including `SELECT`, `WHERE`, `JOIN`, `GROUP BY` and `ORDER BY`. Columns with
+// predicates are placed first in the order of orderColumns, and the others follow by their names. Tables with columns
+// which cannot be indexed are skipped. Aliases of tables in the query are returned to match tables in its plan.
+func coveringIndexes(q utils.Query, views *utils.ViewResolver) ([]utils.Index, map[string]string, error) {
+	stmt, err := utils.ParseOneSQL(q.Text)
+	if err != nil {
+		return nil, nil, err
+	}
+	v := &columnPredicateVisitor{resolver: newColumnResolver(q.SchemaName, views)}
+	v.resolver.resolve(stmt)
+	stmt.Accept(v)
+
+	var tables []utils.TableName
+	tableCols := make(map[string]utils.Set[utils.Column])
+	addColumn := func(c utils.Column) {
+		t := columnTable(c)
+		if _, ok := tableCols[t.Key()]; !ok {
+			tables = append(tables, t)
+			tableCols[t.Key()] = utils.NewSet[utils.Column]()
+		}
+		tableCols[t.Key()].Add(c)
+	}
+	for _, cols := range v.resolver.resolved {
+		for _, c := range cols {
+			addColumn(c)
+		}
+	}
+	for _, c := range v.resolver.expanded {
+		addColumn(c)
+	}
+	tablePreds := make(map[string][]columnPredicate)
+	for _, preds := range v.blocks {
+		for _, p := range preds {
+			t := columnTable(p.col).Key()
+			tablePreds[t] = append(tablePreds[t], p)
+		}
+	}
+
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Key() < tables[j].Key() })
+	var indexes []utils.Index
+	for _, t := range tables {
+		referenced := tableCols[t.Key()].ToList()
+		indexable := true
+		for _, c := range referenced {
+			indexable = indexable && checkColumnIndexableByType(c)
+		}
+		if !indexable {
+			continue
+		}
+		cols := orderColumns(tablePreds[t.Key()], make(columnNDVs))
+		sort.Slice(referenced, func(i, j int) bool { return referenced[i].ColumnName < referenced[j].ColumnName })
+		for _, c := range referenced {
+			if !containsColumn(cols, c) {
+				cols = append(cols, c)
+			}
+		}
+		indexes = append(indexes, utils.NewIndexWithColumns(tempIndexName(cols...), cols...))
+	}
+	return indexes, collectTableAliases(stmt), nil
+}
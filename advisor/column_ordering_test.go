@@ -0,0 +1,142 @@
+package advisor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/qw4990/index_advisor/utils"
+)
+
+func TestOrderedCandidates(t *testing.T) {
+	tt, err := utils.ParseCreateTableStmt("test", "create table t (a int, b int, c int, d int, e varchar(20), f text)")
+	must(err)
+	t2, err := utils.ParseCreateTableStmt("test", "create table t2 (a int, b int)")
+	must(err)
+	views := utils.NewViewResolver(utils.ListToSet(tt, t2), nil)
+	ndvs := columnNDVs{"test.t.a": 10, "test.t.b": 1000, "test.t.c": 100, "test.t.d": 5000, "test.t.e": 50}
+
+	cases := []struct {
+		sql        string
+		candidates string
+	}{
+		// equalities ordered by selectivity, then the range column, then the sort columns
+		{"select * from t where a = 1 and d > 1 and b = 2 order by c", "test.t(b,a,d,c)"},
+		// `b in (...)` with 100 values is less selective than `c = 1`
+		{"select * from t where b in (" + strings.Repeat("1, ", 99) + "1) and c = 1 and a = 1", "test.t(c,b,a)"},
+		// the most selective range column, and a column is placed at its best position
+		{"select * from t where a > 1 and d between 1 and 10 and e like 'x%' and c = 1 and c > 0 group by c, b", "test.t(c,d,b)"},
+		// unknown NDVs keep the original order
+		{"select * from t2 where a = 1 and b = 1", "test.t2(a,b)"},
+		// unusable predicates and orders
+		{"select * from t where e like '%x' and f = 'x' and a = 1 order by b, c desc", ""},
+		{"select * from t join t2 on t.a = t2.a where t.b = 1 and t2.b = 1 order by t.c, t2.a", ""},
+		// each query block
+		{"select * from t where a = 1 and c = 1 and exists (select 1 from t2 where t2.a = t.a and t2.b > 1 order by t2.a)",
+			"test.t(c,a) test.t2(b,a)"}, // `t2.a = t.a` is a join predicate
+	}
+	for _, c := range cases {
+		stmt, err := utils.ParseOneSQL(c.sql)
+		must(err)
+		v := &columnPredicateVisitor{resolver: newColumnResolver("test", views)}
+		v.resolver.resolve(stmt)
+		stmt.Accept(v)
+		var keys []string
+		for _, idx := range orderedCandidates(v.blocks, ndvs) {
+			keys = append(keys, idx.Key())
+		}
+		if strings.Join(keys, " ") != c.candidates {
+			t.Fatalf("unexpected candidates for %v: %v, expected %v", c.sql, keys, c.candidates)
+		}
+	}
+}
+
+func TestOrderLimitColumns(t *testing.T) {
+	tt, err := utils.ParseCreateTableStmt("test", "create table t (a int, b int, c int, d int)")
+	must(err)
+	t2, err := utils.ParseCreateTableStmt("test", "create table t2 (a int, b int)")
+	must(err)
+	views := utils.NewViewResolver(utils.ListToSet(tt, t2), nil)
+
+	cases := []struct {
+		sql     string
+		columns string // equality columns | order-by columns
+	}{
+		{"select * from t where a = 1 and b > 1 and c = 1 order by d desc, b desc limit 10", "a,c|d,b"},
+		{"select * from t x join t2 on x.a = t2.a where x.b = 1 and t2.b = 1 order by x.c limit 10, 10", "b|c"},
+		{"select * from t where a = 1 order by a, b limit 10", "a|b"},
+		{"select * from t where a = 1 order by b", ""},                  // no limit
+		{"select * from t where a = 1 order by b, c desc limit 10", ""}, // different directions
+		{"select * from t join t2 on t.a = t2.a order by t.b, t2.b limit 10", ""},
+		{"select * from t where a = 1 order by b + 1 limit 10", ""},
+	}
+	for _, c := range cases {
+		eqCols, orderCols, ok, err := orderLimitColumns(utils.Query{SchemaName: "test", Text: c.sql}, views)
+		must(err)
+		var columns string
+		if ok {
+			var eqs, orders []string
+			for _, col := range eqCols {
+				eqs = append(eqs, col.ColumnName)
+			}
+			for _, col := range orderCols {
+				orders = append(orders, col.ColumnName)
+			}
+			columns = strings.Join(eqs, ",") + "|" + strings.Join(orders, ",")
+		}
+		if columns != c.columns {
+			t.Fatalf("unexpected columns for %v: %v, expected %v", c.sql, columns, c.columns)
+		}
+	}
+
+	plan := utils.Plan{
+		{"Projection_4", "10.00", "100.00", "root", "", "test.t.a"},
+		{"└─TopN_7", "10.00", "90.00", "root", "", "test.t.b, offset:0, count:10"},
+		{"  └─TableReader_15", "10.00", "80.00", "root", "", "data:TopN_14"},
+	}
+	if !hasSortOperator(plan) || hasSortOperator(plan[2:]) {
+		t.Fatalf("unexpected sort operators")
+	}
+}
+
+func TestCoveringIndexes(t *testing.T) {
+	views := starSchemaViews()
+	cases := []struct {
+		sql     string
+		indexes string
+	}{
+		{"select v from f where d1 = 1", "test.f(d1,v)"},
+		// columns in select, where, join and order of each table
+		{"select x.v, y.k from f x join d1 y on x.d1 = y.id where y.c = 'a' and x.v > 1 order by y.k",
+			"test.d1(c,k,id) test.f(v,d1)"},
+		{"select d1.*, f.v from f, d1 where f.d1 = d1.id and f.c = 'a' group by d1.id", "test.d1(id,c,k) test.f(c,d1,v)"},
+		// columns which cannot be indexed
+		{"select d2.t from f join d2 on f.d2 = d2.id where f.c = 'a'", "test.f(c,d2)"},
+	}
+	for _, c := range cases {
+		indexes, _, err := coveringIndexes(utils.Query{SchemaName: "test", Text: c.sql}, views)
+		must(err)
+		var keys []string
+		for _, idx := range indexes {
+			keys = append(keys, idx.Key())
+		}
+		if strings.Join(keys, " ") != c.indexes {
+			t.Fatalf("unexpected covering indexes for %v: %v, expected %v", c.sql, keys, c.indexes)
+		}
+	}
+
+	plan := utils.Plan{
+		{"IndexJoin_12", "12.50", "1000.00", "root", "", "inner join, inner:IndexLookUp_11, outer key:test.f.d1, inner key:test.d1.id, equal cond:eq(test.f.d1, test.d1.id)"},
+		{"├─IndexReader_20(Build)", "10.00", "100.00", "root", "", "index:IndexRangeScan_19"},
+		{"│ └─IndexRangeScan_19", "10.00", "100.00", "cop[tikv]", "table:x, index:idx(c, d1, v)", "range:[\"a\",\"a\"], keep order:false"},
+		{"└─IndexLookUp_11(Probe)", "1.25", "80.00", "root", "", ""},
+		{"  ├─IndexRangeScan_9(Build)", "1.25", "40.00", "cop[tikv]", "table:d1, index:idx(id)", "range: decided by [eq(test.d1.id, test.f.d1)], keep order:false"},
+		{"  └─TableRowIDScan_10(Probe)", "1.25", "40.00", "cop[tikv]", "table:d1", "keep order:false"},
+	}
+	tree, aliases := parsePlanTree(plan), map[string]string{"x": "f"}
+	if r := tree.readers("f", aliases); r.Size() != 1 || !r.Contains(planReader("IndexReader")) {
+		t.Fatalf("unexpected readers of f: %v", r.ToList())
+	}
+	if r := tree.readers("d1", aliases); r.Size() != 1 || !r.Contains(planReader("IndexLookUp")) {
+		t.Fatalf("unexpected readers of d1: %v", r.ToList())
+	}
+}
@@ -0,0 +1,471 @@
+package advisor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/model"
+	"github.com/qw4990/index_advisor/utils"
+)
+
+// columnResolver resolves column references in a query to the base-table columns they come from, following the name
+// resolution rules of MySQL: table aliases, derived tables, CTEs, views, correlated subqueries and columns merged by
+// `USING` or `NATURAL` joins are all taken into account.
+// A column normally comes from exactly one base column, except columns of unions, which come from all branches, and
+// columns computed by expressions like `a+1`, which come from no base column.
+type columnResolver struct {
+	defaultSchema string
+	views         *utils.ViewResolver
+
+	resolved map[*ast.ColumnName][]utils.Column
+	expanded []utils.Column // base columns output by `*` or `t.*`
+	errs     []error        // ambiguous or unknown columns
+}
+
+func newColumnResolver(defaultSchema string, views *utils.ViewResolver) *columnResolver {
+	return &columnResolver{
+		defaultSchema: strings.ToLower(defaultSchema),
+		views:         views,
+		resolved:      make(map[*ast.ColumnName][]utils.Column),
+	}
+}
+
+// resolve resolves all column references in the statement, the result can be got by baseColumns.
+func (r *columnResolver) resolve(stmt ast.StmtNode) {
+	switch x := stmt.(type) {
+	case *ast.SelectStmt, *ast.SetOprStmt:
+		r.resolveQuery(x.(ast.ResultSetNode), nil)
+	case *ast.ExplainStmt:
+		r.resolve(x.Stmt)
+	}
+}
+
+// baseColumns returns the base-table columns where the column comes from, and whether the column is resolved.
+func (r *columnResolver) baseColumns(col *ast.ColumnName) ([]utils.Column, bool) {
+	cols, ok := r.resolved[col]
+	return cols, ok
+}
+
+// indexableColumn returns the base column if the expression is a column from exactly one indexable base column.
+func (r *columnResolver) indexableColumn(expr ast.ExprNode) (utils.Column, bool) {
+	c, ok := r.baseColumn(expr)
+	if !ok || !checkColumnIndexableByType(c) {
+		return utils.Column{}, false
+	}
+	return c, true
+}
+
+// prefixColumn returns the base column if the expression is a column from exactly one long string column, which can
+// only be indexed by a prefix.
+func (r *columnResolver) prefixColumn(expr ast.ExprNode) (utils.Column, bool) {
+	c, ok := r.baseColumn(expr)
+	if !ok || !checkColumnPrefixIndexableByType(c) {
+		return utils.Column{}, false
+	}
+	return c, true
+}
+
+// baseColumn returns the base column if the expression is a column from exactly one base column.
+func (r *columnResolver) baseColumn(expr ast.ExprNode) (utils.Column, bool) {
+	for {
+		p, ok := expr.(*ast.ParenthesesExpr)
+		if !ok {
+			break
+		}
+		expr = p.Expr
+	}
+	c, ok := expr.(*ast.ColumnNameExpr)
+	if !ok {
+		return utils.Column{}, false
+	}
+	cols, ok := r.baseColumns(c.Name)
+	if !ok || len(cols) != 1 {
+		return utils.Column{}, false
+	}
+	return cols[0], true
+}
+
+// scopeColumn is an output column of a table, a view, a derived table, a CTE or a query block.
+type scopeColumn struct {
+	name string
+	base []utils.Column
+}
+
+// scopeSource is a table source in the `FROM` clause.
+type scopeSource struct {
+	schema string // empty for derived tables and CTEs
+	alias  string
+	known  bool // false if its columns are unknown, e.g. a table without the schema info
+	cols   []scopeColumn
+	merged map[string]bool // columns merged into the left side by `USING` or `NATURAL` joins
+}
+
+// scope is the name resolution scope of a query block.
+type scope struct {
+	parent  *scope
+	ctes    map[string]*scopeSource
+	sources []*scopeSource
+	fields  []scopeColumn // output columns, which can be referenced by `GROUP BY`, `HAVING` and `ORDER BY`
+}
+
+func (s *scope) cte(name string) (*scopeSource, bool) {
+	for cur := s; cur != nil; cur = cur.parent {
+		if cte, ok := cur.ctes[name]; ok {
+			return cte, true
+		}
+	}
+	return nil, false
+}
+
+// lookup finds the column in sources of this scope, maybe is true if the column is not found but may be in a source
+// whose columns are unknown.
+func (s *scope) lookup(col *ast.ColumnName) (c scopeColumn, found, maybe bool, err error) {
+	var matches []scopeColumn
+	for _, src := range s.sources {
+		if col.Table.L != "" && (src.alias != col.Table.L || (col.Schema.L != "" && src.schema != col.Schema.L)) {
+			continue
+		}
+		if !src.known {
+			maybe = true
+			continue
+		}
+		for _, sc := range src.cols {
+			if sc.name == col.Name.L && (col.Table.L != "" || !src.merged[sc.name]) {
+				matches = append(matches, sc)
+			}
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return scopeColumn{}, false, maybe, nil
+	case 1:
+		return matches[0], true, false, nil
+	}
+	return scopeColumn{}, false, false, fmt.Errorf("column '%v' is ambiguous", col)
+}
+
+// lookupField finds the column in the output columns of this scope by its name or alias.
+func (s *scope) lookupField(col *ast.ColumnName) (scopeColumn, bool) {
+	if col.Table.L != "" {
+		return scopeColumn{}, false
+	}
+	for _, f := range s.fields {
+		if f.name == col.Name.L {
+			return f, true
+		}
+	}
+	return scopeColumn{}, false
+}
+
+// aliasMode is how to resolve a name which is both a select field alias and a column in the `FROM` clause.
+type aliasMode int
+
+const (
+	aliasNone  aliasMode = iota // aliases are not visible, e.g. in `WHERE`
+	aliasFirst                  // aliases first, e.g. in `ORDER BY`
+	aliasLast                   // columns in the `FROM` clause first, e.g. in `GROUP BY` and `HAVING`
+)
+
+func (r *columnResolver) resolveColumn(col *ast.ColumnName, s *scope, mode aliasMode) {
+	maybe := false
+	for cur := s; cur != nil; cur = cur.parent {
+		if cur == s && mode == aliasFirst {
+			if f, ok := cur.lookupField(col); ok {
+				r.resolved[col] = f.base
+				return
+			}
+		}
+		c, found, curMaybe, err := cur.lookup(col)
+		if err != nil {
+			r.errs = append(r.errs, err)
+			return
+		}
+		if found {
+			r.resolved[col] = c.base
+			return
+		}
+		if cur == s && mode == aliasLast {
+			if f, ok := cur.lookupField(col); ok {
+				r.resolved[col] = f.base
+				return
+			}
+		}
+		maybe = maybe || curMaybe
+	}
+	if !maybe {
+		r.errs = append(r.errs, fmt.Errorf("unknown column '%v'", col))
+	}
+}
+
+// resolveQuery resolves the query block and returns its output columns.
+func (r *columnResolver) resolveQuery(n ast.Node, parent *scope) []scopeColumn {
+	switch x := n.(type) {
+	case *ast.SelectStmt:
+		return r.resolveSelect(x, parent)
+	case *ast.SetOprStmt:
+		s := &scope{parent: parent}
+		r.resolveWith(x.With, s)
+		cols := r.resolveSetOprList(x.SelectList, s)
+		if x.OrderBy != nil { // `ORDER BY` of a union can only reference its output columns
+			os := &scope{parent: s, sources: []*scopeSource{{known: true, cols: cols}}}
+			for _, item := range x.OrderBy.Items {
+				r.resolveExpr(item.Expr, os, aliasNone)
+			}
+		}
+		return cols
+	case *ast.SetOprSelectList:
+		return r.resolveSetOprList(x, parent)
+	case *ast.SubqueryExpr:
+		return r.resolveQuery(x.Query, parent)
+	}
+	return nil
+}
+
+// resolveSetOprList resolves all branches of a union, output names are from the first branch, and base columns are
+// merged by positions.
+func (r *columnResolver) resolveSetOprList(list *ast.SetOprSelectList, parent *scope) []scopeColumn {
+	var cols []scopeColumn
+	for i, sel := range list.Selects {
+		selCols := r.resolveQuery(sel, parent)
+		if i == 0 {
+			cols = selCols
+			continue
+		}
+		for j := range cols {
+			if j < len(selCols) {
+				cols[j].base = append(append([]utils.Column(nil), cols[j].base...), selCols[j].base...)
+			}
+		}
+	}
+	return cols
+}
+
+func (r *columnResolver) resolveWith(with *ast.WithClause, s *scope) {
+	if with == nil {
+		return
+	}
+	s.ctes = make(map[string]*scopeSource)
+	for _, cte := range with.CTEs {
+		name := cte.Name.L
+		if with.IsRecursive { // a recursive CTE references itself, whose columns are from its seed part
+			seed := ast.Node(cte.Query.Query)
+			if setOpr, ok := seed.(*ast.SetOprStmt); ok && len(setOpr.SelectList.Selects) > 0 {
+				seed = setOpr.SelectList.Selects[0]
+			}
+			s.ctes[name] = &scopeSource{alias: name, known: true, cols: renameColumns(r.resolveQuery(seed, s), cte.ColNameList)}
+		}
+		cols := r.resolveQuery(cte.Query.Query, s)
+		s.ctes[name] = &scopeSource{alias: name, known: true, cols: renameColumns(cols, cte.ColNameList)}
+	}
+}
+
+func renameColumns(cols []scopeColumn, names []model.CIStr) []scopeColumn {
+	if len(names) == 0 {
+		return cols
+	}
+	renamed := make([]scopeColumn, len(cols))
+	for i, c := range cols {
+		renamed[i] = c
+		if i < len(names) {
+			renamed[i].name = names[i].L
+		}
+	}
+	return renamed
+}
+
+func (r *columnResolver) resolveSelect(sel *ast.SelectStmt, parent *scope) []scopeColumn {
+	s := &scope{parent: parent}
+	r.resolveWith(sel.With, s)
+	var onConditions []ast.ExprNode
+	if sel.From != nil {
+		s.sources = r.resolveTableRefs(sel.From.TableRefs, s, &onConditions)
+	}
+
+	if sel.Fields != nil {
+		for _, f := range sel.Fields.Fields {
+			if f.WildCard != nil { // `*` or `t.*`
+				for _, src := range s.sources {
+					if f.WildCard.Table.L != "" && (src.alias != f.WildCard.Table.L ||
+						(f.WildCard.Schema.L != "" && src.schema != f.WildCard.Schema.L)) {
+						continue
+					}
+					for _, c := range src.cols {
+						if f.WildCard.Table.L == "" && src.merged[c.name] {
+							continue // merged columns are output once
+						}
+						s.fields = append(s.fields, c)
+						r.expanded = append(r.expanded, c.base...)
+					}
+				}
+				continue
+			}
+			r.resolveExpr(f.Expr, s, aliasNone)
+			field := scopeColumn{name: f.AsName.L}
+			if c, ok := f.Expr.(*ast.ColumnNameExpr); ok {
+				if field.name == "" {
+					field.name = c.Name.Name.L
+				}
+				field.base = r.resolved[c.Name]
+			}
+			s.fields = append(s.fields, field)
+		}
+	}
+
+	for _, on := range onConditions {
+		r.resolveExpr(on, s, aliasNone)
+	}
+	if sel.Where != nil {
+		r.resolveExpr(sel.Where, s, aliasNone)
+	}
+	if sel.GroupBy != nil {
+		for _, item := range sel.GroupBy.Items {
+			r.resolveExpr(item.Expr, s, aliasLast)
+		}
+	}
+	if sel.Having != nil {
+		r.resolveExpr(sel.Having.Expr, s, aliasLast)
+	}
+	for i := range sel.WindowSpecs {
+		r.resolveExpr(&sel.WindowSpecs[i], s, aliasNone)
+	}
+	if sel.OrderBy != nil {
+		for _, item := range sel.OrderBy.Items {
+			r.resolveExpr(item.Expr, s, aliasFirst)
+		}
+	}
+	return s.fields
+}
+
+// resolveTableRefs returns the sources in the `FROM` clause, and collects `ON` conditions, which are resolved after
+// all sources are known.
+func (r *columnResolver) resolveTableRefs(n ast.ResultSetNode, s *scope, onConditions *[]ast.ExprNode) []*scopeSource {
+	switch x := n.(type) {
+	case *ast.Join:
+		left := r.resolveTableRefs(x.Left, s, onConditions)
+		if x.Right == nil {
+			return left
+		}
+		right := r.resolveTableRefs(x.Right, s, onConditions)
+		if x.On != nil {
+			*onConditions = append(*onConditions, x.On.Expr)
+		}
+		using := make(map[string]*ast.ColumnName)
+		for _, col := range x.Using {
+			using[col.Name.L] = col
+		}
+		if x.NaturalJoin {
+			for _, name := range commonColumnNames(left, right) {
+				using[name] = nil
+			}
+		}
+		for name, col := range using {
+			// `t1 join t2 using (a)` merges t2.a into t1.a, so `a` references t1.a without ambiguity
+			for _, src := range right {
+				if src.merged == nil {
+					src.merged = make(map[string]bool)
+				}
+				src.merged[name] = true
+			}
+			if col != nil {
+				leftScope := &scope{sources: left}
+				if c, found, _, err := leftScope.lookup(&ast.ColumnName{Name: col.Name}); err == nil && found {
+					r.resolved[col] = c.base
+				}
+			}
+		}
+		return append(left, right...)
+	case *ast.TableSource:
+		switch src := x.Source.(type) {
+		case *ast.TableName:
+			return []*scopeSource{r.tableSource(src, x.AsName.L, s)}
+		case *ast.SelectStmt, *ast.SetOprStmt: // derived tables can see CTEs and outer query blocks
+			cols := r.resolveQuery(src, &scope{parent: s.parent, ctes: s.ctes})
+			return []*scopeSource{{alias: x.AsName.L, known: true, cols: cols}}
+		case *ast.Join:
+			return r.resolveTableRefs(src, s, onConditions)
+		}
+	}
+	return nil
+}
+
+func (r *columnResolver) tableSource(tn *ast.TableName, alias string, s *scope) *scopeSource {
+	if alias == "" {
+		alias = tn.Name.L
+	}
+	if tn.Schema.L == "" {
+		if cte, ok := s.cte(tn.Name.L); ok {
+			return &scopeSource{alias: alias, known: true, cols: cte.cols}
+		}
+	}
+	src := &scopeSource{schema: tn.Schema.L, alias: alias}
+	if src.schema == "" {
+		src.schema = r.defaultSchema
+	}
+	name := utils.TableName{SchemaName: src.schema, TableName: tn.Name.L}
+	if v, ok := r.views.View(name); ok {
+		viewCols, err := r.views.ViewColumns(v)
+		if err != nil {
+			r.errs = append(r.errs, err)
+			return src
+		}
+		src.known = true
+		for _, c := range viewCols {
+			src.cols = append(src.cols, scopeColumn{name: c.Name, base: c.BaseColumns})
+		}
+	} else if t, ok := r.views.Table(name); ok {
+		src.known = true
+		for _, c := range t.Columns {
+			src.cols = append(src.cols, scopeColumn{name: c.ColumnName, base: []utils.Column{c}})
+		}
+	}
+	return src
+}
+
+// commonColumnNames returns the names of columns in both sides of a natural join.
+func commonColumnNames(left, right []*scopeSource) []string {
+	leftNames := make(map[string]bool)
+	for _, src := range left {
+		for _, c := range src.cols {
+			if !src.merged[c.name] {
+				leftNames[c.name] = true
+			}
+		}
+	}
+	var names []string
+	for _, src := range right {
+		for _, c := range src.cols {
+			if leftNames[c.name] && !src.merged[c.name] {
+				names = append(names, c.name)
+			}
+		}
+	}
+	return names
+}
+
+func (r *columnResolver) resolveExpr(n ast.Node, s *scope, mode aliasMode) {
+	n.Accept(&exprColumnResolver{r: r, s: s, mode: mode})
+}
+
+// exprColumnResolver resolves columns in an expression, subqueries in it are resolved as inner query blocks.
+type exprColumnResolver struct {
+	r    *columnResolver
+	s    *scope
+	mode aliasMode
+}
+
+func (v *exprColumnResolver) Enter(n ast.Node) (ast.Node, bool) {
+	switch x := n.(type) {
+	case *ast.SubqueryExpr:
+		v.r.resolveQuery(x.Query, v.s)
+		return n, true
+	case *ast.ColumnNameExpr:
+		v.r.resolveColumn(x.Name, v.s, v.mode)
+		return n, true
+	}
+	return n, false
+}
+
+func (v *exprColumnResolver) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
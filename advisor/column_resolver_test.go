@@ -0,0 +1,143 @@
+package advisor
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/qw4990/index_advisor/utils"
+)
+
+type columnNameCollector struct {
+	cols []*ast.ColumnName
+}
+
+func (c *columnNameCollector) Enter(n ast.Node) (ast.Node, bool) {
+	if x, ok := n.(*ast.ColumnNameExpr); ok {
+		c.cols = append(c.cols, x.Name)
+	}
+	return n, false
+}
+
+func (c *columnNameCollector) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+// resolveColumnsForTest returns `column->base columns` for each column in the query in order, and resolution errors.
+func resolveColumnsForTest(r *utils.ViewResolver, query string) ([]string, []error) {
+	stmt, err := utils.ParseOneSQL(query)
+	must(err)
+	resolver := newColumnResolver("test", r)
+	resolver.resolve(stmt)
+	c := new(columnNameCollector)
+	stmt.Accept(c)
+	var result []string
+	for _, col := range c.cols {
+		base, ok := resolver.baseColumns(col)
+		if !ok {
+			result = append(result, fmt.Sprintf("%v->?", col))
+			continue
+		}
+		var keys []string
+		for _, b := range base {
+			keys = append(keys, b.Key())
+		}
+		result = append(result, fmt.Sprintf("%v->%v", col, strings.Join(keys, "|")))
+	}
+	return result, resolver.errs
+}
+
+func TestColumnResolver(t *testing.T) {
+	var tables []utils.TableSchema
+	for _, sql := range []string{
+		"create table t1 (id int, a int, b int)",
+		"create table t2 (id int, a int, c int)",
+		"create table t3 (id int, d int)",
+	} {
+		tbl, err := utils.ParseCreateTableStmt("test", sql)
+		must(err)
+		tables = append(tables, tbl)
+	}
+	v1, err := utils.ParseCreateViewStmt("test", "create view v1 as select id as vid, a + 1 as va from t1")
+	must(err)
+	r := utils.NewViewResolver(utils.ListToSet(tables...), utils.ListToSet(v1))
+
+	for _, c := range []struct {
+		query    string
+		expected string
+	}{
+		{ // aliases
+			"select * from t1 x join t2 y on x.id = y.id where x.a = 1 and c = 2",
+			"x.id->test.t1.id y.id->test.t2.id x.a->test.t1.a c->test.t2.c"},
+		{ // the same table twice
+			"select * from t1, t1 as tt where t1.a = tt.b and test.t1.b = 1",
+			"t1.a->test.t1.a tt.b->test.t1.b test.t1.b->test.t1.b"},
+		{ // derived tables
+			"select x, y from (select a as x, b + 1 as y from t1 where id > 1) dt where x = 1 order by y",
+			"x->test.t1.a y-> a->test.t1.a b->test.t1.b id->test.t1.id x->test.t1.a y->"},
+		{ // correlated subqueries and subquery scopes
+			"select * from t1 where a in (select a from t2 where t2.id = t1.id and b > 1) and exists (select 1 from t3 where d = t1.b)",
+			"a->test.t1.a a->test.t2.a t2.id->test.t2.id t1.id->test.t1.id b->test.t1.b d->test.t3.d t1.b->test.t1.b"},
+		{ // CTEs with column lists
+			"with c1 (x, y) as (select id, a from t1), c2 as (select x as z from c1 where y > 1) select * from c2 where z = 1",
+			"id->test.t1.id a->test.t1.a x->test.t1.id y->test.t1.a z->test.t1.id"},
+		{ // recursive CTEs
+			"with recursive c (n) as (select id from t1 union all select n + 1 from c where n < 10) select * from c where n = 1",
+			"id->test.t1.id n->test.t1.id n->test.t1.id n->test.t1.id"},
+		{ // USING and NATURAL joins
+			"select id, a, b, c from t1 join t2 using (id, a) where id = 1",
+			"id->test.t1.id a->test.t1.a b->test.t1.b c->test.t2.c id->test.t1.id"},
+		{
+			"select * from t1 natural join t2 where id = 1 and t2.a = 2",
+			"id->test.t1.id t2.a->test.t2.a"},
+		{ // views
+			"select * from v1 join t3 on vid = t3.id where va > 1",
+			"vid->test.t1.id t3.id->test.t3.id va->"},
+		{ // aliases in GROUP BY, HAVING and ORDER BY
+			"select a as k, count(*) as cnt from t1 group by k having cnt > 1 order by a",
+			"a->test.t1.a k->test.t1.a cnt-> a->test.t1.a"},
+		{ // unions
+			"select a from t1 union select c from t2 order by a",
+			"a->test.t1.a c->test.t2.c a->test.t1.a|test.t2.c"},
+		{ // unknown tables don't cause errors
+			"select * from t1 join unknown_table u on t1.id = u.id where x = 1",
+			"t1.id->test.t1.id u.id->? x->?"},
+	} {
+		got, errs := resolveColumnsForTest(r, c.query)
+		if len(errs) > 0 {
+			t.Fatalf("%v: unexpected errors %v", c.query, errs)
+		}
+		if strings.Join(got, " ") != c.expected {
+			t.Fatalf("%v:\nexpected %v\ngot      %v", c.query, c.expected, strings.Join(got, " "))
+		}
+	}
+}
+
+func TestColumnResolverAmbiguous(t *testing.T) {
+	t1, err := utils.ParseCreateTableStmt("test", "create table t1 (id int, a int)")
+	must(err)
+	t2, err := utils.ParseCreateTableStmt("test", "create table t2 (id int, a int)")
+	must(err)
+	r := utils.NewViewResolver(utils.ListToSet(t1, t2), nil)
+
+	for _, c := range []struct {
+		query    string
+		expected string
+	}{
+		{"select * from t1, t2 where id = 1", "id->?"},
+		{"select * from t1 join t2 on t1.id = t2.id where a = 1", "t1.id->test.t1.id t2.id->test.t2.id a->?"},
+		{"select * from t1 x, t2 x where x.a = 1", "x.a->?"},
+		{"select * from (select t1.id, t2.id from t1, t2) dt where dt.id = 1", "t1.id->test.t1.id t2.id->test.t2.id dt.id->?"},
+		{"select * from t1 join t2 using (id) where a = 1", "a->?"},
+		{"select * from t1 where no_such_column = 1", "no_such_column->?"},
+	} {
+		got, errs := resolveColumnsForTest(r, c.query)
+		if len(errs) != 1 {
+			t.Fatalf("%v: expect an error, got %v", c.query, errs)
+		}
+		if strings.Join(got, " ") != c.expected {
+			t.Fatalf("%v:\nexpected %v\ngot      %v", c.query, c.expected, strings.Join(got, " "))
+		}
+	}
+}
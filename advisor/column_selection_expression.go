@@ -0,0 +1,298 @@
+package advisor
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/parser/opcode"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+	"github.com/qw4990/index_advisor/optimizer"
+	"github.com/qw4990/index_advisor/utils"
+)
+
+// multiValuedCharLength is the length of strings in multi-valued indexes on JSON arrays of strings.
+const multiValuedCharLength = 64
+
+// expressionPredicateVisitor finds expressions on columns in predicates like `lower(email) = ?`,
+// `date(created_at) > ?` and `json_extract(doc, '$.k') in (?, ?)`, which can only use expression indexes, and JSON
+// arrays in predicates like `json_contains(doc->'$.tags', '[1, 2]')` and `json_overlaps(tags, '["a"]')`, which can
+// only use multi-valued indexes. `1 MEMBER OF (tags)` is not supported by the SQL parser yet, queries using it are
+// rejected or skipped with warnings by utils.ParseOneSQL and its callers, see utils.UsesMemberOf.
+type expressionPredicateVisitor struct {
+	resolver *columnResolver
+	parts    []utils.Column // expression key parts
+	errs     []error
+}
+
+func (v *expressionPredicateVisitor) Enter(n ast.Node) (node ast.Node, skipChildren bool) {
+	switch x := n.(type) {
+	case *ast.BinaryOperationExpr: // {expr} op ?
+		switch x.Op {
+		case opcode.EQ, opcode.NullEQ, opcode.LT, opcode.LE, opcode.GT, opcode.GE:
+			if isConstExpr(x.R) {
+				v.collectExpression(x.L)
+			} else if isConstExpr(x.L) {
+				v.collectExpression(x.R)
+			}
+		}
+	case *ast.PatternInExpr: // {expr} in (?, ?, ...)
+		if x.Sel == nil && isConstExprs(x.List) {
+			v.collectExpression(x.Expr)
+		}
+	case *ast.BetweenExpr: // {expr} between ? and ?
+		if isConstExpr(x.Left) && isConstExpr(x.Right) {
+			v.collectExpression(x.Expr)
+		}
+	case *ast.FuncCallExpr:
+		switch {
+		case x.FnName.L == ast.JSONContains && len(x.Args) == 2: // json_contains({array}, ?)
+			v.collectJSONArray(x.Args[0], x.Args[1])
+		case x.FnName.L == "json_overlaps" && len(x.Args) == 2: // json_overlaps({array}, ?)
+			v.collectJSONArray(x.Args[0], x.Args[1])
+			v.collectJSONArray(x.Args[1], x.Args[0])
+		}
+	}
+	return n, false
+}
+
+func (v *expressionPredicateVisitor) Leave(n ast.Node) (node ast.Node, ok bool) {
+	return n, true
+}
+
+// collectExpression collects the expression if it's a function on exactly one base column, the column should be
+// referenced by its base name since the expression is restored as the key part.
+func (v *expressionPredicateVisitor) collectExpression(expr ast.ExprNode) {
+	for {
+		p, ok := expr.(*ast.ParenthesesExpr)
+		if !ok {
+			break
+		}
+		expr = p.Expr
+	}
+	switch expr.(type) {
+	case *ast.FuncCallExpr, *ast.FuncCastExpr:
+	default:
+		return
+	}
+	c := &expressionColumnCollector{}
+	expr.Accept(c)
+	if len(c.cols) == 0 || c.hasSubquery {
+		return
+	}
+	var base utils.Column
+	for i, col := range c.cols {
+		cols, ok := v.resolver.baseColumns(col)
+		if !ok || len(cols) != 1 || cols[0].ColumnName != col.Name.L || (i > 0 && cols[0].Key() != base.Key()) {
+			return
+		}
+		base = cols[0]
+	}
+	text, _, ok, err := utils.IndexExpression(expr)
+	if err != nil {
+		v.errs = append(v.errs, err)
+		return
+	}
+	if !ok {
+		return
+	}
+	part := utils.NewExpressionColumn(base.SchemaName, base.TableName, base.ColumnName, text)
+	if !containsColumn(v.parts, part) {
+		v.parts = append(v.parts, part)
+	}
+}
+
+// collectJSONArray collects a multi-valued key part like `cast(json_extract(doc, '$.tags') as signed array)` if the
+// array is a JSON column or a path of it, and the values are a JSON constant of numbers or strings.
+func (v *expressionPredicateVisitor) collectJSONArray(array, values ast.ExprNode) {
+	tp, ok := jsonArrayCastType(values)
+	if !ok {
+		return
+	}
+	for {
+		p, ok := array.(*ast.ParenthesesExpr)
+		if !ok {
+			break
+		}
+		array = p.Expr
+	}
+	switch x := array.(type) {
+	case *ast.ColumnNameExpr:
+	case *ast.FuncCallExpr: // `json_extract(doc, '$.tags')` or `doc->'$.tags'`
+		if x.FnName.L != ast.JSONExtract || len(x.Args) != 2 || !isConstExpr(x.Args[1]) {
+			return
+		}
+		if _, ok := x.Args[0].(*ast.ColumnNameExpr); !ok {
+			return
+		}
+	default:
+		return
+	}
+	c := &expressionColumnCollector{}
+	array.Accept(c)
+	if len(c.cols) != 1 {
+		return
+	}
+	cols, ok := v.resolver.baseColumns(c.cols[0])
+	if !ok || len(cols) != 1 || cols[0].ColumnName != c.cols[0].Name.L ||
+		cols[0].ColumnType == nil || cols[0].ColumnType.Tp != mysql.TypeJSON {
+		return
+	}
+	text, _, ok, err := utils.IndexExpression(array)
+	if err != nil {
+		v.errs = append(v.errs, err)
+		return
+	}
+	if !ok {
+		return
+	}
+	part := utils.NewExpressionColumn(cols[0].SchemaName, cols[0].TableName, cols[0].ColumnName,
+		fmt.Sprintf("cast(%v as %v array)", text, tp))
+	if !containsColumn(v.parts, part) {
+		v.parts = append(v.parts, part)
+	}
+}
+
+// jsonArrayCastType returns the type to cast values of a multi-valued index to, according to the JSON constant which
+// should be a number, a string, or an array of them in the same type.
+func jsonArrayCastType(values ast.ExprNode) (string, bool) {
+	val, ok := values.(*driver.ValueExpr)
+	if !ok {
+		return "", false
+	}
+	var doc interface{}
+	if err := json.Unmarshal([]byte(val.GetString()), &doc); err != nil {
+		return "", false
+	}
+	items, isArray := doc.([]interface{})
+	if !isArray {
+		items = []interface{}{doc}
+	}
+	tp := ""
+	for _, item := range items {
+		var itemType string
+		switch x := item.(type) {
+		case float64:
+			itemType = "signed"
+			if x != math.Trunc(x) {
+				itemType = "double"
+			}
+		case string:
+			itemType = fmt.Sprintf("char(%v)", multiValuedCharLength)
+		default:
+			return "", false
+		}
+		if tp == "" || tp == "signed" && itemType == "double" {
+			tp = itemType
+		} else if tp != itemType && !(tp == "double" && itemType == "signed") {
+			return "", false
+		}
+	}
+	return tp, tp != ""
+}
+
+// isMultiValuedPart returns whether the key part is a multi-valued one like `cast(tags as signed array)`.
+func isMultiValuedPart(part utils.Column) bool {
+	return strings.HasSuffix(part.Expression, " array)")
+}
+
+// expressionColumnCollector collects column references in an expression.
+type expressionColumnCollector struct {
+	cols        []*ast.ColumnName
+	hasSubquery bool
+}
+
+func (c *expressionColumnCollector) Enter(n ast.Node) (node ast.Node, skipChildren bool) {
+	switch x := n.(type) {
+	case *ast.ColumnNameExpr:
+		c.cols = append(c.cols, x.Name)
+	case *ast.SubqueryExpr:
+		c.hasSubquery = true
+		return n, true
+	}
+	return n, false
+}
+
+func (c *expressionColumnCollector) Leave(n ast.Node) (node ast.Node, ok bool) {
+	return n, true
+}
+
+func isConstExprs(exprs []ast.ExprNode) bool {
+	for _, expr := range exprs {
+		if !isConstExpr(expr) {
+			return false
+		}
+	}
+	return true
+}
+
+// collectExpressionParts returns expression key parts used by predicates of the statement.
+func collectExpressionParts(stmt ast.StmtNode, defaultSchema string, views *utils.ViewResolver) ([]utils.Column, error) {
+	v := &expressionPredicateVisitor{resolver: newColumnResolver(defaultSchema, views)}
+	v.resolver.resolve(stmt)
+	stmt.Accept(v)
+	if len(v.errs) > 0 {
+		return nil, v.errs[0]
+	}
+	return v.parts, nil
+}
+
+// ExpressionCandidatesSelection generates expression index candidates like `(lower(email))` for predicates on
+// expressions and multi-valued index candidates like `(cast(tags as signed array))` for predicates on JSON arrays,
+// adds them to the workload's candidate indexes, and adds their key parts to indexable columns of queries using them.
+// Candidates are created through the what-if optimizer once to discard the ones it doesn't support, e.g. expressions
+// returning JSON values, or multi-valued indexes on TiDB versions without them.
+func ExpressionCandidatesSelection(workloadInfo *utils.WorkloadInfo, op optimizer.WhatIfOptimizer) error {
+	views := utils.NewViewResolver(workloadInfo.TableSchemas, workloadInfo.Views)
+	parts := utils.NewSet[utils.Column]()
+	queries := workloadInfo.Queries.ToList()
+	queryParts := make([][]utils.Column, len(queries))
+	for i, q := range queries {
+		stmt, err := utils.ParseOneSQL(q.Text)
+		if err != nil {
+			return err
+		}
+		if queryParts[i], err = collectExpressionParts(stmt, q.SchemaName, views); err != nil {
+			return err
+		}
+		for _, part := range queryParts[i] {
+			utils.Debugf("query %v: expression key part %v", q.Alias, part.Key())
+			parts.Add(part)
+		}
+	}
+
+	if workloadInfo.CandidateIndexes == nil {
+		workloadInfo.CandidateIndexes = utils.NewSet[utils.Index]()
+	}
+	for _, part := range parts.ToList() {
+		idx := utils.NewIndexWithColumns(tempIndexName(part), part)
+		if err := op.CreateHypoIndex(idx); err != nil {
+			if isMultiValuedPart(part) {
+				utils.Warningf("discard the multi-valued index candidate %v, multi-valued indexes need TiDB v6.6 or later: %v", idx.Key(), err)
+			} else {
+				utils.Warningf("discard the expression index candidate %v: %v", idx.Key(), err)
+			}
+			parts.Remove(part)
+			continue
+		}
+		if err := op.DropHypoIndex(idx); err != nil {
+			return err
+		}
+		workloadInfo.CandidateIndexes.Add(idx)
+	}
+	for i, q := range queries {
+		if q.IndexableColumns == nil {
+			q.IndexableColumns = utils.NewSet[utils.Column]()
+		}
+		for _, part := range queryParts[i] {
+			if parts.Contains(part) {
+				q.IndexableColumns.Add(part)
+			}
+		}
+		workloadInfo.Queries.Add(q)
+	}
+	return nil
+}
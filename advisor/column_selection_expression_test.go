@@ -0,0 +1,66 @@
+package advisor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/qw4990/index_advisor/utils"
+)
+
+func TestCollectExpressionParts(t *testing.T) {
+	tt, err := utils.ParseCreateTableStmt("test", "create table t (a int, b int, email varchar(64), created_at datetime, doc json)")
+	must(err)
+	v, err := utils.ParseCreateViewStmt("test", "create view v (x, mail) as select a, email from t")
+	must(err)
+	views := utils.NewViewResolver(utils.ListToSet(tt), utils.ListToSet(v))
+
+	cases := []struct {
+		sql   string
+		parts string
+	}{
+		{"select * from t where lower(email) = 'a' and date(t.created_at) between '2023-01-01' and '2023-01-31'",
+			"test.t.(lower(`email`)) test.t.(date(`created_at`))"},
+		{"select * from t x where json_extract(x.doc, '$.k') in (1, 2) or 'v' = (upper(email))",
+			"test.t.(json_extract(`doc`, '$.k')) test.t.(upper(`email`))"},
+		{"select * from t where a in (select a from t where abs(b) > 1)", "test.t.(abs(`b`))"},
+		{"select * from t where lower(email) = lower('A')", "test.t.(lower(`email`))"},
+		{"select * from t where a + 1 = 2 and lower(email) = upper(email) and abs(a - b) = 1", ""},
+		{"select * from v where lower(v.mail) = 'a'", ""}, // columns renamed by views
+		// multi-valued key parts
+		{"select * from t where json_contains(doc->'$.tags', '[1, 2]') and json_overlaps('[\"a\", \"b\"]', (doc))",
+			"test.t.(cast(json_extract(`doc`, '$.tags') as signed array)) test.t.(cast(`doc` as char(64) array))"},
+		{"select * from t where json_contains(doc, '1.5') or json_overlaps(json_extract(doc, '$.a'), '[1, 2.5]')",
+			"test.t.(cast(`doc` as double array)) test.t.(cast(json_extract(`doc`, '$.a') as double array))"},
+		// `1 member of (doc->'$.tags')` can't be parsed, the equivalent json_contains is used instead
+		{"select * from t where json_contains(doc->'$.tags', '1')",
+			"test.t.(cast(json_extract(`doc`, '$.tags') as signed array))"},
+		{"select * from t where json_contains(email, '[1]') or json_contains(doc, '[1, \"a\"]') or json_contains(doc, '{}') or json_contains(doc, '[1]', '$.a')", ""},
+	}
+	for _, c := range cases {
+		stmt, err := utils.ParseOneSQL(c.sql)
+		must(err)
+		parts, err := collectExpressionParts(stmt, "test", views)
+		must(err)
+		var keys []string
+		for _, p := range parts {
+			keys = append(keys, p.Key())
+		}
+		if strings.Join(keys, " ") != c.parts {
+			t.Fatalf("unexpected expression key parts for %v: %v, expected %v", c.sql, keys, c.parts)
+		}
+	}
+
+	for _, sql := range []string{"select * from t where 1 member of (doc->'$.tags')", "select * from t where 'a' MEMBER  OF(doc)"} {
+		if _, err := utils.ParseOneSQL(sql); err == nil || !utils.UsesMemberOf(sql) || !strings.Contains(err.Error(), "MEMBER OF is not supported") {
+			t.Fatalf("expected an error about MEMBER OF for %v, got %v", sql, err)
+		}
+	}
+
+	part := utils.NewExpressionColumn("test", "t", "doc", "cast(json_extract(`doc`, '$.k') as char(32))")
+	if name := tempIndexName(utils.NewColumn("test", "t", "a"), part); name != "idx_a_cast_json_extract_doc_k_as_char_32" {
+		t.Fatalf("unexpected index name: %v", name)
+	}
+	if isMultiValuedPart(part) || !isMultiValuedPart(utils.NewExpressionColumn("test", "t", "doc", "cast(`doc` as signed array)")) {
+		t.Fatalf("unexpected multi-valued key parts")
+	}
+}
@@ -0,0 +1,459 @@
+package advisor
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/opcode"
+	"github.com/qw4990/index_advisor/optimizer"
+	"github.com/qw4990/index_advisor/utils"
+)
+
+// joinCandidatesPerQuery is the max number of composite join candidates kept for each query.
+const joinCandidatesPerQuery = 3
+
+// IndexableColumnsSelectionJoin finds indexable columns like IndexableColumnsSelectionSimple, and generates composite
+// candidate indexes for joins: for each join between table T and table O, the candidate on T consists of the join
+// keys of T and columns of T in local equality filters, so that T can be the inner side of an IndexJoin.
+// Candidates of each query are ranked by the join shapes in its current plan, and only the best ones are kept.
+func IndexableColumnsSelectionJoin(workloadInfo *utils.WorkloadInfo, op optimizer.WhatIfOptimizer) error {
+	if err := IndexableColumnsSelectionSimple(workloadInfo); err != nil {
+		return err
+	}
+	views := utils.NewViewResolver(workloadInfo.TableSchemas, workloadInfo.Views)
+	candidates := utils.NewSet[utils.Index]()
+	for _, q := range workloadInfo.Queries.ToList() {
+		stmt, err := utils.ParseOneSQL(q.Text)
+		if err != nil {
+			return err
+		}
+		joinCandidates, aliases := collectJoinCandidates(stmt, q.SchemaName, views)
+		if len(joinCandidates) == 0 {
+			continue
+		}
+		plan, err := op.ExplainQ(q)
+		if err != nil {
+			utils.Warningf("failed to explain query %v, its join candidates are not ranked: %v", q.Alias, err)
+		} else {
+			rankJoinCandidates(joinCandidates, plan, aliases)
+		}
+		for i, c := range joinCandidates {
+			if i >= joinCandidatesPerQuery {
+				break
+			}
+			utils.Debugf("query %v: join candidate %v, shape %v, outer rows %v", q.Alias, c.index.Key(), c.shape, c.outerRows)
+			candidates.Add(c.index)
+		}
+	}
+	workloadInfo.CandidateIndexes = candidates
+	return nil
+}
+
+// joinShape is how a join between the inner table and the outer table is executed in the current plan.
+type joinShape int
+
+const (
+	joinShapeIndexJoin joinShape = iota // the inner table is already the inner side of an IndexJoin
+	joinShapeProbe                      // the inner table is the larger side of a HashJoin or MergeJoin
+	joinShapeBuild                      // the inner table is the smaller side, or the outer side of an IndexJoin
+	joinShapeUnknown                    // the join is not found in the plan, e.g. it's rewritten by the optimizer
+)
+
+func (s joinShape) String() string {
+	switch s {
+	case joinShapeIndexJoin:
+		return "index-join"
+	case joinShapeProbe:
+		return "probe"
+	case joinShapeBuild:
+		return "build"
+	}
+	return "unknown"
+}
+
+// joinCandidate is a composite candidate index on the inner table of a join.
+type joinCandidate struct {
+	inner, outer utils.TableName
+	innerKeys    []utils.Column // join keys of the inner table
+	outerKeys    []utils.Column // join keys of the outer table, outerKeys[i] = innerKeys[i]
+	index        utils.Index    // join keys + columns in local equality filters
+
+	shape     joinShape
+	outerRows float64 // estimated rows of the outer side, -1 if unknown
+}
+
+// joinPredicate is an equality predicate between columns of two different tables, like `a.x = b.y`.
+type joinPredicate struct {
+	left, right utils.Column
+}
+
+// joinPredicateVisitor classifies predicates in `WHERE` and `ON` clauses of each query block as local filters or join
+// predicates, and generates join candidates for each query block.
+type joinPredicateVisitor struct {
+	resolver   *columnResolver
+	candidates []joinCandidate
+}
+
+// collectJoinCandidates returns join candidates of the statement, and aliases of tables in it.
+func collectJoinCandidates(stmt ast.StmtNode, defaultSchema string, views *utils.ViewResolver) ([]joinCandidate, map[string]string) {
+	v := &joinPredicateVisitor{resolver: newColumnResolver(defaultSchema, views)}
+	v.resolver.resolve(stmt)
+	stmt.Accept(v)
+	return v.candidates, collectTableAliases(stmt)
+}
+
+func (v *joinPredicateVisitor) Enter(n ast.Node) (node ast.Node, skipChildren bool) {
+	switch x := n.(type) {
+	case *ast.SelectStmt:
+		var filters, ons []ast.ExprNode // ON conditions of outer joins only contribute join predicates
+		if x.Where != nil {
+			filters = utils.FlattenCNF(x.Where)
+		}
+		if x.From != nil {
+			filters, ons = collectOnConditions(x.From.TableRefs, filters, ons)
+		}
+		v.collectBlock(filters, ons)
+	}
+	return n, false
+}
+
+func (v *joinPredicateVisitor) Leave(n ast.Node) (node ast.Node, ok bool) {
+	return n, true
+}
+
+func collectOnConditions(n ast.ResultSetNode, filters, ons []ast.ExprNode) ([]ast.ExprNode, []ast.ExprNode) {
+	switch x := n.(type) {
+	case *ast.Join:
+		filters, ons = collectOnConditions(x.Left, filters, ons)
+		if x.Right != nil {
+			filters, ons = collectOnConditions(x.Right, filters, ons)
+		}
+		if x.On != nil {
+			if x.Tp == ast.LeftJoin || x.Tp == ast.RightJoin {
+				ons = append(ons, utils.FlattenCNF(x.On.Expr)...)
+			} else {
+				filters = append(filters, utils.FlattenCNF(x.On.Expr)...)
+			}
+		}
+	case *ast.TableSource:
+		if j, ok := x.Source.(*ast.Join); ok {
+			return collectOnConditions(j, filters, ons)
+		}
+	}
+	return filters, ons
+}
+
+// collectBlock generates join candidates from predicates of a query block.
+func (v *joinPredicateVisitor) collectBlock(filters, ons []ast.ExprNode) {
+	var joins []joinPredicate
+	eqFilters := make(map[string][]utils.Column) // table key -> columns in local equality filters
+	for i, expr := range append(filters, ons...) {
+		if p, ok := v.joinPredicate(expr); ok {
+			joins = append(joins, p)
+			continue
+		}
+		if i >= len(filters) {
+			continue
+		}
+		if p, ok := classifyPredicate(v.resolver, expr); ok && (p.tp == predicateEQ || p.tp == predicateIN) {
+			t := columnTable(p.col).Key()
+			if !containsColumn(eqFilters[t], p.col) {
+				eqFilters[t] = append(eqFilters[t], p.col)
+			}
+		}
+	}
+
+	// group join keys by (inner, outer) table pairs, both sides can be the inner side of an IndexJoin
+	var pairs []string
+	keys := make(map[string]*joinCandidate)
+	for _, p := range joins {
+		for _, side := range [][2]utils.Column{{p.left, p.right}, {p.right, p.left}} {
+			inner, outer := side[0], side[1]
+			pair := columnTable(inner).Key() + "|" + columnTable(outer).Key()
+			c, ok := keys[pair]
+			if !ok {
+				c = &joinCandidate{inner: columnTable(inner), outer: columnTable(outer)}
+				keys[pair] = c
+				pairs = append(pairs, pair)
+			}
+			if !containsColumn(c.innerKeys, inner) {
+				c.innerKeys = append(c.innerKeys, inner)
+				c.outerKeys = append(c.outerKeys, outer)
+			}
+		}
+	}
+	for _, pair := range pairs {
+		c := keys[pair]
+		cols := append([]utils.Column{}, c.innerKeys...)
+		for _, col := range eqFilters[c.inner.Key()] {
+			if !containsColumn(cols, col) {
+				cols = append(cols, col)
+			}
+		}
+		if len(cols) < 2 { // single-column candidates are generated from indexable columns already
+			continue
+		}
+		c.index = utils.NewIndexWithColumns(tempIndexName(cols...), cols...)
+		c.shape, c.outerRows = joinShapeUnknown, -1
+		v.candidates = append(v.candidates, *c)
+	}
+}
+
+// joinPredicate returns the predicate if it's like `a.x = b.y` where `a` and `b` are different tables.
+// Predicates between columns of the same table, including self-joins, are ignored.
+func (v *joinPredicateVisitor) joinPredicate(expr ast.ExprNode) (joinPredicate, bool) {
+	op, ok := expr.(*ast.BinaryOperationExpr)
+	if !ok || op.Op != opcode.EQ {
+		return joinPredicate{}, false
+	}
+	l, lok := v.resolver.indexableColumn(op.L)
+	r, rok := v.resolver.indexableColumn(op.R)
+	if !lok || !rok || columnTable(l).Key() == columnTable(r).Key() {
+		return joinPredicate{}, false
+	}
+	return joinPredicate{left: l, right: r}, true
+}
+
+// constExprChecker checks whether an expression references no column and no subquery.
+type constExprChecker struct {
+	isConst bool
+}
+
+func (c *constExprChecker) Enter(n ast.Node) (node ast.Node, skipChildren bool) {
+	switch n.(type) {
+	case *ast.ColumnNameExpr, *ast.SubqueryExpr, *ast.DefaultExpr:
+		c.isConst = false
+		return n, true
+	}
+	return n, false
+}
+
+func (c *constExprChecker) Leave(n ast.Node) (node ast.Node, ok bool) {
+	return n, true
+}
+
+func isConstExpr(expr ast.ExprNode) bool {
+	c := &constExprChecker{isConst: true}
+	expr.Accept(c)
+	return c.isConst
+}
+
+func columnTable(c utils.Column) utils.TableName {
+	return utils.TableName{SchemaName: c.SchemaName, TableName: c.TableName}
+}
+
+func containsColumn(cols []utils.Column, col utils.Column) bool {
+	for _, c := range cols {
+		if c.Key() == col.Key() {
+			return true
+		}
+	}
+	return false
+}
+
+// tableAliasCollector collects aliases of tables in a statement.
+type tableAliasCollector struct {
+	aliases map[string]string // table alias -> table name, to match tables in the plan
+}
+
+func (c *tableAliasCollector) Enter(n ast.Node) (node ast.Node, skipChildren bool) {
+	if x, ok := n.(*ast.TableSource); ok {
+		if tn, ok := x.Source.(*ast.TableName); ok && x.AsName.L != "" {
+			c.aliases[x.AsName.L] = tn.Name.L
+		}
+	}
+	return n, false
+}
+
+func (c *tableAliasCollector) Leave(n ast.Node) (node ast.Node, ok bool) {
+	return n, true
+}
+
+func collectTableAliases(stmt ast.StmtNode) map[string]string {
+	c := &tableAliasCollector{aliases: make(map[string]string)}
+	stmt.Accept(c)
+	return c.aliases
+}
+
+// planOperator is an operator in the plan.
+type planOperator struct {
+	name         string // e.g. `HashJoin_29`, without the tree prefix and the `(Build)` or `(Probe)` suffix
+	depth        int    // the length of the tree prefix
+	rows         float64
+	accessObject string
+	info         string
+}
+
+// planTree is a plan parsed from the result of `explain format='verbose'`.
+type planTree []planOperator
+
+func parsePlanTree(plan utils.Plan) planTree {
+	var tree planTree
+	for _, row := range plan {
+		if len(row) < 6 {
+			continue
+		}
+		id := []rune(row[0])
+		depth := 0
+		for depth < len(id) && strings.ContainsRune("│├└─ ", id[depth]) {
+			depth++
+		}
+		name := string(id[depth:])
+		if i := strings.Index(name, "("); i >= 0 {
+			name = name[:i]
+		}
+		rows, _ := strconv.ParseFloat(row[1], 64)
+		tree = append(tree, planOperator{name: name, depth: depth, rows: rows, accessObject: row[4], info: row[5]})
+	}
+	return tree
+}
+
+// subtreeEnd returns the end (exclusive) of the subtree rooted at the i-th operator.
+func (t planTree) subtreeEnd(i int) int {
+	j := i + 1
+	for j < len(t) && t[j].depth > t[i].depth {
+		j++
+	}
+	return j
+}
+
+// children returns the children of the i-th operator.
+func (t planTree) children(i int) []int {
+	var children []int
+	for j := i + 1; j < t.subtreeEnd(i); j = t.subtreeEnd(j) {
+		children = append(children, j)
+	}
+	return children
+}
+
+// containsTable returns whether the subtree rooted at the i-th operator accesses the table.
+func (t planTree) containsTable(i int, table string, aliases map[string]string) bool {
+	for j := i; j < t.subtreeEnd(i); j++ {
+		obj := t[j].accessObject
+		if !strings.HasPrefix(obj, "table:") {
+			continue
+		}
+		name := strings.TrimPrefix(obj, "table:")
+		if k := strings.Index(name, ","); k >= 0 {
+			name = name[:k]
+		}
+		name = strings.ToLower(name)
+		if alias, ok := aliases[name]; ok {
+			name = alias
+		}
+		if name == table {
+			return true
+		}
+	}
+	return false
+}
+
+// readers returns types of root operators which read the table, e.g. `IndexLookUp`, `IndexReader` and `TableReader`.
+func (t planTree) readers(table string, aliases map[string]string) utils.Set[planReader] {
+	readers := utils.NewSet[planReader]()
+	for i, op := range t {
+		tp := op.name
+		if k := strings.Index(tp, "_"); k >= 0 {
+			tp = tp[:k]
+		}
+		switch tp {
+		case "IndexLookUp", "IndexReader", "TableReader", "IndexMerge":
+			if t.containsTable(i, table, aliases) {
+				readers.Add(planReader(tp))
+			}
+		}
+	}
+	return readers
+}
+
+// planReader is the type of an operator which reads a table, e.g. `IndexLookUp`.
+type planReader string
+
+// Key returns the key of the reader.
+func (r planReader) Key() string {
+	return string(r)
+}
+
+var planEQCondRegexp = regexp.MustCompile(`eq\(([\w$.]+), ([\w$.]+)\)`)
+
+// planColumnKey returns the key of a column in the plan like `test.x.d1`, whose table is qualified by its alias if any.
+func planColumnKey(col string, aliases map[string]string) string {
+	parts := strings.Split(strings.ToLower(col), ".")
+	if len(parts) == 3 {
+		if table, ok := aliases[parts[1]]; ok {
+			parts[1] = table
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// joinShape returns the shape of the join between the candidate's tables at the i-th operator, ok is false if the
+// operator is not such a join.
+func (t planTree) joinShape(i int, c joinCandidate, aliases map[string]string) (shape joinShape, outerRows float64, ok bool) {
+	op := t[i]
+	if !strings.Contains(op.name, "Join") {
+		return 0, 0, false
+	}
+	matched := false
+	for _, m := range planEQCondRegexp.FindAllStringSubmatch(op.info, -1) {
+		l, r := planColumnKey(m[1], aliases), planColumnKey(m[2], aliases)
+		for k := range c.innerKeys {
+			in, out := c.innerKeys[k].Key(), c.outerKeys[k].Key()
+			if (l == in && r == out) || (l == out && r == in) {
+				matched = true
+			}
+		}
+	}
+	children := t.children(i)
+	if !matched || len(children) != 2 {
+		return 0, 0, false
+	}
+	innerChild, outerChild := children[0], children[1]
+	if !t.containsTable(innerChild, c.inner.TableName, aliases) {
+		innerChild, outerChild = outerChild, innerChild
+	}
+	if !t.containsTable(innerChild, c.inner.TableName, aliases) {
+		return 0, 0, false
+	}
+	outerRows = t[outerChild].rows
+	if strings.HasPrefix(op.name, "Index") { // IndexJoin, IndexHashJoin and IndexMergeJoin
+		if strings.Contains(op.info, fmt.Sprintf("inner:%v,", t[innerChild].name)) {
+			return joinShapeIndexJoin, outerRows, true
+		}
+		return joinShapeBuild, outerRows, true
+	}
+	if t[innerChild].rows >= t[outerChild].rows {
+		return joinShapeProbe, outerRows, true
+	}
+	return joinShapeBuild, outerRows, true
+}
+
+// rankJoinCandidates sorts candidates by how likely they are used as the inner side of an IndexJoin: candidates on
+// tables that are already the inner side of IndexJoins first, then candidates on the larger side of HashJoins or
+// MergeJoins, and candidates with fewer outer rows first for the same shape.
+func rankJoinCandidates(candidates []joinCandidate, plan utils.Plan, aliases map[string]string) {
+	tree := parsePlanTree(plan)
+	for k := range candidates {
+		c := &candidates[k]
+		c.shape, c.outerRows = joinShapeUnknown, -1
+		for i := range tree {
+			shape, outerRows, ok := tree.joinShape(i, *c, aliases)
+			if !ok {
+				continue
+			}
+			if shape < c.shape || (shape == c.shape && outerRows < c.outerRows) {
+				c.shape, c.outerRows = shape, outerRows
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].shape != candidates[j].shape {
+			return candidates[i].shape < candidates[j].shape
+		}
+		return candidates[i].outerRows < candidates[j].outerRows
+	})
+}
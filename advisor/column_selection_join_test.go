@@ -0,0 +1,95 @@
+package advisor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/qw4990/index_advisor/utils"
+)
+
+func joinCandidateKeys(candidates []joinCandidate) string {
+	var keys []string
+	for _, c := range candidates {
+		keys = append(keys, c.index.Key())
+	}
+	return strings.Join(keys, " ")
+}
+
+func starSchemaViews() *utils.ViewResolver {
+	var tables []utils.TableSchema
+	for _, stmt := range []string{
+		"create table f (id int, d1 int, d2 int, v int, c varchar(10))",
+		"create table d1 (id int, k int, c varchar(10))",
+		"create table d2 (id int, k int, c varchar(10), t text)",
+	} {
+		t, err := utils.ParseCreateTableStmt("test", stmt)
+		must(err)
+		tables = append(tables, t)
+	}
+	return utils.NewViewResolver(utils.ListToSet(tables...), nil)
+}
+
+func TestCollectJoinCandidates(t *testing.T) {
+	cases := []struct {
+		sql        string
+		candidates string
+	}{
+		{"select * from f join d1 on f.d1 = d1.id where d1.c = 'a'", "test.d1(id,c)"},
+		{"select * from f x, d1 y, d2 where x.d1 = y.id and x.d2 = d2.id and y.c = 'a' and d2.c in ('b', 'c') and d2.k = 1 and x.v > 1",
+			"test.d1(id,c) test.d2(id,c,k)"},
+		{"select * from f, d1 where f.d1 = d1.id and f.d2 = d1.k and f.c = 'a'", "test.f(d1,d2,c) test.d1(id,k)"},
+		{"select * from f left join d1 on f.d1 = d1.id and f.c = 'a' where d1.c = 'b'", "test.d1(id,c)"},
+		{"select * from f where exists (select 1 from d1 where d1.id = f.d1 and d1.k = 1)", "test.d1(id,k)"},
+		{"select * from f join d2 on f.d2 = d2.id where d2.t = 'a' and d2.k = f.v + 1", ""}, // non-indexable and non-constant filters
+		{"select * from f a join f b on a.d1 = b.d2 where b.c = 'a'", ""},                   // self-joins
+	}
+	views := starSchemaViews()
+	for _, c := range cases {
+		stmt, err := utils.ParseOneSQL(c.sql)
+		must(err)
+		candidates, _ := collectJoinCandidates(stmt, "test", views)
+		if keys := joinCandidateKeys(candidates); keys != c.candidates {
+			t.Fatalf("unexpected candidates for %v: %v, expected %v", c.sql, keys, c.candidates)
+		}
+	}
+}
+
+func TestRankJoinCandidates(t *testing.T) {
+	sql := "select * from f x join d2 on x.d2 = d2.id join d1 y on x.d1 = y.id where y.c = 'a' and d2.c = 'b' and d2.k = 1"
+	stmt, err := utils.ParseOneSQL(sql)
+	must(err)
+	candidates, aliases := collectJoinCandidates(stmt, "test", starSchemaViews())
+	if keys := joinCandidateKeys(candidates); keys != "test.d2(id,c,k) test.d1(id,c)" {
+		t.Fatalf("unexpected candidates: %v", keys)
+	}
+
+	plan := utils.Plan{
+		{"IndexJoin_21", "0.02", "70836.90", "root", "", "inner join, inner:TableReader_17, outer key:test.x.d1, inner key:test.y.id, equal cond:eq(test.x.d1, test.y.id)"},
+		{"├─HashJoin_29(Build)", "10.00", "70818.84", "root", "", "inner join, equal:[eq(test.d2.id, test.x.d2)]"},
+		{"│ ├─TableReader_36(Build)", "10.00", "176.11", "root", "", "data:Selection_35"},
+		{"│ │ └─Selection_35", "10.00", "0.00", "cop[tikv]", "", `eq(test.d2.c, "b"), eq(test.d2.k, 1)`},
+		{"│ │   └─TableFullScan_34", "10000.00", "590.00", "cop[tikv]", "table:d2", "keep order:false"},
+		{"│ └─TableReader_39(Probe)", "9980.01", "70624.69", "root", "", "data:TableFullScan_37"},
+		{"│   └─TableFullScan_37", "10000.00", "705020.00", "cop[tikv]", "table:x", "keep order:false"},
+		{"└─TableReader_17(Probe)", "0.00", "4.00", "root", "", "data:Selection_16"},
+		{"  └─Selection_16", "0.00", "60.00", "cop[tikv]", "", `eq(test.y.c, "a")`},
+		{"    └─TableRangeScan_15", "1.00", "0.00", "cop[tikv]", "table:y", "range: decided by [test.x.d1], keep order:false"},
+	}
+	rankJoinCandidates(candidates, plan, aliases)
+	// d1 is already the inner side of the IndexJoin, while d2 is the build side of the HashJoin
+	if keys := joinCandidateKeys(candidates); keys != "test.d1(id,c) test.d2(id,c,k)" {
+		t.Fatalf("unexpected ranked candidates: %v", keys)
+	}
+	if candidates[0].shape != joinShapeIndexJoin || candidates[0].outerRows != 10 ||
+		candidates[1].shape != joinShapeBuild || candidates[1].outerRows != 9980.01 {
+		t.Fatalf("unexpected join shapes: %v %v, %v %v",
+			candidates[0].shape, candidates[0].outerRows, candidates[1].shape, candidates[1].outerRows)
+	}
+
+	// d2 becomes the larger side of the HashJoin
+	plan[2][1], plan[5][1] = "20.00", "5.00"
+	rankJoinCandidates(candidates, plan, aliases)
+	if candidates[1].shape != joinShapeProbe || candidates[1].outerRows != 5 {
+		t.Fatalf("unexpected join shape: %v %v", candidates[1].shape, candidates[1].outerRows)
+	}
+}
@@ -0,0 +1,169 @@
+package advisor
+
+import (
+	"fmt"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/qw4990/index_advisor/optimizer"
+	"github.com/qw4990/index_advisor/utils"
+)
+
+var (
+	// prefixLengths are lengths of prefix key parts to try, from the shortest one.
+	prefixLengths = []int{8, 16, 32, 64, 128, 255}
+	// defaultPrefixLength is used for columns without any sampled values in stats.
+	defaultPrefixLength = 64
+	// prefixNDVRatio is how close the NDV of a prefix should be to the NDV of the full column.
+	prefixNDVRatio = 0.95
+)
+
+// prefixPredicateVisitor finds long string columns in filters like `url = ?`, `title in (?, ?)` and
+// `url like 'x%'`, which can only be indexed by prefixes.
+type prefixPredicateVisitor struct {
+	resolver *columnResolver
+	cols     []utils.Column
+}
+
+func (v *prefixPredicateVisitor) Enter(n ast.Node) (node ast.Node, skipChildren bool) {
+	sel, ok := n.(*ast.SelectStmt)
+	if !ok {
+		return n, false
+	}
+	var filters []ast.ExprNode // ON conditions of outer joins are not filters
+	if sel.Where != nil {
+		filters = utils.FlattenCNF(sel.Where)
+	}
+	if sel.From != nil {
+		filters, _ = collectOnConditions(sel.From.TableRefs, filters, nil)
+	}
+	for _, expr := range filters {
+		if p, ok := classifyColumnPredicate(expr, v.resolver.prefixColumn); ok && !containsColumn(v.cols, p.col) {
+			v.cols = append(v.cols, p.col)
+		}
+	}
+	return n, false
+}
+
+func (v *prefixPredicateVisitor) Leave(n ast.Node) (node ast.Node, ok bool) {
+	return n, true
+}
+
+// collectPrefixColumns returns long string columns used by filters of the statement.
+func collectPrefixColumns(stmt ast.StmtNode, defaultSchema string, views *utils.ViewResolver) []utils.Column {
+	v := &prefixPredicateVisitor{resolver: newColumnResolver(defaultSchema, views)}
+	v.resolver.resolve(stmt)
+	stmt.Accept(v)
+	return v.cols
+}
+
+// columnSamples is values of columns sampled in stats, the key is `schema.table.column`.
+type columnSamples map[string][]string
+
+// loadColumnSamples reads values of columns of these tables from bounds of histogram buckets in `show stats_buckets`
+// and from `show stats_topn`, columns without stats are ignored.
+func loadColumnSamples(op optimizer.WhatIfOptimizer, tables []utils.TableName) (columnSamples, error) {
+	samples := make(columnSamples)
+	for _, t := range tables {
+		for _, stmt := range []string{"show stats_buckets", "show stats_topn"} {
+			rows, err := op.Query(fmt.Sprintf(`%s where db_name='%s' and table_name='%s'`, stmt, t.SchemaName, t.TableName))
+			if err != nil {
+				return nil, err
+			}
+			err = readRows(rows, func(row map[string]string) {
+				if row["is_index"] != "0" {
+					return
+				}
+				col := utils.NewColumn(row["db_name"], row["table_name"], row["column_name"])
+				if v, ok := row["value"]; ok { // TopN
+					samples[col.Key()] = append(samples[col.Key()], v)
+				} else { // buckets
+					samples[col.Key()] = append(samples[col.Key()], row["lower_bound"], row["upper_bound"])
+				}
+			})
+			rows.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return samples, nil
+}
+
+// prefixLength returns the shortest length in prefixLengths whose prefixes of sampled values keep the NDV close to the
+// full column, or defaultPrefixLength if no value of the column is sampled.
+func (samples columnSamples) prefixLength(col utils.Column) int {
+	maxLength := prefixLengths[len(prefixLengths)-1]
+	if col.ColumnType != nil && col.ColumnType.Flen > 0 {
+		maxLength = utils.Min(maxLength, col.ColumnType.Flen)
+	}
+	values := samples[col.Key()]
+	if len(values) == 0 {
+		return utils.Min(defaultPrefixLength, maxLength)
+	}
+	ndv := func(length int) int {
+		distinct := make(map[string]struct{})
+		for _, v := range values {
+			if r := []rune(v); length > 0 && len(r) > length {
+				v = string(r[:length])
+			}
+			distinct[v] = struct{}{}
+		}
+		return len(distinct)
+	}
+	fullNDV := ndv(0)
+	for _, length := range prefixLengths {
+		if length >= maxLength {
+			break
+		}
+		if float64(ndv(length)) >= prefixNDVRatio*float64(fullNDV) {
+			return length
+		}
+	}
+	return maxLength
+}
+
+// PrefixCandidatesSelection generates prefix index candidates like `url(32)` for filters on long string columns, which
+// are not indexable as full columns, adds them to the workload's candidate indexes, and adds their key parts to
+// indexable columns of queries using them. Prefix lengths are chosen from values of these columns sampled in stats.
+func PrefixCandidatesSelection(workloadInfo *utils.WorkloadInfo, op optimizer.WhatIfOptimizer) error {
+	views := utils.NewViewResolver(workloadInfo.TableSchemas, workloadInfo.Views)
+	queries := workloadInfo.Queries.ToList()
+	queryCols := make([][]utils.Column, len(queries))
+	tables := utils.NewSet[utils.TableName]()
+	for i, q := range queries {
+		stmt, err := utils.ParseOneSQL(q.Text)
+		if err != nil {
+			return err
+		}
+		queryCols[i] = collectPrefixColumns(stmt, q.SchemaName, views)
+		for _, col := range queryCols[i] {
+			tables.Add(columnTable(col))
+		}
+	}
+	if tables.Size() == 0 {
+		return nil
+	}
+	samples, err := loadColumnSamples(op, tables.ToList())
+	if err != nil {
+		utils.Warningf("failed to read sampled values of columns, use the default prefix length %v: %v", defaultPrefixLength, err)
+		samples = make(columnSamples)
+	}
+
+	if workloadInfo.CandidateIndexes == nil {
+		workloadInfo.CandidateIndexes = utils.NewSet[utils.Index]()
+	}
+	for i, q := range queries {
+		if q.IndexableColumns == nil {
+			q.IndexableColumns = utils.NewSet[utils.Column]()
+		}
+		for _, col := range queryCols[i] {
+			part := col
+			part.Length = samples.prefixLength(col)
+			utils.Debugf("query %v: prefix key part %v", q.Alias, part.Key())
+			workloadInfo.CandidateIndexes.Add(utils.NewIndexWithColumns(tempIndexName(part), part))
+			q.IndexableColumns.Add(part)
+		}
+		workloadInfo.Queries.Add(q)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package advisor
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/qw4990/index_advisor/utils"
+)
+
+func TestCollectPrefixColumns(t *testing.T) {
+	tt, err := utils.ParseCreateTableStmt("test", "create table t (a int, url varchar(2048), title text, data blob, name varchar(64))")
+	must(err)
+	views := utils.NewViewResolver(utils.ListToSet(tt), nil)
+	cases := []struct {
+		sql  string
+		cols string
+	}{
+		{"select * from t where url = 'x' and title in ('a', 'b') and name = 'c' and a = 1", "test.t.url test.t.title"},
+		{"select * from t x join t y on x.a = y.a and x.url like 'http%' where y.data between 'a' and 'b'", "test.t.data test.t.url"},
+		{"select * from t where url like '%x' or title = 'a' and a = 1", ""},
+		{"select * from t x left join t y on x.url = y.url and y.title = 'a' order by x.title", ""},
+	}
+	for _, c := range cases {
+		stmt, err := utils.ParseOneSQL(c.sql)
+		must(err)
+		var keys []string
+		for _, col := range collectPrefixColumns(stmt, "test", views) {
+			keys = append(keys, col.Key())
+		}
+		if strings.Join(keys, " ") != c.cols {
+			t.Fatalf("unexpected prefix columns for %v: %v, expected %v", c.sql, keys, c.cols)
+		}
+	}
+
+	cols := make(map[string]utils.Column)
+	for _, col := range tt.Columns {
+		cols[col.ColumnName] = col
+	}
+	samples := make(columnSamples)
+	for i := 0; i < 100; i++ {
+		samples["test.t.url"] = append(samples["test.t.url"], fmt.Sprintf("https://www.example.com/%05d", i))
+		samples["test.t.title"] = append(samples["test.t.title"], fmt.Sprintf("%v title", i%10))
+		samples["test.t.name"] = append(samples["test.t.name"], strings.Repeat("x", 50)+fmt.Sprint(i))
+	}
+	for _, c := range []struct {
+		col    string
+		length int
+	}{
+		{"url", 32},  // `https://www.example.com/` has 24 characters
+		{"title", 8}, // all prefixes are distinct
+		{"name", 64}, // up to the column length
+		{"data", 64}, // no samples
+	} {
+		if length := samples.prefixLength(cols[c.col]); length != c.length {
+			t.Fatalf("unexpected prefix length of %v: %v, expected %v", c.col, length, c.length)
+		}
+	}
+
+	part := cols["url"]
+	part.Length = 32
+	idx := utils.NewIndexWithColumns(tempIndexName(part, cols["a"]), part, cols["a"])
+	if idx.DDL() != "CREATE INDEX idx_url_32_a ON test.t (url(32), a)" || idx.Key() != "test.t(url(32),a)" {
+		t.Fatalf("unexpected prefix index: %v, %v", idx.DDL(), idx.Key())
+	}
+}
@@ -1,8 +1,6 @@
 package advisor
 
 import (
-	"strings"
-
 	"github.com/pingcap/parser/ast"
 	"github.com/pingcap/parser/mysql"
 	"github.com/pingcap/parser/opcode"
@@ -12,9 +10,9 @@ import (
 
 // simpleIndexableColumnsVisitor finds all columns that appear in any range-filter, order-by, or group-by clause.
 type simpleIndexableColumnsVisitor struct {
-	tables      utils.Set[utils.TableSchema]
+	views       *utils.ViewResolver
 	cols        utils.Set[utils.Column] // key = 'schema.table.column'
-	currentSQL  utils.Query
+	resolver    *columnResolver         // resolves columns of the current utils.Query
 	currentCols utils.Set[utils.Column] // columns related to the current utils.Query
 }
 
@@ -50,18 +48,12 @@ func (v *simpleIndexableColumnsVisitor) collectColumn(n ast.Node) {
 	case *ast.ColumnNameExpr:
 		v.collectColumn(x.Name)
 	case *ast.ColumnName:
-		var schemaName string
-		if x.Schema.L != "" {
-			schemaName = x.Schema.L
-		} else {
-			schemaName = v.currentSQL.SchemaName
-		}
-		possibleColumns, err := v.matchPossibleColumns(schemaName, x.Name.L)
-		if err != nil {
-			// TODO: log or return this error?
+		baseColumns, ok := v.resolver.baseColumns(x)
+		if !ok { // unknown or ambiguous columns
+			return
 		}
-		for _, c := range possibleColumns {
-			if !v.checkColumnIndexableByType(c) {
+		for _, c := range baseColumns {
+			if !checkColumnIndexableByType(c) {
 				continue
 			}
 			v.cols.Add(c)
@@ -70,7 +62,8 @@ func (v *simpleIndexableColumnsVisitor) collectColumn(n ast.Node) {
 	}
 }
 
-func (v *simpleIndexableColumnsVisitor) checkColumnIndexableByType(c utils.Column) bool {
+// checkColumnIndexableByType returns whether the column can be indexed according to its type.
+func checkColumnIndexableByType(c utils.Column) bool {
 	if c.ColumnType == nil {
 		return false
 	}
@@ -85,26 +78,19 @@ func (v *simpleIndexableColumnsVisitor) checkColumnIndexableByType(c utils.Colum
 	return false
 }
 
-func (v *simpleIndexableColumnsVisitor) matchPossibleColumns(defaultSchemaName, columnName string) (cols []utils.Column, err error) {
-	relatedTableNames, err := utils.CollectTableNamesFromSQL(defaultSchemaName, v.currentSQL.Text)
-	if err != nil {
-		return nil, err
+// checkColumnPrefixIndexableByType checks whether the column is a long string column, which is not indexable by
+// checkColumnIndexableByType but can be indexed by a prefix.
+func checkColumnPrefixIndexableByType(c utils.Column) bool {
+	if c.ColumnType == nil {
+		return false
 	}
-	for _, table := range v.tables.ToList() {
-		if !relatedTableNames.Contains(utils.TableName{table.SchemaName, table.TableName}) {
-			continue
-		}
-		for _, col := range table.Columns {
-			// it's hard to check which column belongs to which table, for simplification, just check whether this table name is in the query text.
-			if !strings.Contains(strings.ToLower(v.currentSQL.Text), strings.ToLower(col.TableName)) {
-				continue
-			}
-			if col.ColumnName == columnName {
-				cols = append(cols, col)
-			}
-		}
+	switch c.ColumnType.Tp {
+	case mysql.TypeVarchar, mysql.TypeString, mysql.TypeVarString:
+		return c.ColumnType.Flen > 512
+	case mysql.TypeTinyBlob, mysql.TypeMediumBlob, mysql.TypeLongBlob, mysql.TypeBlob:
+		return true // TEXT and BLOB columns
 	}
-	return
+	return false
 }
 
 func (v *simpleIndexableColumnsVisitor) Leave(n ast.Node) (node ast.Node, ok bool) {
@@ -114,8 +100,8 @@ func (v *simpleIndexableColumnsVisitor) Leave(n ast.Node) (node ast.Node, ok boo
 // IndexableColumnsSelectionSimple finds all columns that appear in any range-filter, order-by, or group-by clause.
 func IndexableColumnsSelectionSimple(workloadInfo *utils.WorkloadInfo) error {
 	v := &simpleIndexableColumnsVisitor{
-		cols:   utils.NewSet[utils.Column](),
-		tables: workloadInfo.TableSchemas,
+		cols:  utils.NewSet[utils.Column](),
+		views: utils.NewViewResolver(workloadInfo.TableSchemas, workloadInfo.Views),
 	}
 	sqls := workloadInfo.Queries.ToList()
 	for _, sql := range sqls {
@@ -123,7 +109,11 @@ func IndexableColumnsSelectionSimple(workloadInfo *utils.WorkloadInfo) error {
 		if err != nil {
 			return err
 		}
-		v.currentSQL = sql
+		v.resolver = newColumnResolver(sql.SchemaName, v.views)
+		v.resolver.resolve(stmt)
+		for _, err := range v.resolver.errs {
+			utils.Debugf("query %v: %v", sql.Alias, err)
+		}
 		v.currentCols = utils.NewSet[utils.Column]()
 		stmt.Accept(v)
 		sql.IndexableColumns = v.currentCols
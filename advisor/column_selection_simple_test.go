@@ -22,10 +22,10 @@ func TestFindIndexableColumnsCase1(t *testing.T) {
 	workload := utils.WorkloadInfo{
 		TableSchemas: utils.ListToSet(tt),
 		Queries: utils.ListToSet(
-			utils.Query{"", "test",
-				"select * from t where a<1 and b>1 and e like 'abc'", 1, nil},
-			utils.Query{"", "test",
-				"select * from t where c in (1, 2, 3) order by d", 1, nil}),
+			utils.Query{SchemaName: "test", Frequency: 1,
+				Text: "select * from t where a<1 and b>1 and e like 'abc'"},
+			utils.Query{SchemaName: "test", Frequency: 1,
+				Text: "select * from t where c in (1, 2, 3) order by d"}),
 	}
 	must(IndexableColumnsSelectionSimple(&workload))
 	checkIndexableCols(workload.IndexableColumns, []string{"test.t.a", "test.t.b", "test.t.c", "test.t.d"})
@@ -38,8 +38,8 @@ func TestFindIndexableColumnsCase2(t *testing.T) {
 	must(err)
 	workload := utils.WorkloadInfo{
 		TableSchemas: utils.ListToSet(t1, t2),
-		Queries: utils.ListToSet(utils.Query{"", "test",
-			"select * from t2 tx where a<1", 1, nil}),
+		Queries: utils.ListToSet(utils.Query{SchemaName: "test", Frequency: 1,
+			Text: "select * from t2 tx where a<1"}),
 	}
 	must(IndexableColumnsSelectionSimple(&workload))
 	checkIndexableCols(workload.IndexableColumns, []string{"test.t2.a"})
@@ -52,13 +52,46 @@ func TestFindIndexableColumnsCase3(t *testing.T) {
 	must(err)
 	workload := utils.WorkloadInfo{
 		TableSchemas: utils.ListToSet(t1, t2),
-		Queries: utils.ListToSet(utils.Query{"", "db1",
-			"select * from db2.t2 where a2<1", 1, nil}),
+		Queries: utils.ListToSet(utils.Query{SchemaName: "db1", Frequency: 1,
+			Text: "select * from db2.t2 where a2<1"}),
 	}
 	must(IndexableColumnsSelectionSimple(&workload))
 	checkIndexableCols(workload.IndexableColumns, []string{"db2.t2.a2"})
 }
 
+func TestFindIndexableColumnsJoin(t *testing.T) {
+	t1, err := utils.ParseCreateTableStmt("test", "create table t1 (id int, a int)")
+	must(err)
+	t2, err := utils.ParseCreateTableStmt("test", "create table t2 (id int, a int, b int)")
+	must(err)
+	workload := utils.WorkloadInfo{
+		TableSchemas: utils.ListToSet(t1, t2),
+		Queries: utils.ListToSet(utils.Query{SchemaName: "test", Frequency: 1,
+			Text: "select * from t1 x join t2 on x.id = t2.id where x.a = 1 order by b"}),
+	}
+	must(IndexableColumnsSelectionSimple(&workload))
+	checkIndexableCols(workload.IndexableColumns, []string{"test.t1.a", "test.t1.id", "test.t2.b", "test.t2.id"})
+}
+
+func TestFindIndexableColumnsView(t *testing.T) {
+	t1, err := utils.ParseCreateTableStmt("test", "create table t1 (a int, b int, c int)")
+	must(err)
+	t2, err := utils.ParseCreateTableStmt("test", "create table t2 (a int, d int)")
+	must(err)
+	v1, err := utils.ParseCreateViewStmt("test", "create view v1 (x, y) as select t1.a, b+1 from t1 join t2 on t1.a = t2.a")
+	must(err)
+	v2, err := utils.ParseCreateViewStmt("test", "create view v2 as select * from v1, t2")
+	must(err)
+	workload := utils.WorkloadInfo{
+		TableSchemas: utils.ListToSet(t1, t2),
+		Views:        utils.ListToSet(v1, v2),
+		Queries: utils.ListToSet(utils.Query{SchemaName: "test", Frequency: 1,
+			Text: "select * from v2 where x = 1 and y > 2 and d < 3"}),
+	}
+	must(IndexableColumnsSelectionSimple(&workload))
+	checkIndexableCols(workload.IndexableColumns, []string{"test.t1.a", "test.t2.d"})
+}
+
 func TestFindIndexableColumnsSimpleTPCH(t *testing.T) {
 	t1, err := utils.ParseCreateTableStmt("tpch", `CREATE TABLE tpch.nation (
                                N_NATIONKEY bigint(20) NOT NULL,
@@ -71,7 +104,7 @@ func TestFindIndexableColumnsSimpleTPCH(t *testing.T) {
 	workload := utils.WorkloadInfo{
 		TableSchemas: utils.ListToSet(t1),
 		Queries: utils.ListToSet(
-			utils.Query{"", "tpch", `select
+			utils.Query{SchemaName: "tpch", Frequency: 1, Text: `select
 	supp_nation,
 	cust_nation,
 	l_year,
@@ -109,7 +142,7 @@ group by
 order by
 	supp_nation,
 	cust_nation,
-	l_year`, 1, nil})}
+	l_year`})}
 	must(IndexableColumnsSelectionSimple(&workload))
 	checkIndexableCols(workload.IndexableColumns, []string{"tpch.nation.n_name", "tpch.nation.n_nationkey"})
 }
@@ -0,0 +1,211 @@
+package advisor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/qw4990/index_advisor/utils"
+)
+
+// Constraints are user-provided constraints on the candidate space.
+type Constraints struct {
+	ExcludedTables  []utils.TableName       // tables which must never be indexed
+	ExcludedColumns []utils.Column          // columns which must never be indexed
+	ProposedIndexes []utils.Index           // indexes which must be considered as candidates
+	KeptIndexes     []utils.Index           // indexes which must be kept in the result
+	TableLimits     map[utils.TableName]int // the max number of new indexes on each table
+}
+
+// ConstraintsSpec is the textual form of Constraints used by flags and the constraints file, e.g.
+// `{"exclude_tables": ["db.t1"], "exclude_columns": ["db.t2.c"], "propose_indexes": ["db.t2(a, b)"],
+// "keep_indexes": ["db.t3((lower(name)))"], "table_index_limits": {"db.t2": 1}}`. The schema name can be omitted.
+type ConstraintsSpec struct {
+	ExcludeTables    []string       `json:"exclude_tables,omitempty"`
+	ExcludeColumns   []string       `json:"exclude_columns,omitempty"`
+	ProposeIndexes   []string       `json:"propose_indexes,omitempty"`
+	KeepIndexes      []string       `json:"keep_indexes,omitempty"`
+	TableIndexLimits map[string]int `json:"table_index_limits,omitempty"`
+}
+
+// LoadConstraintsSpec loads the constraints file in JSON.
+func LoadConstraintsSpec(fpath string) (ConstraintsSpec, error) {
+	var spec ConstraintsSpec
+	data, err := os.ReadFile(fpath)
+	if err != nil {
+		return spec, err
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return spec, fmt.Errorf("invalid constraints file %v: %v", fpath, err)
+	}
+	return spec, nil
+}
+
+// Merge returns the union of the two specs, limits in other override the ones in s.
+func (s ConstraintsSpec) Merge(other ConstraintsSpec) ConstraintsSpec {
+	merged := ConstraintsSpec{
+		ExcludeTables:    append(append([]string{}, s.ExcludeTables...), other.ExcludeTables...),
+		ExcludeColumns:   append(append([]string{}, s.ExcludeColumns...), other.ExcludeColumns...),
+		ProposeIndexes:   append(append([]string{}, s.ProposeIndexes...), other.ProposeIndexes...),
+		KeepIndexes:      append(append([]string{}, s.KeepIndexes...), other.KeepIndexes...),
+		TableIndexLimits: make(map[string]int),
+	}
+	for _, limits := range []map[string]int{s.TableIndexLimits, other.TableIndexLimits} {
+		for t, limit := range limits {
+			merged.TableIndexLimits[t] = limit
+		}
+	}
+	return merged
+}
+
+// ParseConstraints parses the spec, names without schemas are considered to be under the defaultSchema.
+func ParseConstraints(spec ConstraintsSpec, defaultSchema string) (Constraints, error) {
+	var c Constraints
+	tableName := func(name string) (utils.TableName, error) {
+		parts := strings.Split(strings.ToLower(strings.TrimSpace(name)), ".")
+		switch {
+		case len(parts) == 1 && parts[0] != "":
+			return utils.TableName{SchemaName: strings.ToLower(defaultSchema), TableName: parts[0]}, nil
+		case len(parts) == 2 && parts[0] != "" && parts[1] != "":
+			return utils.TableName{SchemaName: parts[0], TableName: parts[1]}, nil
+		}
+		return utils.TableName{}, fmt.Errorf("invalid table name %v, should be like 'db.t' or 't'", name)
+	}
+
+	for _, name := range spec.ExcludeTables {
+		t, err := tableName(name)
+		if err != nil {
+			return c, err
+		}
+		c.ExcludedTables = append(c.ExcludedTables, t)
+	}
+	for _, name := range spec.ExcludeColumns {
+		i := strings.LastIndex(name, ".")
+		if i < 0 {
+			return c, fmt.Errorf("invalid column name %v, should be like 'db.t.c' or 't.c'", name)
+		}
+		t, err := tableName(name[:i])
+		if err != nil {
+			return c, err
+		}
+		c.ExcludedColumns = append(c.ExcludedColumns, utils.NewColumn(t.SchemaName, t.TableName, strings.TrimSpace(name[i+1:])))
+	}
+	for _, indexes := range []struct {
+		specs  []string
+		target *[]utils.Index
+	}{{spec.ProposeIndexes, &c.ProposedIndexes}, {spec.KeepIndexes, &c.KeptIndexes}} {
+		for _, indexSpec := range indexes.specs {
+			i := strings.Index(indexSpec, "(")
+			if i < 0 || !strings.HasSuffix(strings.TrimSpace(indexSpec), ")") {
+				return c, fmt.Errorf("invalid index %v, should be like 'db.t(a, b)' or 't(a, b)'", indexSpec)
+			}
+			t, err := tableName(indexSpec[:i])
+			if err != nil {
+				return c, err
+			}
+			idx, err := utils.ParseCreateIndexStmt(fmt.Sprintf("create index idx on %v.%v %v",
+				t.SchemaName, t.TableName, strings.TrimSpace(indexSpec[i:])))
+			if err != nil {
+				return c, fmt.Errorf("invalid index %v: %v", indexSpec, err)
+			}
+			*indexes.target = append(*indexes.target, utils.NewIndexWithColumns(tempIndexName(idx.Columns...), idx.Columns...))
+		}
+	}
+	for name, limit := range spec.TableIndexLimits {
+		t, err := tableName(name)
+		if err != nil {
+			return c, err
+		}
+		if limit < 0 {
+			return c, fmt.Errorf("invalid index limit %v of table %v, should be at least 0", limit, name)
+		}
+		if c.TableLimits == nil {
+			c.TableLimits = make(map[utils.TableName]int)
+		}
+		c.TableLimits[t] = limit
+	}
+	return c, nil
+}
+
+// validate removes proposed and kept indexes which are excluded or on unknown tables or columns, since they can't be
+// created as hypothetical indexes, and warns about kept indexes exceeding the table limits.
+func (c Constraints) validate(tables utils.Set[utils.TableSchema]) Constraints {
+	valid := func(kind string, idx utils.Index) bool {
+		if !c.allowed(idx) {
+			utils.Warningf("%v index %v is excluded by the constraints, ignore it", kind, idx.Key())
+			return false
+		}
+		table, ok := tables.Find(utils.TableSchema{SchemaName: idx.SchemaName, TableName: idx.TableName})
+		if !ok {
+			utils.Warningf("%v index %v is on an unknown table, ignore it", kind, idx.Key())
+			return false
+		}
+		for _, col := range idx.Columns {
+			if !containsColumn(table.Columns, utils.NewColumn(col.SchemaName, col.TableName, col.ColumnName)) {
+				utils.Warningf("%v index %v is on an unknown column %v, ignore it", kind, idx.Key(), col.ColumnName)
+				return false
+			}
+		}
+		return true
+	}
+	var proposed, kept []utils.Index
+	for _, idx := range c.ProposedIndexes {
+		if valid("proposed", idx) {
+			proposed = append(proposed, idx)
+		}
+	}
+	for _, idx := range c.KeptIndexes {
+		if valid("kept", idx) {
+			kept = append(kept, idx)
+		}
+	}
+	c.ProposedIndexes, c.KeptIndexes = proposed, kept
+	if c.exceedsTableLimits(utils.ListToSet(kept...)) {
+		utils.Warningf("kept indexes exceed the per-table index limits, other indexes on these tables won't be recommended")
+	}
+	return c
+}
+
+// allowed returns whether the index is allowed, i.e. neither its table nor its columns are excluded.
+func (c Constraints) allowed(idx utils.Index) bool {
+	for _, t := range c.ExcludedTables {
+		if t.SchemaName == idx.SchemaName && t.TableName == idx.TableName {
+			return false
+		}
+	}
+	for _, col := range idx.Columns {
+		for _, excluded := range c.ExcludedColumns {
+			if excluded.SchemaName == col.SchemaName && excluded.TableName == col.TableName && excluded.ColumnName == col.ColumnName {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// exceedsTableLimits returns whether the indexes exceed the per-table limits.
+func (c Constraints) exceedsTableLimits(indexes utils.Set[utils.Index]) bool {
+	if len(c.TableLimits) == 0 {
+		return false
+	}
+	counts := make(map[utils.TableName]int)
+	for _, idx := range indexes.ToList() {
+		t := utils.TableName{SchemaName: idx.SchemaName, TableName: idx.TableName}
+		counts[t]++
+		if limit, ok := c.TableLimits[t]; ok && counts[t] > limit {
+			return true
+		}
+	}
+	return false
+}
+
+// kept returns whether the index must be kept.
+func (c Constraints) kept(idx utils.Index) bool {
+	for _, k := range c.KeptIndexes {
+		if k.Key() == idx.Key() {
+			return true
+		}
+	}
+	return false
+}
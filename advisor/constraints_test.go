@@ -0,0 +1,81 @@
+package advisor
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/qw4990/index_advisor/utils"
+)
+
+func TestParseConstraints(t *testing.T) {
+	c, err := ParseConstraints(ConstraintsSpec{
+		ExcludeTables:    []string{"T1", "db2.t2"},
+		ExcludeColumns:   []string{"t3.A", "db2.t4.b"},
+		ProposeIndexes:   []string{"t3(b, c)", "db2.T4(a, (lower(c)))"},
+		KeepIndexes:      []string{"t5(name(10))"},
+		TableIndexLimits: map[string]int{"t3": 1, "db2.t4": 0},
+	}, "Test")
+	must(err)
+	var keys []string
+	for _, tbl := range c.ExcludedTables {
+		keys = append(keys, tbl.SchemaName+"."+tbl.TableName)
+	}
+	for _, col := range c.ExcludedColumns {
+		keys = append(keys, col.Key())
+	}
+	for _, idx := range append(c.ProposedIndexes, c.KeptIndexes...) {
+		keys = append(keys, idx.Key())
+	}
+	expected := "test.t1 db2.t2 test.t3.a db2.t4.b test.t3(b,c) db2.t4(a,(lower(`c`))) test.t5(name(10))"
+	if strings.Join(keys, " ") != expected {
+		t.Fatalf("expected %v, got %v", expected, strings.Join(keys, " "))
+	}
+	if c.TableLimits[utils.TableName{SchemaName: "test", TableName: "t3"}] != 1 || len(c.TableLimits) != 2 {
+		t.Fatalf("unexpected table limits %v", c.TableLimits)
+	}
+
+	for _, spec := range []ConstraintsSpec{
+		{ExcludeTables: []string{"a.b.c"}},
+		{ExcludeColumns: []string{"c"}},
+		{ProposeIndexes: []string{"t"}},
+		{KeepIndexes: []string{"t(a, b"}},
+		{TableIndexLimits: map[string]int{"t": -1}},
+	} {
+		if _, err := ParseConstraints(spec, "test"); err == nil {
+			t.Fatalf("expected an error for %v", spec)
+		}
+	}
+}
+
+func TestConstraintsAllowedAndLimits(t *testing.T) {
+	c, err := ParseConstraints(ConstraintsSpec{
+		ExcludeTables:    []string{"t1"},
+		ExcludeColumns:   []string{"t2.a"},
+		TableIndexLimits: map[string]int{"t2": 1},
+	}, "test")
+	must(err)
+	for _, x := range []struct {
+		index   utils.Index
+		allowed bool
+	}{
+		{utils.NewIndex("test", "t1", "idx", "b"), false},
+		{utils.NewIndex("test", "t2", "idx", "b", "a"), false},
+		{utils.NewIndex("test", "t2", "idx", "b", "c"), true},
+		{utils.NewIndex("test", "t3", "idx", "a"), true},
+	} {
+		if c.allowed(x.index) != x.allowed {
+			t.Fatalf("expected %v for %v", x.allowed, x.index.Key())
+		}
+	}
+
+	indexes := utils.ListToSet(utils.NewIndex("test", "t2", "idx", "b"), utils.NewIndex("test", "t3", "idx", "a"),
+		utils.NewIndex("test", "t3", "idx", "b"))
+	if c.exceedsTableLimits(indexes) {
+		t.Fatalf("unexpected exceeding for %v", indexes.ToKeyList())
+	}
+	indexes.Add(utils.NewIndex("test", "t2", "idx", "c"))
+	if !c.exceedsTableLimits(indexes) {
+		t.Fatalf("expected exceeding for %v", fmt.Sprint(indexes.ToKeyList()))
+	}
+}
@@ -1,6 +1,8 @@
 package advisor
 
 import (
+	"strings"
+
 	"github.com/qw4990/index_advisor/optimizer"
 	"github.com/qw4990/index_advisor/utils"
 )
@@ -12,20 +14,32 @@ type IndexSelectionAlgo func(
 	optimizer optimizer.WhatIfOptimizer, // the what-if optimizer
 ) (utils.Set[utils.Index], error)
 
-// IndexableColumnsSelectionAlgo is the interface for indexable columns selection algorithms.
-type IndexableColumnsSelectionAlgo func(workloadInfo *utils.WorkloadInfo) error
+// IndexableColumnsSelectionAlgo is the interface for indexable columns selection algorithms, the optimizer is used to
+// get the current plans of queries.
+type IndexableColumnsSelectionAlgo func(workloadInfo *utils.WorkloadInfo, optimizer optimizer.WhatIfOptimizer) error
 
-// WorkloadInfoCompressionAlgo is the interface for workload info compression algorithms.
-type WorkloadInfoCompressionAlgo func(workloadInfo utils.WorkloadInfo) utils.WorkloadInfo
+// WorkloadInfoCompressionAlgo is the interface for workload info compression algorithms, the optimizer is used to get
+// the baseline costs of queries.
+type WorkloadInfoCompressionAlgo func(workloadInfo utils.WorkloadInfo, parameter Parameter, optimizer optimizer.WhatIfOptimizer) (utils.WorkloadInfo, error)
 
 var (
 	compressAlgorithms = map[string]WorkloadInfoCompressionAlgo{
-		"none":   NoneWorkloadInfoCompress,
-		"digest": DigestWorkloadInfoCompress,
+		"none": func(workloadInfo utils.WorkloadInfo, _ Parameter, _ optimizer.WhatIfOptimizer) (utils.WorkloadInfo, error) {
+			return NoneWorkloadInfoCompress(workloadInfo), nil
+		},
+		"digest": func(workloadInfo utils.WorkloadInfo, p Parameter, _ optimizer.WhatIfOptimizer) (utils.WorkloadInfo, error) {
+			return DigestWorkloadInfoCompress(workloadInfo, p.MaxNumSamples), nil
+		},
+		"cost": func(workloadInfo utils.WorkloadInfo, p Parameter, op optimizer.WhatIfOptimizer) (utils.WorkloadInfo, error) {
+			return CostWorkloadInfoCompress(workloadInfo, p.MaxNumSamples, p.MaxNumQueries, op)
+		},
 	}
 
 	findIndexableColsAlgorithms = map[string]IndexableColumnsSelectionAlgo{
-		"simple": IndexableColumnsSelectionSimple,
+		"simple": func(workloadInfo *utils.WorkloadInfo, _ optimizer.WhatIfOptimizer) error {
+			return IndexableColumnsSelectionSimple(workloadInfo)
+		},
+		"join": IndexableColumnsSelectionJoin,
 	}
 
 	selectIndexAlgorithms = map[string]IndexSelectionAlgo{
@@ -37,6 +51,17 @@ var (
 type Parameter struct {
 	MaxNumberIndexes int // the max number of indexes to recommend
 	MaxIndexWidth    int // the max number of columns in recommended indexes
+	MaxNumQueries    int // the max number of queries to keep after compressing the workload, 0 means no limit
+	MaxNumSamples    int // the max number of samples with different constants to keep for each digest, 1 by default
+
+	CompressionAlgo string // one of compressAlgorithms, "cost" if MaxNumQueries is set, "digest" by default
+	IndexableAlgo   string // one of findIndexableColsAlgorithms, "simple" by default
+
+	RegressionGuard    bool     // reject configurations making any query's cost rise by more than MaxQueryRegression
+	MaxQueryRegression float64  // the max percentage a query's cost can rise over the baseline with RegressionGuard
+	ProtectedQueries   []string // aliases of queries whose costs must not rise over the baseline at all
+
+	Constraints Constraints // user-provided constraints on the candidate space
 }
 
 func validateParameter(p Parameter) Parameter {
@@ -56,25 +81,82 @@ func validateParameter(p Parameter) Parameter {
 		utils.Warningf("max index width should be at most 5, set from %v to 5", p.MaxIndexWidth)
 		p.MaxIndexWidth = 5
 	}
+	if p.MaxNumQueries < 0 {
+		utils.Warningf("max number of queries should be at least 0, set from %v to 0", p.MaxNumQueries)
+		p.MaxNumQueries = 0
+	}
+	if p.MaxNumSamples < 1 { // keep one sample for each digest by default
+		p.MaxNumSamples = 1
+	}
+	if p.CompressionAlgo == "" {
+		p.CompressionAlgo = "digest"
+		if p.MaxNumQueries > 0 { // the query budget only works with the cost compression
+			p.CompressionAlgo = "cost"
+		}
+	}
+	if _, ok := compressAlgorithms[p.CompressionAlgo]; !ok {
+		utils.Warningf("unknown workload compression algorithm %v, use 'digest' instead", p.CompressionAlgo)
+		p.CompressionAlgo = "digest"
+	}
+	if p.MaxNumQueries > 0 && p.CompressionAlgo != "cost" {
+		utils.Warningf("max number of queries only works with the 'cost' compression algorithm, ignore it")
+	}
+	if p.IndexableAlgo == "" {
+		p.IndexableAlgo = "simple"
+	}
+	if _, ok := findIndexableColsAlgorithms[p.IndexableAlgo]; !ok {
+		utils.Warningf("unknown indexable columns selection algorithm %v, use 'simple' instead", p.IndexableAlgo)
+		p.IndexableAlgo = "simple"
+	}
+	if p.RegressionGuard && p.MaxQueryRegression < 0 {
+		utils.Warningf("max query regression should be at least 0%%, set from %v%% to 0%%", p.MaxQueryRegression)
+		p.MaxQueryRegression = 0
+	}
 	return p
 }
 
-// IndexAdvise is the entry point of index advisor.
-func IndexAdvise(db optimizer.WhatIfOptimizer, workload utils.WorkloadInfo, param Parameter) (utils.Set[utils.Index], error) {
+// IndexAdvise is the entry point of index advisor. The workload is not changed except its CostCoverage, which is set
+// if the workload is compressed by cost.
+func IndexAdvise(db optimizer.WhatIfOptimizer, workloadInfo *utils.WorkloadInfo, param Parameter) (utils.Set[utils.Index], error) {
+	workload := *workloadInfo
 	utils.Infof("start index advise for %v queries, %v tables", workload.Queries.Size(), workload.TableSchemas.Size())
 	param = validateParameter(param)
+	param.Constraints = param.Constraints.validate(workload.TableSchemas)
+	if numKept := len(param.Constraints.KeptIndexes); numKept > param.MaxNumberIndexes {
+		utils.Warningf("max number of indexes should be at least the number of kept indexes, set from %v to %v",
+			param.MaxNumberIndexes, numKept)
+		param.MaxNumberIndexes = numKept
+	}
 
-	compress := compressAlgorithms["digest"]
-	indexable := findIndexableColsAlgorithms["simple"]
+	compress := compressAlgorithms[param.CompressionAlgo]
+	indexable := findIndexableColsAlgorithms[param.IndexableAlgo]
 	selection := selectIndexAlgorithms["auto_admin"]
 
-	compressedWorkloadInfo := compress(workload)
+	compressedWorkloadInfo, err := compress(workload, param, db)
+	if err != nil {
+		return nil, err
+	}
 	utils.Infof("compress %v queries to %v queries", workload.Queries.Size(), compressedWorkloadInfo.Queries.Size())
+	workloadInfo.CostCoverage = compressedWorkloadInfo.CostCoverage
 
-	if err := indexable(&compressedWorkloadInfo); err != nil {
+	if err := indexable(&compressedWorkloadInfo, db); err != nil {
 		return nil, err
 	}
 	utils.Infof("find %v indexable columns", compressedWorkloadInfo.IndexableColumns.Size())
+	if err := OrderedCandidatesSelection(&compressedWorkloadInfo, db); err != nil {
+		return nil, err
+	}
+	if err := ExpressionCandidatesSelection(&compressedWorkloadInfo, db); err != nil {
+		return nil, err
+	}
+	if err := PrefixCandidatesSelection(&compressedWorkloadInfo, db); err != nil {
+		return nil, err
+	}
+	utils.Infof("find %v composite, expression and prefix candidate indexes", compressedWorkloadInfo.CandidateIndexes.Size())
+
+	if err := setRegressionGuard(&compressedWorkloadInfo, param, db); err != nil {
+		return nil, err
+	}
 
 	checkWorkloadInfo(compressedWorkloadInfo)
 	recommendedIndexes, err := selection(compressedWorkloadInfo, param, db)
@@ -84,3 +166,40 @@ func IndexAdvise(db optimizer.WhatIfOptimizer, workload utils.WorkloadInfo, para
 	utils.Infof("finish index advise with %v recommended indexes", recommendedIndexes.Size())
 	return recommendedIndexes, err
 }
+
+// setRegressionGuard sets the MaxCost of queries from their baseline costs without any recommended index, according
+// to the regression guard and protected queries in the parameter.
+func setRegressionGuard(workloadInfo *utils.WorkloadInfo, param Parameter, db optimizer.WhatIfOptimizer) error {
+	if !param.RegressionGuard && len(param.ProtectedQueries) == 0 {
+		return nil
+	}
+	protected := make(map[string]bool)
+	for _, alias := range param.ProtectedQueries {
+		if alias = strings.TrimSpace(alias); alias != "" {
+			protected[strings.ToLower(alias)] = false
+		}
+	}
+	for _, q := range workloadInfo.Queries.ToList() {
+		_, isProtected := protected[strings.ToLower(q.Alias)]
+		if !param.RegressionGuard && !isProtected {
+			continue
+		}
+		p, err := db.ExplainQ(q)
+		if err != nil {
+			return err
+		}
+		q.MaxCost = p.PlanCost()
+		if isProtected {
+			protected[strings.ToLower(q.Alias)] = true
+		} else {
+			q.MaxCost *= 1 + param.MaxQueryRegression/100
+		}
+		workloadInfo.Queries.Add(q)
+	}
+	for alias, found := range protected {
+		if !found {
+			utils.Warningf("protected query %v is not found in the compressed workload, it's not protected", alias)
+		}
+	}
+	return nil
+}
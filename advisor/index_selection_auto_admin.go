@@ -1,6 +1,9 @@
 package advisor
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/qw4990/index_advisor/optimizer"
 	"github.com/qw4990/index_advisor/utils"
 )
@@ -37,14 +40,21 @@ func SelectIndexAAAlgo(workload utils.WorkloadInfo, parameter Parameter, op opti
 		tmpOptimizers: tmpOptimizers,
 		maxIndexes:    parameter.MaxNumberIndexes,
 		maxIndexWidth: parameter.MaxIndexWidth,
+		constraints:   parameter.Constraints,
 	}
 	utils.Infof("starting auto-admin algorithm with max-indexes %d, max index-width %d", aa.maxIndexes, aa.maxIndexWidth)
 
 	op.ResetStats()
-	bestIndexes, err := aa.calculateBestIndexes(workload)
+	bestIndexes, err := aa.calculateBestIndexesWithRequirements(workload)
 	if err != nil {
 		return nil, err
 	}
+	if bestIndexes, err = aa.trimIndexes(workload, bestIndexes); err != nil {
+		return nil, err
+	}
+	if bestIndexes, err = aa.removeRegressions(workload, bestIndexes); err != nil {
+		return nil, err
+	}
 	utils.Infof("what-if optimizer stats: %v", op.Stats().Format())
 	return bestIndexes, nil
 }
@@ -55,6 +65,126 @@ type autoAdmin struct {
 
 	maxIndexes    int // The algorithm stops as soon as it has selected #max_indexes indexes
 	maxIndexWidth int // The number of columns an index can contain at maximum.
+
+	constraints Constraints // user-provided constraints on the candidate space
+}
+
+// calculateBestIndexesWithRequirements starts from indexes kept by the constraints and indexes selected for target
+// queries, and then uses the remaining budget for the whole workload by adding indexes selected for it greedily.
+func (aa *autoAdmin) calculateBestIndexesWithRequirements(workload utils.WorkloadInfo) (utils.Set[utils.Index], error) {
+	requiredIndexes, err := aa.selectTargetIndexes(workload)
+	if err != nil {
+		return nil, err
+	}
+	if requiredIndexes.Size() == 0 {
+		return aa.calculateBestIndexes(workload)
+	}
+	if requiredIndexes.Size() >= aa.maxIndexes {
+		return requiredIndexes, nil
+	}
+	candidates, err := aa.calculateBestIndexes(workload)
+	if err != nil {
+		return nil, err
+	}
+	cost, err := evaluateIndexConfCost(workload, aa.optimizer, requiredIndexes)
+	if err != nil {
+		return nil, err
+	}
+	indexes, _, err := aa.enumerateGreedy(workload, requiredIndexes, cost, utils.DiffSet(candidates, requiredIndexes), aa.maxIndexes)
+	return indexes, err
+}
+
+// selectTargetIndexes selects the fewest indexes to meet cost reduction targets of target queries besides the kept
+// indexes. It selects candidates for target queries only, adds the one meeting the most targets (or reducing their
+// costs the most) greedily until all targets are met or there is no budget, and then removes indexes which are not
+// necessary for the met targets. The kept indexes are returned if there is no target query.
+func (aa *autoAdmin) selectTargetIndexes(workload utils.WorkloadInfo) (utils.Set[utils.Index], error) {
+	keptIndexes := utils.ListToSet(aa.constraints.KeptIndexes...)
+	targetWorkload := workload
+	targetWorkload.Queries = utils.NewSet[utils.Query]()
+	targetWorkload.IndexableColumns = utils.NewSet[utils.Column]()
+	for _, q := range workload.Queries.ToList() {
+		if q.TargetReduction > 0 {
+			targetWorkload.Queries.Add(q)
+			if q.IndexableColumns != nil {
+				targetWorkload.IndexableColumns.AddSet(q.IndexableColumns)
+			}
+		}
+	}
+	targets := targetWorkload.Queries.ToList()
+	if len(targets) == 0 {
+		return keptIndexes, nil
+	}
+	baselines := make([]float64, len(targets))
+	for i, q := range targets {
+		p, err := aa.optimizer.ExplainQ(q)
+		if err != nil {
+			return nil, err
+		}
+		baselines[i] = p.PlanCost()
+	}
+	evaluate := func(indexes utils.Set[utils.Index]) (met int, cost float64, err error) {
+		for i, q := range targets {
+			p, err := explainWithIndexes(aa.optimizer, q, indexes)
+			if err != nil {
+				return 0, 0, err
+			}
+			if p.PlanCost() <= baselines[i]*(1-q.TargetReduction/100) {
+				met++
+			}
+			cost += p.PlanCost()
+		}
+		return met, cost, nil
+	}
+
+	candidates, err := aa.calculateBestIndexes(targetWorkload)
+	if err != nil {
+		return nil, err
+	}
+	indexes := keptIndexes.Clone()
+	met, cost, err := evaluate(indexes)
+	if err != nil {
+		return nil, err
+	}
+	for met < len(targets) && indexes.Size() < aa.maxIndexes {
+		var best utils.Index
+		bestMet, bestCost := met, cost
+		for _, idx := range utils.DiffSet(candidates, indexes).ToList() {
+			indexes.Add(idx)
+			if aa.constraints.exceedsTableLimits(indexes) {
+				indexes.Remove(idx)
+				continue
+			}
+			m, c, err := evaluate(indexes)
+			indexes.Remove(idx)
+			if err != nil {
+				return nil, err
+			}
+			if m > bestMet || (m == bestMet && c < bestCost) {
+				best, bestMet, bestCost = idx, m, c
+			}
+		}
+		if best.Columns == nil {
+			break // no index can help
+		}
+		indexes.Add(best)
+		met, cost = bestMet, bestCost
+	}
+	for _, idx := range indexes.ToList() { // remove unnecessary indexes
+		if keptIndexes.Contains(idx) {
+			continue
+		}
+		indexes.Remove(idx)
+		m, _, err := evaluate(indexes)
+		if err != nil {
+			return nil, err
+		}
+		if m < met {
+			indexes.Add(idx)
+		}
+	}
+	utils.Infof("auto-admin algorithm: %v indexes meet %v of %v targets", indexes.Size()-keptIndexes.Size(), met, len(targets))
+	return indexes, nil
 }
 
 func (aa *autoAdmin) calculateBestIndexes(workload utils.WorkloadInfo) (utils.Set[utils.Index], error) {
@@ -66,10 +196,13 @@ func (aa *autoAdmin) calculateBestIndexes(workload utils.WorkloadInfo) (utils.Se
 	for _, col := range workload.IndexableColumns.ToList() {
 		potentialIndexes.Add(utils.NewIndex(col.SchemaName, col.TableName, tempIndexName(col), col.ColumnName))
 	}
+	potentialIndexes = aa.allowedIndexes(potentialIndexes)
 
 	currentBestIndexes := utils.NewSet[utils.Index]()
 	for currentMaxIndexWidth := 1; currentMaxIndexWidth <= aa.maxIndexWidth; currentMaxIndexWidth++ {
 		utils.Infof("auto-admin algorithm: current index width is %d", currentMaxIndexWidth)
+		potentialIndexes.AddSet(aa.compositeCandidates(workload, currentMaxIndexWidth))
+		potentialIndexes.AddSet(utils.ListToSet(aa.constraints.ProposedIndexes...))
 		candidates, err := aa.selectIndexCandidates(workload, potentialIndexes)
 		if err != nil {
 			return nil, err
@@ -99,6 +232,10 @@ func (aa *autoAdmin) calculateBestIndexes(workload utils.WorkloadInfo) (utils.Se
 	if err != nil {
 		return nil, err
 	}
+	currentBestIndexes, err = aa.heuristicOrderLimitIndexes(currentBestIndexes, workload)
+	if err != nil {
+		return nil, err
+	}
 
 	utils.Infof("auto-admin algorithm: the number of candidate indexes before filter is %v", currentBestIndexes.Size())
 	currentBestIndexes, err = aa.filterIndexes(workload, currentBestIndexes)
@@ -110,10 +247,14 @@ func (aa *autoAdmin) calculateBestIndexes(workload utils.WorkloadInfo) (utils.Se
 	if err != nil {
 		return nil, err
 	}
+	currentBestIndexes, err = aa.cutDownTableLimits(currentBestIndexes, workload)
+	if err != nil {
+		return nil, err
+	}
 
 	// try to add more indexes if the number of indexes is less than maxIndexes
 	for limit := 0; limit < 3 && currentBestIndexes.Size() < aa.maxIndexes; limit++ {
-		potentialIndexes = utils.DiffSet(potentialIndexes, currentBestIndexes)
+		potentialIndexes = aa.allowedIndexes(utils.DiffSet(potentialIndexes, currentBestIndexes))
 		currentCost, err := evaluateIndexConfCost(workload, aa.optimizer, currentBestIndexes)
 		if err != nil {
 			return nil, err
@@ -160,88 +301,239 @@ func (aa *autoAdmin) cutDown(candidateIndexes utils.Set[utils.Index],
 	return aa.cutDown(candidateIndexes, w, op, maxIndexes)
 }
 
-func (aa *autoAdmin) heuristicCoveredIndexes(candidateIndexes utils.Set[utils.Index], w utils.WorkloadInfo) (utils.Set[utils.Index], error) {
-	// build an index (b, a) for `select a from t where b=1` to convert IndexLookup to IndexScan
-	currentCost, err := evaluateIndexConfCost(w, aa.optimizer, candidateIndexes)
+// removeRegressions removes indexes one by one until no query's cost exceeds its MaxCost set by the regression guard,
+// each time it removes the index without which the configuration is the best. Heuristics may add indexes without
+// comparing configurations, so the final configuration is checked here. Regressions caused by kept indexes can't be
+// removed, so it stops once no more queries regress than with the kept indexes only.
+func (aa *autoAdmin) removeRegressions(w utils.WorkloadInfo, indexes utils.Set[utils.Index]) (utils.Set[utils.Index], error) {
+	if indexes == nil {
+		return indexes, nil
+	}
+	keptIndexes := utils.NewSet[utils.Index]()
+	for _, idx := range indexes.ToList() {
+		if aa.constraints.kept(idx) {
+			keptIndexes.Add(idx)
+		}
+	}
+	keptCost, err := evaluateIndexConfCost(w, aa.optimizer, keptIndexes)
 	if err != nil {
 		return nil, err
 	}
+	for indexes.Size() > keptIndexes.Size() {
+		cost, err := evaluateIndexConfCost(w, aa.optimizer, indexes)
+		if err != nil {
+			return nil, err
+		}
+		if cost.NumRegressedQueries <= keptCost.NumRegressedQueries {
+			break
+		}
+		var bestCost utils.IndexConfCost
+		var target utils.Index
+		for _, idx := range indexes.ToList() {
+			if aa.constraints.kept(idx) {
+				continue
+			}
+			indexes.Remove(idx)
+			cost, err := evaluateIndexConfCost(w, aa.optimizer, indexes)
+			indexes.Add(idx)
+			if err != nil {
+				return nil, err
+			}
+			if target.Columns == nil || cost.Less(bestCost) {
+				bestCost, target = cost, idx
+			}
+		}
+		if target.Columns == nil {
+			break // only kept indexes are left
+		}
+		utils.Infof("auto-admin algorithm: remove index %v since %v queries regress beyond the regression guard with it",
+			target.Key(), cost.NumRegressedQueries)
+		indexes.Remove(target)
+	}
+	return indexes, nil
+}
+
+// cutDownTableLimits removes indexes on tables exceeding their index limits in the constraints, each time it removes
+// the index without which the configuration is the best, since heuristics may add indexes on the same table.
+func (aa *autoAdmin) cutDownTableLimits(indexes utils.Set[utils.Index], w utils.WorkloadInfo) (utils.Set[utils.Index], error) {
+	for aa.constraints.exceedsTableLimits(indexes) {
+		var bestCost utils.IndexConfCost
+		var target utils.Index
+		for _, idx := range indexes.ToList() {
+			limit, ok := aa.constraints.TableLimits[utils.TableName{SchemaName: idx.SchemaName, TableName: idx.TableName}]
+			if !ok || aa.constraints.kept(idx) || aa.numTableIndexes(indexes, idx) <= limit {
+				continue
+			}
+			indexes.Remove(idx)
+			cost, err := evaluateIndexConfCost(w, aa.optimizer, indexes)
+			indexes.Add(idx)
+			if err != nil {
+				return nil, err
+			}
+			if target.Columns == nil || cost.Less(bestCost) {
+				bestCost, target = cost, idx
+			}
+		}
+		if target.Columns == nil {
+			break // only kept indexes are left on these tables
+		}
+		utils.Debugf("auto-admin algorithm: remove index %v to meet the index limit of its table", target.Key())
+		indexes.Remove(target)
+	}
+	return indexes, nil
+}
+
+// numTableIndexes returns the number of indexes on the same table as idx.
+func (aa *autoAdmin) numTableIndexes(indexes utils.Set[utils.Index], idx utils.Index) int {
+	n := 0
+	for _, x := range indexes.ToList() {
+		if x.SchemaName == idx.SchemaName && x.TableName == idx.TableName {
+			n++
+		}
+	}
+	return n
+}
+
+// allowedIndexes returns indexes which are allowed by the constraints.
+func (aa *autoAdmin) allowedIndexes(indexes utils.Set[utils.Index]) utils.Set[utils.Index] {
+	allowed := utils.NewSet[utils.Index]()
+	for _, idx := range indexes.ToList() {
+		if aa.constraints.allowed(idx) {
+			allowed.Add(idx)
+		}
+	}
+	return allowed
+}
 
+func (aa *autoAdmin) heuristicCoveredIndexes(candidateIndexes utils.Set[utils.Index], w utils.WorkloadInfo) (utils.Set[utils.Index], error) {
+	// build an index (b, a) for `select a from t where b=1` to convert IndexLookUp to IndexReader, and for join queries,
+	// build such an index for each table with all its columns referenced in the query
+	views := utils.NewViewResolver(w.TableSchemas, w.Views)
 	for _, q := range w.Queries.ToList() {
-		// parse select columns
-		selectCols, err := utils.ParseSelectColumnsFromQuery(q)
+		coverIndexes, aliases, err := coveringIndexes(q, views)
 		if err != nil {
 			return nil, err
 		}
-		if selectCols == nil || selectCols.Size() == 0 || selectCols.Size() > aa.maxIndexWidth {
+		if len(coverIndexes) == 0 {
 			continue
 		}
-		schemaName, tableName := selectCols.ToList()[0].SchemaName, selectCols.ToList()[0].TableName
-
-		// generate cover-index candidates
-		coverIndexSet := utils.NewSet[utils.Index]()
-		coverIndexSet.Add(utils.Index{
-			SchemaName: schemaName,
-			TableName:  tableName,
-			IndexName:  tempIndexName(selectCols.ToList()...),
-			Columns:    selectCols.ToList(),
-		})
-		for _, idx := range candidateIndexes.ToList() {
-			if idx.SchemaName != schemaName || idx.TableName != tableName {
-				continue // not for the same table
-			}
-			if len(idx.Columns)+selectCols.Size() > aa.maxIndexWidth {
+		plan, err := explainWithIndexes(aa.optimizer, q, candidateIndexes)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, coverIndex := range coverIndexes {
+			if len(coverIndex.Columns) > aa.maxIndexWidth {
 				continue // exceed the max-index-width limitation
 			}
-			// try this cover-index: idx-cols + select-cols
-			var newCols []utils.Column
-			for _, col := range selectCols.ToList() {
-				duplicated := false
-				for _, idxCol := range idx.Columns {
-					if col.Key() == idxCol.Key() {
-						duplicated = true
-						break
+			table := strings.ToLower(coverIndex.TableName)
+			if !parsePlanTree(plan).readers(table, aliases).Contains(planReader("IndexLookUp")) {
+				continue // no table lookups to eliminate
+			}
+
+			// generate cover-index candidates: the cover-index itself, and existing indexes extended by it
+			coverIndexSet := utils.NewSet[utils.Index]()
+			coverIndexSet.Add(coverIndex)
+			for _, idx := range candidateIndexes.ToList() {
+				if idx.SchemaName != coverIndex.SchemaName || idx.TableName != coverIndex.TableName {
+					continue // not for the same table
+				}
+				cols := append([]utils.Column{}, idx.Columns...)
+				for _, col := range coverIndex.Columns {
+					if !containsColumn(cols, col) {
+						cols = append(cols, col)
 					}
 				}
-				if !duplicated {
-					newCols = append(newCols, col)
+				if len(cols) > aa.maxIndexWidth || len(cols) == len(idx.Columns) {
+					continue // exceed the max-index-width limitation, or covering already
 				}
+				coverIndexSet.Add(utils.NewIndexWithColumns(tempIndexName(cols...), cols...))
+			}
+
+			// select the best cover-index which turns IndexLookUp into IndexReader
+			var bestCoverIndex utils.Index
+			var bestCoverIndexCost utils.IndexConfCost
+			var bestPlan utils.Plan
+			for _, idx := range coverIndexSet.ToList() {
+				if candidateIndexes.Contains(idx) || !aa.constraints.allowed(idx) {
+					continue
+				}
+				candidateIndexes.Add(idx)
+				newPlan, err := explainWithIndexes(aa.optimizer, q, candidateIndexes)
+				if err != nil {
+					return nil, err
+				}
+				readers := parsePlanTree(newPlan).readers(table, aliases)
+				if readers.Contains(planReader("IndexLookUp")) || !readers.Contains(planReader("IndexReader")) {
+					candidateIndexes.Remove(idx)
+					continue
+				}
+				cost, err := evaluateIndexConfCost(w, aa.optimizer, candidateIndexes)
+				candidateIndexes.Remove(idx)
+				if err != nil {
+					return nil, err
+				}
+				if bestPlan == nil || cost.Less(bestCoverIndexCost) {
+					bestCoverIndex, bestCoverIndexCost, bestPlan = idx, cost, newPlan
+				}
+			}
+			if bestPlan != nil {
+				utils.Debugf("auto-admin algorithm: index %v covers table %v of %v", bestCoverIndex.Key(), table, q.Alias)
+				candidateIndexes.Add(bestCoverIndex)
+				plan = bestPlan
 			}
-			var cols []utils.Column
-			cols = append(cols, idx.Columns...)
-			cols = append(cols, newCols...)
-			coverIndexSet.Add(utils.Index{
-				SchemaName: schemaName,
-				TableName:  tableName,
-				IndexName:  tempIndexName(cols...),
-				Columns:    cols,
-			})
 		}
+	}
+
+	return candidateIndexes, nil
+}
 
-		// select the best cover-index
-		var bestCoverIndex utils.Index
-		var bestCoverIndexCost utils.IndexConfCost
-		for i, coverIndex := range coverIndexSet.ToList() {
-			candidateIndexes.Add(coverIndex)
-			cost, err := evaluateIndexConfCost(w, aa.optimizer, candidateIndexes)
+func (aa *autoAdmin) heuristicOrderLimitIndexes(candidateIndexes utils.Set[utils.Index], w utils.WorkloadInfo) (utils.Set[utils.Index], error) {
+	// build an index (a, b) for `select * from t where a=1 order by b limit 10` to eliminate the TopN operator, since the
+	// index can provide the order, which is common in pagination queries.
+	views := utils.NewViewResolver(w.TableSchemas, w.Views)
+	for _, q := range w.Queries.ToList() {
+		eqCols, orderCols, ok, err := orderLimitColumns(q, views)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		plan, err := explainWithIndexes(aa.optimizer, q, candidateIndexes)
+		if err != nil {
+			return nil, err
+		}
+		if !hasSortOperator(plan) {
+			continue // the order is provided by current indexes already
+		}
+
+		// try the equality columns + the order-by columns first, and then the order-by columns only, equality columns
+		// which exceed the max index width are left as filters, which don't break the order
+		var candidates [][]utils.Column
+		if numEQ := utils.Min(len(eqCols), aa.maxIndexWidth-len(orderCols)); numEQ > 0 {
+			candidates = append(candidates, append(append([]utils.Column{}, eqCols[:numEQ]...), orderCols...))
+		}
+		if len(orderCols) <= aa.maxIndexWidth {
+			candidates = append(candidates, orderCols)
+		}
+		for _, cols := range candidates {
+			idx := utils.NewIndexWithColumns(tempIndexName(cols...), cols...)
+			if candidateIndexes.Contains(idx) || !aa.constraints.allowed(idx) {
+				continue
+			}
+			candidateIndexes.Add(idx)
+			plan, err := explainWithIndexes(aa.optimizer, q, candidateIndexes)
 			if err != nil {
 				return nil, err
 			}
-			candidateIndexes.Remove(coverIndex)
-
-			if i == 0 || cost.Less(bestCoverIndexCost) {
-				bestCoverIndexCost = cost
-				bestCoverIndex = coverIndex
+			if !hasSortOperator(plan) { // accept it if the TopN or Sort operator is eliminated
+				utils.Debugf("auto-admin algorithm: index %v eliminates the sort of %v", idx.Key(), q.Alias)
+				break
 			}
-		}
-
-		// check whether this cover-index can bring any benefits
-		if bestCoverIndexCost.Less(currentCost) {
-			candidateIndexes.Add(bestCoverIndex)
-			currentCost = bestCoverIndexCost
+			candidateIndexes.Remove(idx)
 		}
 	}
-
 	return candidateIndexes, nil
 }
 
@@ -302,6 +594,7 @@ func (aa *autoAdmin) heuristicMergeIndexes(candidateIndexes utils.Set[utils.Inde
 				newIndexes.Add(idx)
 			}
 		}
+		newIndexes = aa.allowedIndexes(newIndexes)
 		if newIndexes.Size() == 0 {
 			continue
 		}
@@ -324,6 +617,39 @@ func (aa *autoAdmin) heuristicMergeIndexes(candidateIndexes utils.Set[utils.Inde
 	return candidateIndexes, nil
 }
 
+// compositeCandidates returns prefixes with the given width of composite candidate indexes of the workload, and
+// expression and prefix candidates for the width 1 since other single-column candidates come from indexable columns.
+func (aa *autoAdmin) compositeCandidates(workload utils.WorkloadInfo, width int) utils.Set[utils.Index] {
+	candidates := utils.NewSet[utils.Index]()
+	if workload.CandidateIndexes == nil {
+		return candidates
+	}
+	for _, index := range workload.CandidateIndexes.ToList() {
+		if width == 1 && index.Columns[0].IsPlain() {
+			continue
+		}
+		if len(index.Columns) >= width {
+			cols := index.Columns[:width]
+			candidates.Add(utils.NewIndexWithColumns(tempIndexName(cols...), cols...))
+		}
+	}
+	return candidates
+}
+
+// nextCandidateColumns returns columns following the index in composite candidates which have the index as a prefix.
+func (aa *autoAdmin) nextCandidateColumns(workload utils.WorkloadInfo, index utils.Index) utils.Set[utils.Column] {
+	cols := utils.NewSet[utils.Column]()
+	if workload.CandidateIndexes == nil {
+		return cols
+	}
+	for _, candidate := range workload.CandidateIndexes.ToList() {
+		if len(candidate.Columns) > len(index.Columns) && candidate.PrefixContain(index) {
+			cols.Add(candidate.Columns[len(index.Columns)])
+		}
+	}
+	return cols
+}
+
 func (aa *autoAdmin) createMultiColumnIndexes(workload utils.WorkloadInfo, indexes utils.Set[utils.Index]) utils.Set[utils.Index] {
 	multiColumnCandidates := utils.NewSet[utils.Index]()
 	for _, index := range indexes.ToList() {
@@ -334,7 +660,12 @@ func (aa *autoAdmin) createMultiColumnIndexes(workload utils.WorkloadInfo, index
 		tableColsSet := utils.ListToSet[utils.Column](table.Columns...)
 		indexableColsSet := workload.IndexableColumns
 		indexColsSet := utils.ListToSet[utils.Column](index.Columns...)
-		for _, column := range utils.DiffSet(utils.AndSet(tableColsSet, indexableColsSet), indexColsSet).ToList() {
+		nextColsSet := utils.DiffSet(utils.AndSet(tableColsSet, indexableColsSet), indexColsSet)
+		if candidateColsSet := aa.nextCandidateColumns(workload, index); candidateColsSet.Size() > 0 {
+			// follow the column orders of composite candidates instead of trying all columns
+			nextColsSet = candidateColsSet
+		}
+		for _, column := range nextColsSet.ToList() {
 			cols := append([]utils.Column{}, index.Columns...)
 			cols = append(cols, column)
 			multiColumnCandidates.Add(utils.Index{
@@ -352,7 +683,6 @@ func (aa *autoAdmin) createMultiColumnIndexes(workload utils.WorkloadInfo, index
 // Rule 1: if index X is a prefix of index Y, then remove X.
 // Rule 2: if index X has no any benefit, then remove X.
 // Rule 3: if candidate index X is a prefix of some existing index in the workload, then remove X.
-// Rule 4(TBD): remove unnecessary suffix columns, e.g. X(a, b, c) to X(a, b) if no query can gain benefit from the suffix column c.
 func (aa *autoAdmin) filterIndexes(workload utils.WorkloadInfo, indexes utils.Set[utils.Index]) (utils.Set[utils.Index], error) {
 	indexList := indexes.ToList()
 	filteredIndexes := utils.NewSet[utils.Index]()
@@ -406,6 +736,90 @@ func (aa *autoAdmin) filterIndexes(workload utils.WorkloadInfo, indexes utils.Se
 	return filteredIndexes, nil
 }
 
+// trimCostTolerance is how much the workload cost can increase when trimming columns from an index.
+const trimCostTolerance = 0.01
+
+// trimIndexes replaces each recommended index with its shortest variant from trimVariants whose workload cost stays
+// within trimCostTolerance of the full index, e.g. X(a, b, c) to X(a, b) if no query can gain benefit from the suffix
+// column c, and records the trimmed columns in the variant. It's applied once to the final recommended indexes, and
+// indexes kept by the constraints are never trimmed.
+func (aa *autoAdmin) trimIndexes(workload utils.WorkloadInfo, indexes utils.Set[utils.Index]) (utils.Set[utils.Index], error) {
+	if indexes == nil {
+		return indexes, nil
+	}
+	for _, x := range indexes.ToList() {
+		if len(x.Columns) < 2 || aa.constraints.kept(x) {
+			continue
+		}
+		fullCost, err := evaluateIndexConfCost(workload, aa.optimizer, indexes)
+		if err != nil {
+			return nil, err
+		}
+
+		indexes.Remove(x)
+		var best utils.Index
+		var bestCost utils.IndexConfCost
+		for _, cols := range trimVariants(x.Columns) {
+			if best.Columns != nil && len(cols) > len(best.Columns) {
+				break // variants are ordered by their widths
+			}
+			variant := utils.NewIndexWithColumns(tempIndexName(cols...), cols...)
+			existing := indexes.Contains(variant)
+			indexes.Add(variant)
+			cost, err := evaluateIndexConfCost(workload, aa.optimizer, indexes)
+			if !existing {
+				indexes.Remove(variant)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if cost.TotalWorkloadQueryCost > fullCost.TotalWorkloadQueryCost*(1+trimCostTolerance) ||
+				cost.NumRegressedQueries > fullCost.NumRegressedQueries {
+				continue
+			}
+			if best.Columns == nil || cost.TotalWorkloadQueryCost < bestCost.TotalWorkloadQueryCost {
+				best, bestCost = variant, cost
+			}
+		}
+		if best.Columns == nil {
+			indexes.Add(x)
+			continue
+		}
+
+		var trimmed []string
+		for _, col := range x.Columns {
+			if !containsColumn(best.Columns, col) {
+				trimmed = append(trimmed, col.KeyPart())
+			}
+		}
+		best.Trimmed = fmt.Sprintf("trim %v from %v since the workload cost changes by %+.2f%% without them",
+			strings.Join(trimmed, ", "), strings.Join(x.KeyParts(), ", "),
+			100*(bestCost.TotalWorkloadQueryCost/fullCost.TotalWorkloadQueryCost-1))
+		if x.Trimmed != "" {
+			best.Trimmed = x.Trimmed + "; " + best.Trimmed
+		}
+		utils.Infof("auto-admin algorithm: %v: %v", best.Key(), best.Trimmed)
+		indexes.Add(best) // replace the existing one, if any, to keep the trimmed columns
+	}
+	return indexes, nil
+}
+
+// trimVariants returns variants of the index columns with fewer columns ordered by their widths, which are shorter
+// prefixes, and columns without one of the middle columns since removing the last one is a prefix.
+func trimVariants(cols []utils.Column) [][]utils.Column {
+	var variants [][]utils.Column
+	for width := 1; width < len(cols); width++ {
+		variants = append(variants, cols[:width])
+		if width == len(cols)-1 {
+			for i := 1; i < len(cols)-1; i++ {
+				variant := append(append([]utils.Column{}, cols[:i]...), cols[i+1:]...)
+				variants = append(variants, variant)
+			}
+		}
+	}
+	return variants
+}
+
 // selectIndexCandidates selects the best indexes for each single-query.
 func (aa *autoAdmin) selectIndexCandidates(workload utils.WorkloadInfo, potentialIndexes utils.Set[utils.Index]) (utils.Set[utils.Index], error) {
 	utils.Debugf("auto-admin algorithm: select best index from %v candidates for each single query(total %v)", potentialIndexes.Size(), workload.Queries.Size())
@@ -476,6 +890,9 @@ func (aa *autoAdmin) enumerateGreedy(workload utils.WorkloadInfo, currentIndexes
 		if newCombination.Size() != currentIndexes.Size()+1 {
 			continue // duplicated index
 		}
+		if aa.constraints.exceedsTableLimits(newCombination) {
+			continue
+		}
 		indexCombinations = append(indexCombinations, newCombination)
 	}
 	if len(indexCombinations) == 0 {
@@ -506,7 +923,11 @@ func (aa *autoAdmin) enumerateNaive(workload utils.WorkloadInfo, candidateIndexe
 	// get all index combinations
 	indexCombinations := make([]utils.Set[utils.Index], 0, 128)
 	for numberOfIndexes := 1; numberOfIndexes <= numberIndexesNaive; numberOfIndexes++ {
-		indexCombinations = append(indexCombinations, utils.CombSet(candidateIndexes, numberOfIndexes)...)
+		for _, combination := range utils.CombSet(candidateIndexes, numberOfIndexes) {
+			if !aa.constraints.exceedsTableLimits(combination) {
+				indexCombinations = append(indexCombinations, combination)
+			}
+		}
 	}
 	if len(indexCombinations) > 32 {
 		utils.Infof("auto-admin algorithm: find %v index combinations", len(indexCombinations))
@@ -526,11 +947,23 @@ func (aa *autoAdmin) enumerateNaive(workload utils.WorkloadInfo, candidateIndexe
 func (aa *autoAdmin) potentialIndexesForQuery(query utils.Query, potentialIndexes utils.Set[utils.Index]) utils.Set[utils.Index] {
 	indexes := utils.NewSet[utils.Index]()
 	for _, index := range potentialIndexes.ToList() {
+		if !aa.constraints.allowed(index) {
+			continue
+		}
 		// The leading index column must be referenced by the query.
 		if query.IndexableColumns.Contains(index.Columns[0]) {
 			indexes.Add(index)
 		}
 	}
+	// Proposed indexes are considered for all queries referencing their tables.
+	for _, index := range aa.constraints.ProposedIndexes {
+		for _, col := range query.IndexableColumns.ToList() {
+			if col.SchemaName == index.SchemaName && col.TableName == index.TableName {
+				indexes.Add(index)
+				break
+			}
+		}
+	}
 	return indexes
 }
 
@@ -2,6 +2,9 @@ package advisor
 
 import (
 	"fmt"
+	"math"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/qw4990/index_advisor/optimizer"
@@ -47,3 +50,256 @@ func TestSimulateAndCost(t *testing.T) {
 		fmt.Println(">> ", p)
 	}
 }
+
+func TestTrimVariants(t *testing.T) {
+	cols := func(names ...string) []utils.Column {
+		var cs []utils.Column
+		for _, name := range names {
+			cs = append(cs, utils.NewColumn("test", "t", name))
+		}
+		return cs
+	}
+	keys := func(variants [][]utils.Column) []string {
+		var ks []string
+		for _, v := range variants {
+			var names []string
+			for _, c := range v {
+				names = append(names, c.ColumnName)
+			}
+			ks = append(ks, strings.Join(names, ","))
+		}
+		return ks
+	}
+	for _, c := range []struct {
+		cols     []string
+		variants string
+	}{
+		{[]string{"a"}, ""},
+		{[]string{"a", "b"}, "a"},
+		{[]string{"a", "b", "c"}, "a|a,b|a,c"},
+		{[]string{"a", "b", "c", "d"}, "a|a,b|a,b,c|a,c,d|a,b,d"},
+	} {
+		if got := strings.Join(keys(trimVariants(cols(c.cols...))), "|"); got != c.variants {
+			t.Fatalf("variants of %v: expected %v, got %v", c.cols, c.variants, got)
+		}
+	}
+}
+
+// fakeOptimizer estimates query costs from a fixed table instead of TiDB, to test selection decisions deterministically.
+type fakeOptimizer struct {
+	optimizer.WhatIfOptimizer // methods not used by the selection algorithm are not implemented
+
+	costs   map[string]map[string]float64 // query text -> index key -> cost of the query with the index, "" for no index
+	indexes utils.Set[utils.Index]
+}
+
+func newFakeOptimizer(costs map[string]map[string]float64) *fakeOptimizer {
+	return &fakeOptimizer{costs: costs, indexes: utils.NewSet[utils.Index]()}
+}
+
+func (o *fakeOptimizer) Clone() (optimizer.WhatIfOptimizer, error) {
+	return newFakeOptimizer(o.costs), nil
+}
+
+func (o *fakeOptimizer) Close() error {
+	return nil
+}
+
+func (o *fakeOptimizer) ResetStats() {}
+
+func (o *fakeOptimizer) Stats() optimizer.WhatIfOptimizerStats {
+	return optimizer.WhatIfOptimizerStats{}
+}
+
+func (o *fakeOptimizer) CreateHypoIndex(index utils.Index) error {
+	o.indexes.Add(index)
+	return nil
+}
+
+func (o *fakeOptimizer) DropHypoIndex(index utils.Index) error {
+	o.indexes.Remove(index)
+	return nil
+}
+
+func (o *fakeOptimizer) ExplainQ(q utils.Query) (utils.Plan, error) {
+	return o.Explain(q.Text)
+}
+
+// Explain returns a plan with the least cost of the query under the created indexes. An index with a cost higher than
+// the baseline models a bad plan choice, which is used even if other indexes are cheaper.
+func (o *fakeOptimizer) Explain(query string) (utils.Plan, error) {
+	costs, ok := o.costs[query]
+	if !ok {
+		return nil, fmt.Errorf("unknown query %v", query)
+	}
+	cost, regressed := costs[""], 0.0
+	for _, idx := range o.indexes.ToList() {
+		c, ok := costs[idx.Key()]
+		if ok && c > costs[""] {
+			regressed = math.Max(regressed, c)
+		} else if ok && c < cost {
+			cost = c
+		}
+	}
+	if regressed > 0 {
+		cost = regressed
+	}
+	return utils.Plan{{"TableReader_1", "1.00", fmt.Sprint(cost), "root", "", ""}}, nil
+}
+
+type selectionCase struct {
+	queries []string                      // aliases are q1, q2, ... in order
+	costs   map[string]map[string]float64 // alias -> index key -> cost of the query with the index, "" for no index
+	targets map[string]float64            // alias -> the required cost reduction percentage of the target query
+	param   Parameter
+	indexes []string // indexes passed to the tested function, e.g. "t(a)"
+	result  []string
+}
+
+// runSelectionCases runs the cases against the selection step fn on tables t(a, b, c) and s(x, y) with fake costs.
+func runSelectionCases(t *testing.T, cases []selectionCase,
+	fn func(aa *autoAdmin, w utils.WorkloadInfo, indexes utils.Set[utils.Index]) (utils.Set[utils.Index], error)) {
+	createTableStmts := []string{`create table t (a int, b int, c int)`, `create table s (x int, y int)`}
+	parseIndex := func(s string) utils.Index {
+		i := strings.Index(s, "(")
+		cols := strings.Split(s[i+1:len(s)-1], ",")
+		return utils.NewIndex("test", s[:i], "idx_"+strings.Join(cols, "_"), cols...)
+	}
+	for i, c := range cases {
+		w, err := utils.CreateWorkloadFromRawStmt("test", createTableStmts, nil)
+		must(err)
+		costs := make(map[string]map[string]float64)
+		for j, text := range c.queries {
+			alias := fmt.Sprintf("q%v", j+1)
+			w.Queries.Add(utils.Query{Alias: alias, SchemaName: "test", Text: text, Frequency: 1, TargetReduction: c.targets[alias]})
+			costs[text] = make(map[string]float64)
+			for idx, cost := range c.costs[alias] {
+				if idx != "" {
+					idx = parseIndex(idx).Key()
+				}
+				costs[text][idx] = cost
+			}
+		}
+		must(IndexableColumnsSelectionSimple(&w))
+		op := newFakeOptimizer(costs)
+		param := validateParameter(c.param)
+		param.Constraints = param.Constraints.validate(w.TableSchemas)
+		must(setRegressionGuard(&w, param, op))
+		aa := &autoAdmin{
+			optimizer:     op,
+			tmpOptimizers: []optimizer.WhatIfOptimizer{newFakeOptimizer(costs), newFakeOptimizer(costs)},
+			maxIndexes:    param.MaxNumberIndexes,
+			maxIndexWidth: param.MaxIndexWidth,
+			constraints:   param.Constraints,
+		}
+		indexes := utils.NewSet[utils.Index]()
+		for _, idx := range c.indexes {
+			indexes.Add(parseIndex(idx))
+		}
+		result, err := fn(aa, w, indexes)
+		must(err)
+
+		var resultKeys, expectedKeys []string
+		for _, r := range result.ToList() {
+			resultKeys = append(resultKeys, r.Key())
+		}
+		for _, r := range c.result {
+			expectedKeys = append(expectedKeys, parseIndex(r).Key())
+		}
+		sort.Strings(resultKeys)
+		sort.Strings(expectedKeys)
+		if strings.Join(expectedKeys, ",") != strings.Join(resultKeys, ",") {
+			t.Errorf("case: %v, expected: %v, actual: %v, query: %v", i, expectedKeys, resultKeys, c.queries)
+		}
+	}
+}
+
+func parseTestConstraints(spec ConstraintsSpec) Constraints {
+	c, err := ParseConstraints(spec, "test")
+	must(err)
+	return c
+}
+
+func TestRemoveRegressions(t *testing.T) {
+	queries := []string{`select * from t where a=1`, `select * from t where b=1`}
+	costs := map[string]map[string]float64{
+		"q1": {"": 100, "t(a)": 10, "t(b)": 150}, // t(b) makes q1 choose a bad plan
+		"q2": {"": 1000, "t(b)": 10},
+	}
+	removeRegressions := func(aa *autoAdmin, w utils.WorkloadInfo, indexes utils.Set[utils.Index]) (utils.Set[utils.Index], error) {
+		return aa.removeRegressions(w, indexes)
+	}
+	runSelectionCases(t, []selectionCase{
+		// no guard, the regression is allowed
+		{queries, costs, nil, Parameter{MaxNumberIndexes: 2, MaxIndexWidth: 1},
+			[]string{"t(a)", "t(b)"}, []string{"t(a)", "t(b)"}},
+		// q1 rises by 50%, t(b) is removed even if it reduces the workload cost
+		{queries, costs, nil, Parameter{MaxNumberIndexes: 2, MaxIndexWidth: 1, RegressionGuard: true},
+			[]string{"t(a)", "t(b)"}, []string{"t(a)"}},
+		{queries, costs, nil, Parameter{MaxNumberIndexes: 2, MaxIndexWidth: 1, RegressionGuard: true, MaxQueryRegression: 60},
+			[]string{"t(a)", "t(b)"}, []string{"t(a)", "t(b)"}},
+		// protected queries must not regress at all
+		{queries, costs, nil, Parameter{MaxNumberIndexes: 2, MaxIndexWidth: 1, ProtectedQueries: []string{"q1"}},
+			[]string{"t(a)", "t(b)"}, []string{"t(a)"}},
+		{queries, costs, nil, Parameter{MaxNumberIndexes: 2, MaxIndexWidth: 1, ProtectedQueries: []string{"q2"}},
+			[]string{"t(a)", "t(b)"}, []string{"t(a)", "t(b)"}},
+		// kept indexes are never removed
+		{queries, costs, nil, Parameter{MaxNumberIndexes: 2, MaxIndexWidth: 1, RegressionGuard: true,
+			Constraints: parseTestConstraints(ConstraintsSpec{KeepIndexes: []string{"t(b)"}})},
+			[]string{"t(a)", "t(b)"}, []string{"t(a)", "t(b)"}},
+	}, removeRegressions)
+}
+
+func TestSelectTargetIndexes(t *testing.T) {
+	queries := []string{`select * from t where a=1`, `select * from t where b=1`, `select * from s where x=1`}
+	costs := map[string]map[string]float64{
+		"q1": {"": 10000, "t(a)": 10},
+		"q2": {"": 100, "t(b)": 40},
+		"q3": {"": 1000, "s(x)": 10},
+	}
+	calculateBestIndexes := func(aa *autoAdmin, w utils.WorkloadInfo, _ utils.Set[utils.Index]) (utils.Set[utils.Index], error) {
+		return aa.calculateBestIndexesWithRequirements(w)
+	}
+	runSelectionCases(t, []selectionCase{
+		{queries, costs, nil, Parameter{MaxNumberIndexes: 1, MaxIndexWidth: 1}, nil, []string{"t(a)"}},
+		// targets are met first even if other indexes reduce the workload cost more
+		{queries, costs, map[string]float64{"q2": 50}, Parameter{MaxNumberIndexes: 1, MaxIndexWidth: 1}, nil, []string{"t(b)"}},
+		{queries, costs, map[string]float64{"q2": 50, "q3": 50}, Parameter{MaxNumberIndexes: 2, MaxIndexWidth: 1}, nil, []string{"t(b)", "s(x)"}},
+		// the remaining budget is used for the whole workload
+		{queries, costs, map[string]float64{"q2": 50}, Parameter{MaxNumberIndexes: 2, MaxIndexWidth: 1}, nil, []string{"t(a)", "t(b)"}},
+		// no budget is spent on targets which can't be met
+		{queries, costs, map[string]float64{"q2": 70}, Parameter{MaxNumberIndexes: 1, MaxIndexWidth: 1}, nil, []string{"t(a)"}},
+		// kept indexes are selected first and count in the budget
+		{queries, costs, map[string]float64{"q2": 50}, Parameter{MaxNumberIndexes: 2, MaxIndexWidth: 1,
+			Constraints: parseTestConstraints(ConstraintsSpec{KeepIndexes: []string{"s(x)"}})}, nil, []string{"s(x)", "t(b)"}},
+		{queries, costs, map[string]float64{"q2": 50}, Parameter{MaxNumberIndexes: 1, MaxIndexWidth: 1,
+			Constraints: parseTestConstraints(ConstraintsSpec{KeepIndexes: []string{"s(x)"}})}, nil, []string{"s(x)"}},
+	}, calculateBestIndexes)
+}
+
+func TestCutDownTableLimits(t *testing.T) {
+	queries := []string{`select * from t where a=1`, `select * from t where b=1`, `select * from s where x=1`}
+	costs := map[string]map[string]float64{
+		"q1": {"": 1000, "t(a)": 10},
+		"q2": {"": 1000, "t(b)": 100},
+		"q3": {"": 1000, "s(x)": 10},
+	}
+	cutDownTableLimits := func(aa *autoAdmin, w utils.WorkloadInfo, indexes utils.Set[utils.Index]) (utils.Set[utils.Index], error) {
+		return aa.cutDownTableLimits(indexes, w)
+	}
+	limits := func(spec ConstraintsSpec) Parameter {
+		return Parameter{MaxNumberIndexes: 3, MaxIndexWidth: 1, Constraints: parseTestConstraints(spec)}
+	}
+	indexes := []string{"t(a)", "t(b)", "s(x)"}
+	runSelectionCases(t, []selectionCase{
+		{queries, costs, nil, limits(ConstraintsSpec{}), indexes, []string{"t(a)", "t(b)", "s(x)"}},
+		// the index whose removal increases the workload cost the least is removed first
+		{queries, costs, nil, limits(ConstraintsSpec{TableIndexLimits: map[string]int{"t": 1}}), indexes, []string{"t(a)", "s(x)"}},
+		{queries, costs, nil, limits(ConstraintsSpec{TableIndexLimits: map[string]int{"t": 0, "s": 1}}), indexes, []string{"s(x)"}},
+		// kept indexes are never removed even if they exceed the limits
+		{queries, costs, nil, limits(ConstraintsSpec{TableIndexLimits: map[string]int{"t": 1}, KeepIndexes: []string{"t(b)"}}),
+			indexes, []string{"t(b)", "s(x)"}},
+		{queries, costs, nil, limits(ConstraintsSpec{TableIndexLimits: map[string]int{"t": 0}, KeepIndexes: []string{"t(b)"}}),
+			indexes, []string{"t(b)", "s(x)"}},
+	}, cutDownTableLimits)
+}
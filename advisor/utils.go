@@ -2,6 +2,7 @@ package advisor
 
 import (
 	"fmt"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
@@ -56,12 +57,16 @@ func evaluateIndexConfCost(info utils.WorkloadInfo, optimizer optimizer.WhatIfOp
 		}
 	}
 	var workloadCost float64
+	var numRegressed int
 	for _, sql := range info.Queries.ToList() { // TODO: run them concurrently to save time
 		p, err := optimizer.ExplainQ(sql)
 		if err != nil {
 			return utils.IndexConfCost{}, err
 		}
-		workloadCost += p.PlanCost() * float64(sql.Frequency)
+		workloadCost += p.PlanCost() * sql.CostWeight()
+		if sql.MaxCost > 0 && p.PlanCost() > sql.MaxCost {
+			numRegressed++
+		}
 	}
 	for _, index := range indexes.ToList() {
 		if err := optimizer.DropHypoIndex(index); err != nil {
@@ -76,16 +81,54 @@ func evaluateIndexConfCost(info utils.WorkloadInfo, optimizer optimizer.WhatIfOp
 	}
 	sort.Strings(keys)
 
-	return utils.IndexConfCost{workloadCost, totCols, strings.Join(keys, ",")}, nil
+	return utils.IndexConfCost{
+		TotalWorkloadQueryCost:    workloadCost,
+		TotalNumberOfIndexColumns: totCols,
+		IndexKeysStr:              strings.Join(keys, ","),
+		NumRegressedQueries:       numRegressed,
+	}, nil
+}
+
+// explainWithIndexes returns the plan of the query under the given indexes.
+func explainWithIndexes(optimizer optimizer.WhatIfOptimizer, q utils.Query, indexes utils.Set[utils.Index]) (utils.Plan, error) {
+	for _, index := range indexes.ToList() {
+		if err := optimizer.CreateHypoIndex(index); err != nil {
+			return nil, err
+		}
+	}
+	plan, err := optimizer.ExplainQ(q)
+	for _, index := range indexes.ToList() {
+		if dropErr := optimizer.DropHypoIndex(index); dropErr != nil && err == nil {
+			err = dropErr
+		}
+	}
+	return plan, err
+}
+
+// hasSortOperator returns whether the plan sorts rows by a TopN or Sort operator.
+func hasSortOperator(plan utils.Plan) bool {
+	for _, op := range parsePlanTree(plan) {
+		if strings.HasPrefix(op.name, "TopN_") || strings.HasPrefix(op.name, "Sort_") {
+			return true
+		}
+	}
+	return false
 }
 
-var indexID atomic.Int64
+var (
+	indexID            atomic.Int64
+	nonNameCharsRegexp = regexp.MustCompile(`[^a-z0-9_]+`)
+)
 
 // tempIndexName returns a temp index name for the given columns.
 func tempIndexName(cols ...utils.Column) string {
 	var names []string
 	for _, col := range cols {
-		names = append(names, col.ColumnName)
+		if !col.IsPlain() { // `(lower(email))` -> `lower_email`, `url(32)` -> `url_32`
+			names = append(names, strings.Trim(nonNameCharsRegexp.ReplaceAllString(strings.ToLower(col.KeyPart()), "_"), "_"))
+		} else {
+			names = append(names, col.ColumnName)
+		}
 	}
 	idxName := fmt.Sprintf("idx_%v", strings.Join(names, "_"))
 	if len(idxName) <= 64 {
@@ -110,6 +153,15 @@ func checkWorkloadInfo(w utils.WorkloadInfo) {
 			}
 		}
 	}
+	if w.CandidateIndexes != nil {
+		for _, idx := range w.CandidateIndexes.ToList() {
+			for _, col := range idx.Columns {
+				if col.SchemaName == "" || col.TableName == "" || col.ColumnName == "" {
+					panic(fmt.Sprintf("invalid candidate index: %v", idx))
+				}
+			}
+		}
+	}
 	for _, tbl := range w.TableSchemas.ToList() {
 		if tbl.SchemaName == "" || tbl.TableName == "" {
 			panic(fmt.Sprintf("invalid table schema: %v", tbl))
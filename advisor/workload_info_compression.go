@@ -1,6 +1,13 @@
 package advisor
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pingcap/parser/ast"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+	"github.com/qw4990/index_advisor/optimizer"
 	"github.com/qw4990/index_advisor/utils"
 )
 
@@ -9,29 +16,299 @@ func NoneWorkloadInfoCompress(workloadInfo utils.WorkloadInfo) utils.WorkloadInf
 	return workloadInfo
 }
 
-// DigestWorkloadInfoCompress compresses queries by digest.
-func DigestWorkloadInfoCompress(workloadInfo utils.WorkloadInfo) utils.WorkloadInfo {
+// DigestWorkloadInfoCompress compresses queries by digest, and keeps at most maxNumSamples queries with different
+// constants as samples of each digest.
+func DigestWorkloadInfoCompress(workloadInfo utils.WorkloadInfo, maxNumSamples int) utils.WorkloadInfo {
 	compressed := workloadInfo
-	compressed.Queries = compressBySQLDigest(compressed.Queries)
+	compressed.Queries = compressBySQLDigest(compressed.Queries, maxNumSamples)
 	return compressed
 }
 
-func compressBySQLDigest(sqls utils.Set[utils.Query]) utils.Set[utils.Query] {
-	s := utils.NewSet[utils.Query]()
-	digestFreq := make(map[string]int)
-	digestSQL := make(map[string]utils.Query)
+// compressBySQLDigest groups queries by digest and keeps at most maxNumSamples samples with diverse constants for each
+// digest, e.g. both `status = 'active'` and `status = 'deleted'`. Frequencies and importance of other queries are
+// distributed to the samples in proportion to their own ones, so the cost of a digest is the weighted average over its
+// samples times its total weight.
+func compressBySQLDigest(sqls utils.Set[utils.Query], maxNumSamples int) utils.Set[utils.Query] {
+	var digests []string
+	digestSQLs := make(map[string][]utils.Query)
 	for _, sql := range sqls.ToList() {
 		_, digest := utils.NormalizeDigest(sql.Text)
-		if _, ok := digestFreq[digest]; ok {
-			digestFreq[digest] += sql.Frequency
-			existingSQL := digestSQL[digest]
-			existingSQL.Frequency = digestFreq[digest]
-			s.Add(existingSQL)
-		} else {
-			digestFreq[digest] = sql.Frequency
-			digestSQL[digest] = sql
-			s.Add(sql)
+		if _, ok := digestSQLs[digest]; !ok {
+			digests = append(digests, digest)
+		}
+		digestSQLs[digest] = append(digestSQLs[digest], sql)
+	}
+
+	s := utils.NewSet[utils.Query]()
+	for _, digest := range digests {
+		group := digestSQLs[digest]
+		total, totalImportance := 0, 0.0
+		for _, sql := range group {
+			total += sql.Frequency
+			totalImportance += sql.Importance
+		}
+		samples := pickDiverseSamples(group, maxNumSamples)
+		freqs := make([]int, len(samples))
+		var sampleImportance float64
+		for i, sql := range samples {
+			freqs[i] = sql.Frequency
+			sampleImportance += sql.Importance
+		}
+		for i, freq := range scaleFrequencies(freqs, total) {
+			samples[i].Frequency = freq
+			if sampleImportance > 0 {
+				samples[i].Importance *= totalImportance / sampleImportance
+			}
+			s.Add(samples[i])
 		}
 	}
 	return s
 }
+
+// pickDiverseSamples picks at most n (at least 1) queries with the same digest, it starts from the most important one
+// (target queries first), and then repeatedly picks the one with the most constants not seen at the same positions in
+// picked ones, until there is no new constant.
+func pickDiverseSamples(queries []utils.Query, n int) []utils.Query {
+	queries = append([]utils.Query{}, queries...)
+	sort.SliceStable(queries, func(i, j int) bool {
+		if isTarget := queries[i].TargetReduction > 0; isTarget != (queries[j].TargetReduction > 0) {
+			return isTarget
+		}
+		return queries[i].CostWeight() > queries[j].CostWeight()
+	})
+	consts := make([][]string, len(queries))
+	for i, q := range queries {
+		consts[i] = queryConstants(q.Text)
+	}
+	var seen []map[string]struct{} // constants seen at each position
+	picked := make([]bool, len(queries))
+	var samples []utils.Query
+	for len(samples) < utils.Min(utils.Max(n, 1), len(queries)) {
+		best, bestNew := -1, 0
+		for i := range queries {
+			if picked[i] {
+				continue
+			}
+			numNew := 0
+			for pos, c := range consts[i] {
+				if pos >= len(seen) {
+					numNew++
+				} else if _, ok := seen[pos][c]; !ok {
+					numNew++
+				}
+			}
+			if best == -1 || numNew > bestNew {
+				best, bestNew = i, numNew
+			}
+		}
+		if len(samples) > 0 && bestNew == 0 {
+			break
+		}
+		picked[best] = true
+		samples = append(samples, queries[best])
+		for pos, c := range consts[best] {
+			if pos >= len(seen) {
+				seen = append(seen, make(map[string]struct{}))
+			}
+			seen[pos][c] = struct{}{}
+		}
+	}
+	return samples
+}
+
+// constantCollector collects constants in a statement.
+type constantCollector struct {
+	consts []string
+}
+
+func (c *constantCollector) Enter(n ast.Node) (node ast.Node, skipChildren bool) {
+	if v, ok := n.(*driver.ValueExpr); ok {
+		c.consts = append(c.consts, fmt.Sprintf("%v", v.GetValue()))
+	}
+	return n, false
+}
+
+func (c *constantCollector) Leave(n ast.Node) (node ast.Node, ok bool) {
+	return n, true
+}
+
+// queryConstants returns constants in the query in order, or nil if the query can't be parsed.
+func queryConstants(text string) []string {
+	stmt, err := utils.ParseOneSQL(text)
+	if err != nil {
+		return nil
+	}
+	c := &constantCollector{}
+	stmt.Accept(c)
+	return c.consts
+}
+
+// scaleFrequencies scales frequencies in proportion to make their sum the total, remainders are given to the ones
+// with the largest fractional parts.
+func scaleFrequencies(freqs []int, total int) []int {
+	sum := 0
+	for _, f := range freqs {
+		sum += f
+	}
+	scaled := make([]int, len(freqs))
+	if sum == 0 {
+		scaled[0] = total
+		return scaled
+	}
+	fractions := make([]float64, len(freqs))
+	left := total
+	for i, f := range freqs {
+		exact := float64(f) * float64(total) / float64(sum)
+		scaled[i] = int(exact)
+		fractions[i] = exact - float64(scaled[i])
+		left -= scaled[i]
+	}
+	order := make([]int, len(freqs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return fractions[order[i]] > fractions[order[j]] })
+	for i := 0; i < left; i++ {
+		scaled[order[i%len(order)]]++
+	}
+	return scaled
+}
+
+// CostWorkloadInfoCompress compresses queries by digest with at most maxNumSamples samples for each digest first, and
+// then keeps at most maxNumQueries queries if it's positive: queries are ranked by weight × baseline cost, the top ones
+// are kept as they are, and the rest are clustered by their table sets and indexable columns, each cluster is
+// represented by its top query whose frequency and importance are the total ones of the cluster, so the total weight
+// of the workload is preserved. The ratio of the workload cost covered by the kept queries is set as CostCoverage.
+func CostWorkloadInfoCompress(workloadInfo utils.WorkloadInfo, maxNumSamples, maxNumQueries int, op optimizer.WhatIfOptimizer) (utils.WorkloadInfo, error) {
+	compressed := DigestWorkloadInfoCompress(workloadInfo, maxNumSamples)
+	if maxNumQueries <= 0 || compressed.Queries.Size() <= maxNumQueries {
+		return compressed, nil
+	}
+
+	queries := compressed.Queries.ToList()
+	costs := make([]float64, len(queries))
+	for i, q := range queries {
+		p, err := op.ExplainQ(q)
+		if err != nil {
+			return compressed, err
+		}
+		costs[i] = p.PlanCost()
+	}
+	signatures, err := querySignatures(compressed)
+	if err != nil {
+		return compressed, err
+	}
+	retained, coverage := compressByCost(queries, costs, signatures, maxNumQueries)
+	utils.Infof("compress %v queries to %v queries within the budget %v, the retained queries cover %.2f%% of the workload cost",
+		len(queries), len(retained), maxNumQueries, coverage*100)
+	compressed.Queries = utils.ListToSet(retained...)
+	compressed.CostCoverage = coverage
+	return compressed, nil
+}
+
+// querySignatures returns signatures of queries to cluster them, which consist of tables and indexable columns of
+// queries, the key is the query text.
+func querySignatures(workloadInfo utils.WorkloadInfo) (map[string]string, error) {
+	tmp := workloadInfo
+	tmp.Queries = utils.ListToSet(workloadInfo.Queries.ToList()...) // don't touch indexable columns of the original one
+	if err := IndexableColumnsSelectionSimple(&tmp); err != nil {
+		return nil, err
+	}
+	signatures := make(map[string]string)
+	for _, q := range tmp.Queries.ToList() {
+		tables, err := utils.CollectTableNamesFromSQL(q.SchemaName, q.Text)
+		if err != nil {
+			return nil, err
+		}
+		var tableKeys, colKeys []string
+		for _, t := range tables.ToList() {
+			tableKeys = append(tableKeys, t.Key())
+		}
+		for _, c := range q.IndexableColumns.ToList() {
+			colKeys = append(colKeys, c.Key())
+		}
+		sort.Strings(tableKeys)
+		sort.Strings(colKeys)
+		signatures[q.Key()] = strings.Join(tableKeys, ",") + "|" + strings.Join(colKeys, ",")
+	}
+	return signatures, nil
+}
+
+// compressByCost keeps at most budget queries, it keeps as many top queries ranked by weight × cost as possible,
+// and clusters the rest by their signatures. Target queries are always kept as they are, even beyond the budget. If
+// there are still too many clusters when no other query is kept as it is, the clusters whose top queries have the
+// least cost are merged into one. It returns the retained queries and the ratio of the workload cost covered by them.
+func compressByCost(queries []utils.Query, costs []float64, signatures map[string]string, budget int) ([]utils.Query, float64) {
+	type rankedQuery struct {
+		utils.Query
+		score float64
+	}
+	ranked := make([]rankedQuery, len(queries))
+	var total float64
+	numTargets := 0
+	for i, q := range queries {
+		ranked[i] = rankedQuery{q, q.CostWeight() * costs[i]}
+		total += ranked[i].score
+		if q.TargetReduction > 0 {
+			numTargets++
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if isTarget := ranked[i].TargetReduction > 0; isTarget != (ranked[j].TargetReduction > 0) {
+			return isTarget
+		}
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score > ranked[j].score
+		}
+		return ranked[i].Text < ranked[j].Text
+	})
+
+	// clusters groups queries by signatures in the order of their top queries.
+	clusters := func(rest []rankedQuery) [][]rankedQuery {
+		var groups [][]rankedQuery
+		groupIdx := make(map[string]int)
+		for _, q := range rest {
+			sig := signatures[q.Key()]
+			if i, ok := groupIdx[sig]; ok {
+				groups[i] = append(groups[i], q)
+				continue
+			}
+			groupIdx[sig] = len(groups)
+			groups = append(groups, []rankedQuery{q})
+		}
+		return groups
+	}
+	top := utils.Max(utils.Min(budget-1, len(ranked)), numTargets)
+	groups := clusters(ranked[top:])
+	for ; top > numTargets && top+len(groups) > budget; top-- {
+		groups = clusters(ranked[top-1:])
+	}
+	if keep := utils.Max(budget-top, 1); len(groups) > keep { // merge the trailing clusters
+		var merged []rankedQuery
+		for _, g := range groups[keep-1:] {
+			merged = append(merged, g...)
+		}
+		sort.SliceStable(merged, func(i, j int) bool { return merged[i].score > merged[j].score })
+		groups = append(groups[:keep-1], merged)
+	}
+
+	var retained []utils.Query
+	var covered float64
+	for _, q := range ranked[:top] {
+		retained = append(retained, q.Query)
+		covered += q.score
+	}
+	for _, g := range groups {
+		rep := g[0].Query
+		covered += g[0].score
+		for _, q := range g[1:] {
+			rep.Frequency += q.Frequency
+			rep.Importance += q.Importance
+		}
+		retained = append(retained, rep)
+	}
+	if total == 0 {
+		return retained, 1
+	}
+	return retained, covered / total
+}
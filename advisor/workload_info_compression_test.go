@@ -1,6 +1,8 @@
 package advisor
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/qw4990/index_advisor/utils"
@@ -11,8 +13,97 @@ func TestDigestCompression(t *testing.T) {
 	s.Add(utils.Query{Text: "select * from t1 where a = 1", Frequency: 1})
 	s.Add(utils.Query{Text: "select * from t1 where a = 2", Frequency: 2})
 	s.Add(utils.Query{Text: "select * from t1 where a = 3", Frequency: 3})
-	cs := compressBySQLDigest(s)
+	cs := compressBySQLDigest(s, 1)
 	if cs.ToList()[0].Frequency != 1+2+3 {
 		t.Errorf("expect 6, got %v", cs.ToList()[0].Frequency)
 	}
 }
+
+func TestCostCompression(t *testing.T) {
+	queries := []utils.Query{
+		{Text: "q1", Frequency: 10}, // cost 100, score 1000
+		{Text: "q2", Frequency: 1},  // cost 500, score 500
+		{Text: "q3", Frequency: 2},  // cost 50, score 100
+		{Text: "q4", Frequency: 5},  // cost 10, score 50
+		{Text: "q5", Frequency: 1},  // cost 20, score 20
+		{Text: "q6", Frequency: 3},  // cost 10, score 30
+	}
+	costs := []float64{100, 500, 50, 10, 20, 10}
+	signatures := map[string]string{"q1": "t1", "q2": "t2", "q3": "t1", "q4": "t1", "q5": "t2", "q6": "t3"}
+	totalFreq := func(qs []utils.Query) (freq int) {
+		for _, q := range qs {
+			freq += q.Frequency
+		}
+		return
+	}
+
+	for _, c := range []struct {
+		budget   int
+		expected string
+	}{
+		{6, "q1:10,q2:1,q3:2,q4:5,q6:3,q5:1"},
+		{4, "q1:10,q2:2,q3:7,q6:3"}, // q2 and q5 on t2, q3 and q4 on t1
+		{3, "q1:17,q2:2,q6:3"},      // no query is kept as it is
+		{1, "q1:22"},                // all clusters are merged
+	} {
+		retained, coverage := compressByCost(queries, costs, signatures, c.budget)
+		var got []string
+		for _, q := range retained {
+			got = append(got, fmt.Sprintf("%v:%v", q.Text, q.Frequency))
+		}
+		if strings.Join(got, ",") != c.expected {
+			t.Errorf("budget %v: expect %v, got %v", c.budget, c.expected, strings.Join(got, ","))
+		}
+		if totalFreq(retained) != 22 || coverage <= 0 || coverage > 1 {
+			t.Errorf("budget %v: unexpected total frequency %v or coverage %v", c.budget, totalFreq(retained), coverage)
+		}
+	}
+}
+
+func TestDigestCompressionSamples(t *testing.T) {
+	s := utils.NewSet[utils.Query]()
+	s.Add(utils.Query{Text: "select * from t where status = 'active' and a = 1", Frequency: 60})
+	s.Add(utils.Query{Text: "select * from t where status = 'active' and a = 2", Frequency: 20})
+	s.Add(utils.Query{Text: "select * from t where status = 'deleted' and a = 1", Frequency: 10})
+	s.Add(utils.Query{Text: "select * from t where status = 'deleted' and a = 3", Frequency: 5})
+	s.Add(utils.Query{Text: "select * from t where status = 'active' and a = 3", Frequency: 5})
+
+	for _, c := range []struct {
+		maxNumSamples int
+		expected      map[string]int
+	}{
+		{1, map[string]int{"select * from t where status = 'active' and a = 1": 100}},
+		{2, map[string]int{ // the one with 'deleted' and 3 is more diverse
+			"select * from t where status = 'active' and a = 1":  92,
+			"select * from t where status = 'deleted' and a = 3": 8}},
+		{5, map[string]int{ // no new constant after 3 samples
+			"select * from t where status = 'active' and a = 1":  71,
+			"select * from t where status = 'deleted' and a = 3": 6,
+			"select * from t where status = 'active' and a = 2":  23}},
+	} {
+		cs := compressBySQLDigest(s, c.maxNumSamples)
+		got := make(map[string]int)
+		for _, q := range cs.ToList() {
+			got[q.Text] = q.Frequency
+		}
+		if fmt.Sprintf("%v", got) != fmt.Sprintf("%v", c.expected) {
+			t.Errorf("max %v samples: expect %v, got %v", c.maxNumSamples, c.expected, got)
+		}
+	}
+}
+
+func TestScaleFrequencies(t *testing.T) {
+	for _, c := range []struct {
+		freqs    []int
+		total    int
+		expected string
+	}{
+		{[]int{1, 1, 1}, 10, "[4 3 3]"},
+		{[]int{3, 1}, 8, "[6 2]"},
+		{[]int{0, 0}, 5, "[5 0]"},
+	} {
+		if got := fmt.Sprintf("%v", scaleFrequencies(c.freqs, c.total)); got != c.expected {
+			t.Errorf("scale %v to %v: expect %v, got %v", c.freqs, c.total, c.expected, got)
+		}
+	}
+}
@@ -2,9 +2,11 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 	"path"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/qw4990/index_advisor/advisor"
 	"github.com/qw4990/index_advisor/optimizer"
@@ -15,8 +17,26 @@ import (
 type adviseOfflineCmdOpt struct {
 	maxNumIndexes int
 	maxIndexWidth int
+	maxNumQueries int
+	maxNumSamples int
+	compressAlgo  string
+	indexableAlgo string
+	queryWeight   string
+	maxRegression float64
+	targets       string
+	targetsFile   string
+	targetRatio   float64
+	protectedQs   []string
+
+	constraints     advisor.ConstraintsSpec
+	constraintsFile string
 
 	tidbVersion  string
+	tidbBackend  string
+	tidbDSN      string
+	tidbBinary   string
+	tidbTimeout  time.Duration
+	reuseTiDB    bool
 	queryPath    string
 	schemaPath   string
 	statsPath    string
@@ -35,7 +55,8 @@ func NewAdviseOfflineCmd() *cobra.Command {
 		Short: "advise some indexes for the specified workload",
 		Long: `advise some indexes for the specified workload.
 How it work:
-1. start a local TiDB server through TiUP and connect to it
+1. start a local TiDB server through TiUP (or an embedded TiDB in this process, or a given TiDB binary) and connect to it,
+   or connect to an existing TiDB server specified by '--tidb-dsn'
 2. load all necessary information(table schema, table statistics) into this TiDB server
 3. read all queries from the specified query file
 4. analyze those queries and generate a series of candidate indexes
@@ -45,13 +66,50 @@ How it work:
 		RunE: func(cmd *cobra.Command, args []string) error {
 			utils.SetLogLevel(opt.logLevel)
 
-			s, db, err := startTiDB(opt.tidbVersion)
-			if s != nil {
-				defer s.Release()
+			var manifest *utils.BundleManifest
+			if exist, isDir := utils.FileExists(opt.dirPath); exist && !isDir && utils.IsBundlePath(opt.dirPath) {
+				bundleDir, err := os.MkdirTemp("", "index_advisor_bundle_")
+				if err != nil {
+					return err
+				}
+				defer os.RemoveAll(bundleDir)
+				utils.Infof("unpack bundle %v into %v", opt.dirPath, bundleDir)
+				m, err := utils.UnpackBundle(opt.dirPath, bundleDir)
+				if err != nil {
+					return err
+				}
+				utils.Infof("bundle exported from TiDB %v at %v", m.SourceTiDBVersion, m.ExportTime.Format(time.RFC3339))
+				if m.CostModelVersion != "" && !cmd.Flags().Changed("cost-model-ver") {
+					utils.Infof("use cost model version %v of the bundle", m.CostModelVersion)
+					opt.costModelVer = m.CostModelVersion
+				}
+				manifest, opt.dirPath = &m, bundleDir
+			}
+
+			release, db, sharedDSN, err := startTiDB(opt)
+			if release != nil {
+				defer release()
 			}
 			if err != nil {
 				return err
 			}
+			var isolation *workloadIsolation
+			if sharedDSN != "" { // load the workload into isolated databases to avoid affecting the existing ones
+				if isolation, err = newWorkloadIsolation(sharedDSN); err != nil {
+					return err
+				}
+				defer isolation.Release()
+			}
+			if manifest != nil {
+				localVersion, err := readTiDBVersion(db)
+				if err != nil {
+					return err
+				}
+				if localVersion != manifest.SourceTiDBVersion {
+					utils.Warningf("the local TiDB version %v is different from the source TiDB version %v of the bundle, the advised indexes may be different from the ones advised on the source version",
+						localVersion, manifest.SourceTiDBVersion)
+				}
+			}
 			if err := db.Execute(`set sql_mode=''`); err != nil {
 				return err
 			}
@@ -59,17 +117,20 @@ How it work:
 			if opt.dirPath != "" {
 				opt.schemaPath = path.Join(opt.dirPath, "schema.sql")
 				opt.statsPath = path.Join(opt.dirPath, "stats")
-				opt.queryPath = path.Join(opt.dirPath, "queries")
-				if exist, isDir := utils.FileExists(opt.queryPath); exist && isDir {
-				} else {
-					opt.queryPath = path.Join(opt.dirPath, "queries.sql")
+				opt.queryPath = path.Join(opt.dirPath, "queries.json")
+				if !fileExists(opt.queryPath) {
+					if dirExists(path.Join(opt.dirPath, "queries")) {
+						opt.queryPath = path.Join(opt.dirPath, "queries")
+					} else {
+						opt.queryPath = path.Join(opt.dirPath, "queries.sql")
+					}
 				}
 				utils.Infof("use schema path: %s", opt.schemaPath)
 				utils.Infof("use stats path: %s", opt.statsPath)
 				utils.Infof("use query path: %s", opt.queryPath)
 			}
 
-			dbName, err := loadSchemaIntoCluster(db, opt.schemaPath)
+			dbName, schemaModel, err := loadSchemaIntoCluster(db, opt.schemaPath, isolation)
 			if err != nil {
 				return err
 			}
@@ -84,6 +145,24 @@ How it work:
 				utils.Infof("no query needs to be analyzed")
 				return nil
 			}
+			if err := utils.WeightQueries(queries, opt.queryWeight); err != nil {
+				return err
+			}
+			if err := markTargetQueries(queries, opt.targets, opt.targetsFile, opt.targetRatio); err != nil {
+				return err
+			}
+			constraints, err := parseConstraints(opt.constraints, opt.constraintsFile, dbName)
+			if err != nil {
+				return err
+			}
+			if isolation != nil {
+				queries = isolation.isolateQueries(queries)
+				if opt.statsPath, err = isolation.isolateStatsDir(opt.statsPath); err != nil {
+					return err
+				}
+				dbName = isolation.schema(dbName)
+				constraints = isolation.isolateConstraints(constraints)
+			}
 
 			if err := loadStatsIntoCluster(db, opt.statsPath); err != nil {
 				return err
@@ -96,7 +175,14 @@ How it work:
 			if err != nil {
 				return err
 			}
-			tableSchemas, err := getTableSchemas(db, tableNames)
+			views, err := readViews(db, schemaModel, tableNames)
+			if err != nil {
+				return err
+			}
+			if tableNames, err = utils.ExpandViews(tableNames, views); err != nil {
+				return err
+			}
+			tableSchemas, err := getTableSchemasWithModel(db, schemaModel, tableNames)
 			if err != nil {
 				return err
 			}
@@ -104,32 +190,64 @@ How it work:
 			workload := utils.WorkloadInfo{
 				Queries:      queries,
 				TableSchemas: tableSchemas,
+				Views:        views,
 			}
 
 			// set cost-model-version
 			if err := db.Execute(fmt.Sprintf("set @@tidb_cost_model_version = %v", opt.costModelVer)); err != nil {
-				return nil
+				utils.Warningf("failed to set the cost model version, use the default one: %v", err)
 			}
 
-			indexes, err := advisor.IndexAdvise(db, workload, advisor.Parameter{
+			indexes, err := advisor.IndexAdvise(db, &workload, advisor.Parameter{
 				MaxNumberIndexes: opt.maxNumIndexes,
 				MaxIndexWidth:    opt.maxIndexWidth,
+				MaxNumQueries:    opt.maxNumQueries,
+				MaxNumSamples:    opt.maxNumSamples,
+				CompressionAlgo:  opt.compressAlgo,
+				IndexableAlgo:    opt.indexableAlgo,
+
+				RegressionGuard:    opt.maxRegression >= 0,
+				MaxQueryRegression: opt.maxRegression,
+				ProtectedQueries:   opt.protectedQs,
+
+				Constraints: constraints,
 			})
 			if err != nil {
 				return err
 			}
-			return outputAdviseResult(indexes, workload, db, opt.output)
+			return outputAdviseResult(indexes, workload, db, opt.output, isolation)
 		},
 	}
 
 	cmd.Flags().IntVar(&opt.maxNumIndexes, "max-num-indexes", 5, "max number of indexes to recommend, 1~20")
 	cmd.Flags().IntVar(&opt.maxIndexWidth, "max-index-width", 3, "the max number of columns in recommended indexes")
-
-	cmd.Flags().StringVar(&opt.tidbVersion, "tidb-version", "nightly", "tidb version, one of 'nightly', 'v7.3.0'")
-	cmd.Flags().StringVar(&opt.queryPath, "query-path", "", "(required) query file or dictionary path, e.g. './examples/tpch_example1/queries' or 'examples/tpch_example2/query.sql'")
+	cmd.Flags().IntVar(&opt.maxNumQueries, "max-num-queries", 0, "the max number of queries to evaluate, queries beyond it are compressed into weighted representatives by their costs, tables and indexable columns, 0 means no limit")
+	cmd.Flags().IntVar(&opt.maxNumSamples, "max-num-samples", 1, "the max number of samples with different constants to keep for each query digest, the cost of a digest is the frequency-weighted average over its samples, e.g. '3' against parameter skew")
+	cmd.Flags().StringVar(&opt.compressAlgo, "compress-algo", "", "how to compress the workload, one of 'none', 'digest' (merge queries with the same digest), 'cost' (keep the most costly queries within '--max-num-queries'), 'cost' if '--max-num-queries' is set, 'digest' otherwise")
+	cmd.Flags().StringVar(&opt.indexableAlgo, "indexable-algo", "simple", "how to find indexable columns and candidates, one of 'simple', 'join' (also generate composite candidates for the inner sides of IndexJoins)")
+	cmd.Flags().StringVar(&opt.queryWeight, "query-weight", utils.WeightByFrequency, "how to weight queries in the workload cost, one of 'frequency', 'total-latency' (frequency × average latency), 'p99-latency' (frequency × p99 latency), 'user' (frequency × user-specified weight in the workload file)")
+	cmd.Flags().Float64Var(&opt.maxRegression, "max-query-regression", -1, "reject index configurations making any query's estimated cost rise by more than this percentage over the baseline, e.g. '20', negative means no limit")
+	cmd.Flags().StringSliceVar(&opt.protectedQs, "protected-queries", []string{}, "queries (aliases) which must not regress at all, e.g. 'q1,q2'")
+	cmd.Flags().StringVar(&opt.targets, "target-queries", "", "queries (aliases or digests) to improve first with required cost reduction percentages, e.g. 'q1:50,q2', indexes are selected to meet these targets with the fewest indexes first")
+	cmd.Flags().StringVar(&opt.targetsFile, "target-queries-file", "", "a file of target queries in the same format as '--target-queries', separated by commas or new lines")
+	cmd.Flags().Float64Var(&opt.targetRatio, "target-reduction", 50, "the default required cost reduction percentage of target queries")
+	cmd.Flags().StringSliceVar(&opt.constraints.ExcludeTables, "exclude-tables", []string{}, "tables which must never be indexed, e.g. 'db.t1,t2', tables without schemas are under the default one")
+	cmd.Flags().StringSliceVar(&opt.constraints.ExcludeColumns, "exclude-columns", []string{}, "columns which must never be indexed, e.g. 'db.t1.c1,t2.c2'")
+	cmd.Flags().StringArrayVar(&opt.constraints.ProposeIndexes, "propose-index", []string{}, "an index which must be considered as a candidate, e.g. 'db.t(a, b)', can be specified more than once")
+	cmd.Flags().StringArrayVar(&opt.constraints.KeepIndexes, "keep-index", []string{}, "an index which must be kept in the result, e.g. 'db.t(a, (lower(b)))', can be specified more than once")
+	cmd.Flags().StringToIntVar(&opt.constraints.TableIndexLimits, "table-index-limits", map[string]int{}, "the max number of new indexes on tables, e.g. 'db.t1=1,t2=0'")
+	cmd.Flags().StringVar(&opt.constraintsFile, "constraints-file", "", "a JSON file of constraints with the fields 'exclude_tables', 'exclude_columns', 'propose_indexes', 'keep_indexes' and 'table_index_limits', which are merged with the flags above")
+
+	cmd.Flags().StringVar(&opt.tidbVersion, "tidb-version", "nightly", "tidb version, one of 'nightly', 'v7.3.0', ignored by the embedded backend")
+	cmd.Flags().StringVar(&opt.tidbDSN, "tidb-dsn", "", "(optional) use an existing TiDB server instead of starting a new one, e.g. 'root:@tcp(127.0.0.1:4000)/', the workload is loaded into auto-generated databases which are dropped at last")
+	cmd.Flags().StringVar(&opt.tidbBinary, "tidb-binary", "", "(optional) start a local TiDB server from this tidb-server binary instead of TiUP, e.g. './bin/tidb-server'")
+	cmd.Flags().DurationVar(&opt.tidbTimeout, "tidb-start-timeout", utils.DefaultTiDBStartTimeout, "how long to wait for the local TiDB server to start, e.g. '5m'")
+	cmd.Flags().BoolVar(&opt.reuseTiDB, "reuse-tidb", false, "keep the local TiDB server running after advising and reuse it in later invocations with the same version, to save the starting time")
+	cmd.Flags().StringVar(&opt.tidbBackend, "tidb-backend", "tiup", "how to start the local TiDB, one of 'tiup', 'embedded'; 'embedded' runs an in-process TiDB which needs no network and no TiUP, but the binary must be built with '-tags embedded'")
+	cmd.Flags().StringVar(&opt.queryPath, "query-path", "", "(required) query file or dictionary path, e.g. './examples/tpch_example1/queries', 'examples/tpch_example2/query.sql' or 'examples/workload_export_output/queries.json'")
 	cmd.Flags().StringVar(&opt.schemaPath, "schema-path", "", "(optional) schema file path, e.g. './examples/tpch_example1/schema.sql'")
 	cmd.Flags().StringVar(&opt.statsPath, "stats-path", "", "(optional) stats dictionary path, e.g. './examples/tpch_example1/stats'")
-	cmd.Flags().StringVar(&opt.dirPath, "dir-path", "", "(optional) the dictionary path that contains queries, schema and stats, e.g. './examples/tpch_example1'")
+	cmd.Flags().StringVar(&opt.dirPath, "dir-path", "", "(optional) the dictionary path that contains queries, schema and stats, e.g. './examples/tpch_example1', or a bundle file exported by 'workload-export --bundle', e.g. './workload.tar.gz'")
 	cmd.Flags().StringVar(&opt.output, "output", "", "output directory to save the result, e.g. './output'")
 	cmd.Flags().StringVar(&opt.costModelVer, "cost-model-ver", "2", "cost model version, 1 or 2")
 
@@ -139,21 +257,61 @@ How it work:
 	return cmd
 }
 
-func startTiDB(ver string) (*utils.LocalTiDBServer, optimizer.WhatIfOptimizer, error) {
-	s, err := utils.StartLocalTiDBServer(ver)
-	if err != nil {
-		return nil, nil, err
+// startTiDB starts or connects to the TiDB to advise on, sharedDSN is the DSN of the TiDB if it's shared with others,
+// e.g. the one specified by '--tidb-dsn' or a reused local one, and the workload should be isolated in it.
+func startTiDB(opt adviseOfflineCmdOpt) (release func(), db optimizer.WhatIfOptimizer, sharedDSN string, err error) {
+	if opt.tidbDSN != "" && opt.tidbBinary != "" {
+		return nil, nil, "", fmt.Errorf("'--tidb-dsn' and '--tidb-binary' can not be used together")
+	}
+	if opt.tidbDSN != "" {
+		utils.Infof("connect to %s", opt.tidbDSN)
+		db, err := optimizer.NewTiDBWhatIfOptimizer(opt.tidbDSN)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		return func() { db.Close() }, db, opt.tidbDSN, nil
 	}
 
-	utils.Infof("connect to %s", s.DSN())
-	db, err := optimizer.NewTiDBWhatIfOptimizer(s.DSN()) // the DB may not exist yet
-	if err != nil {
-		return s, nil, err
+	switch strings.ToLower(opt.tidbBackend) {
+	case "tiup":
+		serverOpt := utils.LocalTiDBServerOptions{
+			Version:      opt.tidbVersion,
+			BinaryPath:   opt.tidbBinary,
+			StartTimeout: opt.tidbTimeout,
+			Reuse:        opt.reuseTiDB,
+		}
+		if opt.output != "" {
+			serverOpt.LogDir = path.Join(opt.output, "tidb_logs")
+		}
+		s, err := utils.StartLocalTiDBServerWithOptions(serverOpt)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		utils.Infof("connect to %s", s.DSN())
+		db, err := optimizer.NewTiDBWhatIfOptimizer(s.DSN()) // the DB may not exist yet
+		if opt.reuseTiDB {
+			sharedDSN = s.DSN()
+		}
+		return func() { s.Release() }, db, sharedDSN, err
+	case "embedded":
+		t, err := optimizer.StartEmbeddedTiDB()
+		if err != nil {
+			return nil, nil, "", err
+		}
+		db, err := optimizer.NewEmbeddedWhatIfOptimizer(t)
+		release = func() {
+			if db != nil {
+				db.Close()
+			}
+			t.Release()
+		}
+		return release, db, "", err
+	default:
+		return nil, nil, "", fmt.Errorf("unknown TiDB backend %v, should be one of 'tiup', 'embedded'", opt.tidbBackend)
 	}
-	return s, db, nil
 }
 
-func outputAdviseResult(indexes utils.Set[utils.Index], workload utils.WorkloadInfo, optimizer optimizer.WhatIfOptimizer, savePath string) error {
+func outputAdviseResult(indexes utils.Set[utils.Index], workload utils.WorkloadInfo, optimizer optimizer.WhatIfOptimizer, savePath string, isolation *workloadIsolation) error {
 	// index DDL statements
 	indexList := indexes.ToList()
 	sort.Slice(indexList, func(i, j int) bool { // to make the result stable
@@ -161,7 +319,7 @@ func outputAdviseResult(indexes utils.Set[utils.Index], workload utils.WorkloadI
 	})
 	indexDDLStmts := make([]string, 0, len(indexList))
 	for _, index := range indexList {
-		indexDDLStmts = append(indexDDLStmts, index.DDL())
+		indexDDLStmts = append(indexDDLStmts, isolation.restoreIndex(index).DDL())
 	}
 
 	// query plan changes
@@ -169,6 +327,9 @@ func outputAdviseResult(indexes utils.Set[utils.Index], workload utils.WorkloadI
 	if err != nil {
 		return err
 	}
+	for i := range planChanges {
+		planChanges[i].SQL = isolation.restoreQuery(planChanges[i].SQL)
+	}
 	var originalWorkloadCost, optimizerWorkloadCost float64
 	for _, change := range planChanges {
 		originalWorkloadCost += change.OriPlan.PlanCost()
@@ -178,9 +339,15 @@ func outputAdviseResult(indexes utils.Set[utils.Index], workload utils.WorkloadI
 	// summary content
 	var summaryContent string
 	summaryContent += fmt.Sprintf("Total Queries in the workload: %d\n", workload.Queries.Size())
+	if workload.CostCoverage > 0 {
+		summaryContent += fmt.Sprintf("Workload cost covered by the queries kept by the compression: %.2f%%\n", workload.CostCoverage*100)
+	}
 	summaryContent += fmt.Sprintf("Total number of indexes: %d\n", len(indexList))
-	for _, ddlStmt := range indexDDLStmts {
+	for i, ddlStmt := range indexDDLStmts {
 		summaryContent += fmt.Sprintf("  %s;\n", ddlStmt)
+		if indexList[i].Trimmed != "" {
+			summaryContent += fmt.Sprintf("    -- %s\n", indexList[i].Trimmed)
+		}
 	}
 	if len(indexDDLStmts) == 0 {
 		summaryContent += "  (no beneficial index recommended)\n"
@@ -201,9 +368,9 @@ func outputAdviseResult(indexes utils.Set[utils.Index], workload utils.WorkloadI
 	}
 
 	summaryContent += fmt.Sprintf("Top %d queries with the most cost reduction number:\n", utils.Min(len(planChanges), n))
-	sort.Slice(planChanges, func(i, j int) bool {
-		return (planChanges[i].OriPlan.PlanCost() - planChanges[i].OptPlan.PlanCost()) >
-			(planChanges[j].OriPlan.PlanCost() - planChanges[j].OptPlan.PlanCost())
+	sort.Slice(planChanges, func(i, j int) bool { // weighted by the importance of queries
+		return (planChanges[i].OriPlan.PlanCost()-planChanges[i].OptPlan.PlanCost())*planChanges[i].SQL.CostWeight() >
+			(planChanges[j].OriPlan.PlanCost()-planChanges[j].OptPlan.PlanCost())*planChanges[j].SQL.CostWeight()
 	})
 	for i := 0; i < utils.Min(len(planChanges), n); i++ {
 		change := planChanges[i]
@@ -212,9 +379,9 @@ func outputAdviseResult(indexes utils.Set[utils.Index], workload utils.WorkloadI
 	}
 
 	summaryContent += fmt.Sprintf("Top %d queries with the most cost:\n", utils.Min(len(planChanges), n))
-	sort.Slice(planChanges, func(i, j int) bool {
-		return (planChanges[i].OriPlan.PlanCost() + planChanges[i].OptPlan.PlanCost()) >
-			(planChanges[j].OriPlan.PlanCost() + planChanges[j].OptPlan.PlanCost())
+	sort.Slice(planChanges, func(i, j int) bool { // weighted by the importance of queries
+		return (planChanges[i].OriPlan.PlanCost()+planChanges[i].OptPlan.PlanCost())*planChanges[i].SQL.CostWeight() >
+			(planChanges[j].OriPlan.PlanCost()+planChanges[j].OptPlan.PlanCost())*planChanges[j].SQL.CostWeight()
 	})
 	for i := 0; i < utils.Min(len(planChanges), n); i++ {
 		change := planChanges[i]
@@ -222,6 +389,45 @@ func outputAdviseResult(indexes utils.Set[utils.Index], workload utils.WorkloadI
 			change.OriPlan.PlanCost(), change.OptPlan.PlanCost(), change.OptPlan.PlanCost()/change.OriPlan.PlanCost())
 	}
 
+	var regressed []planChange
+	for _, change := range planChanges {
+		if change.OptPlan.PlanCost() > change.OriPlan.PlanCost() {
+			regressed = append(regressed, change)
+		}
+	}
+	sort.Slice(regressed, func(i, j int) bool {
+		return regressed[i].OptPlan.PlanCost()/regressed[i].OriPlan.PlanCost() > regressed[j].OptPlan.PlanCost()/regressed[j].OriPlan.PlanCost()
+	})
+	summaryContent += fmt.Sprintf("Queries with higher cost after applying the indexes: %d\n", len(regressed))
+	for _, change := range regressed {
+		summaryContent += fmt.Sprintf("  Alias: %s, Cost Regression: %.2E->%.2E(%+.2f%%)\n", change.SQL.Alias,
+			change.OriPlan.PlanCost(), change.OptPlan.PlanCost(), 100*(change.OptPlan.PlanCost()/change.OriPlan.PlanCost()-1))
+	}
+
+	var targets []planChange
+	numMet := 0
+	for _, change := range planChanges {
+		if change.SQL.TargetReduction > 0 {
+			targets = append(targets, change)
+			if targetMet(change) {
+				numMet++
+			}
+		}
+	}
+	if len(targets) > 0 {
+		sort.Slice(targets, func(i, j int) bool { return targets[i].SQL.Alias < targets[j].SQL.Alias })
+		summaryContent += fmt.Sprintf("Target queries: %d, met: %d\n", len(targets), numMet)
+		for _, change := range targets {
+			status := "met"
+			if !targetMet(change) {
+				status = "not met"
+			}
+			summaryContent += fmt.Sprintf("  Alias: %s, Cost: %.2E->%.2E, Reduction: %.2f%% (required %.2f%%), %s\n",
+				change.SQL.Alias, change.OriPlan.PlanCost(), change.OptPlan.PlanCost(),
+				100*(1-change.OptPlan.PlanCost()/change.OriPlan.PlanCost()), change.SQL.TargetReduction, status)
+		}
+	}
+
 	fmt.Println(summaryContent)
 	if savePath != "" {
 		if err := utils.PrepareDir(savePath); err != nil {
@@ -271,6 +477,11 @@ type planChange struct {
 	OptPlan utils.Plan
 }
 
+// targetMet returns whether the cost reduction of the target query meets its target.
+func targetMet(change planChange) bool {
+	return change.OptPlan.PlanCost() <= change.OriPlan.PlanCost()*(1-change.SQL.TargetReduction/100)
+}
+
 func getPlanChanges(optimizer optimizer.WhatIfOptimizer, workload utils.WorkloadInfo, indexList []utils.Index) ([]planChange, error) {
 	sqls := workload.Queries.ToList()
 	var oriPlans, optPlans []utils.Plan
@@ -322,6 +533,28 @@ func getTableSchemas(db optimizer.WhatIfOptimizer, tableNames utils.Set[utils.Ta
 	return s, nil
 }
 
+// getTableSchemasWithModel is like getTableSchemas, but tables in the schema model are not read from the cluster again.
+func getTableSchemasWithModel(db optimizer.WhatIfOptimizer, model *utils.SchemaModel, tableNames utils.Set[utils.TableName]) (utils.Set[utils.TableSchema], error) {
+	s := utils.NewSet[utils.TableSchema]()
+	missing := utils.NewSet[utils.TableName]()
+	for _, t := range tableNames.ToList() {
+		if schema, ok := model.Table(t.SchemaName, t.TableName); ok {
+			s.Add(schema)
+		} else {
+			missing.Add(t)
+		}
+	}
+	if missing.Size() == 0 {
+		return s, nil
+	}
+	fromCluster, err := getTableSchemas(db, missing)
+	if err != nil {
+		return nil, err
+	}
+	s.AddSet(fromCluster)
+	return s, nil
+}
+
 func getTableSchema(db optimizer.WhatIfOptimizer, schemaName, tableName string) (utils.TableSchema, error) {
 	r, err := db.Query(fmt.Sprintf("show create table %v.%v", schemaName, tableName))
 	if err != nil {
@@ -11,6 +11,19 @@ import (
 type adviseOnlineCmdOpt struct {
 	maxNumIndexes int
 	maxIndexWidth int
+	maxNumQueries int
+	maxNumSamples int
+	compressAlgo  string
+	indexableAlgo string
+	queryWeight   string
+	maxRegression float64
+	targets       string
+	targetsFile   string
+	targetRatio   float64
+	protectedQs   []string
+
+	constraints     advisor.ConstraintsSpec
+	constraintsFile string
 
 	dsn      string
 	output   string
@@ -44,12 +57,28 @@ How it work:
 			if indexes == nil {
 				return nil
 			}
-			return outputAdviseResult(indexes, *info, db, opt.output)
+			return outputAdviseResult(indexes, *info, db, opt.output, nil)
 		},
 	}
 
 	cmd.Flags().IntVar(&opt.maxNumIndexes, "max-num-indexes", 5, "max number of indexes to recommend, 1~20")
 	cmd.Flags().IntVar(&opt.maxIndexWidth, "max-index-width", 3, "the max number of columns in recommended indexes")
+	cmd.Flags().IntVar(&opt.maxNumQueries, "max-num-queries", 0, "the max number of queries to evaluate, queries beyond it are compressed into weighted representatives by their costs, tables and indexable columns, 0 means no limit")
+	cmd.Flags().IntVar(&opt.maxNumSamples, "max-num-samples", 1, "the max number of samples with different constants to keep for each query digest, the cost of a digest is the frequency-weighted average over its samples, e.g. '3' against parameter skew")
+	cmd.Flags().StringVar(&opt.compressAlgo, "compress-algo", "", "how to compress the workload, one of 'none', 'digest' (merge queries with the same digest), 'cost' (keep the most costly queries within '--max-num-queries'), 'cost' if '--max-num-queries' is set, 'digest' otherwise")
+	cmd.Flags().StringVar(&opt.indexableAlgo, "indexable-algo", "simple", "how to find indexable columns and candidates, one of 'simple', 'join' (also generate composite candidates for the inner sides of IndexJoins)")
+	cmd.Flags().StringVar(&opt.queryWeight, "query-weight", utils.WeightByFrequency, "how to weight queries in the workload cost, one of 'frequency', 'total-latency' (frequency × average latency), 'p99-latency' (frequency × p99 latency), 'user' (frequency × user-specified weight in the workload file)")
+	cmd.Flags().Float64Var(&opt.maxRegression, "max-query-regression", -1, "reject index configurations making any query's estimated cost rise by more than this percentage over the baseline, e.g. '20', negative means no limit")
+	cmd.Flags().StringSliceVar(&opt.protectedQs, "protected-queries", []string{}, "queries (aliases, which are statement digests in the statement summary) which must not regress at all, e.g. 'digest1,digest2'")
+	cmd.Flags().StringVar(&opt.targets, "target-queries", "", "queries (aliases or digests) to improve first with required cost reduction percentages, e.g. 'q1:50,q2', indexes are selected to meet these targets with the fewest indexes first")
+	cmd.Flags().StringVar(&opt.targetsFile, "target-queries-file", "", "a file of target queries in the same format as '--target-queries', separated by commas or new lines")
+	cmd.Flags().Float64Var(&opt.targetRatio, "target-reduction", 50, "the default required cost reduction percentage of target queries")
+	cmd.Flags().StringSliceVar(&opt.constraints.ExcludeTables, "exclude-tables", []string{}, "tables which must never be indexed, e.g. 'db.t1,t2', tables without schemas are under the default one")
+	cmd.Flags().StringSliceVar(&opt.constraints.ExcludeColumns, "exclude-columns", []string{}, "columns which must never be indexed, e.g. 'db.t1.c1,t2.c2'")
+	cmd.Flags().StringArrayVar(&opt.constraints.ProposeIndexes, "propose-index", []string{}, "an index which must be considered as a candidate, e.g. 'db.t(a, b)', can be specified more than once")
+	cmd.Flags().StringArrayVar(&opt.constraints.KeepIndexes, "keep-index", []string{}, "an index which must be kept in the result, e.g. 'db.t(a, (lower(b)))', can be specified more than once")
+	cmd.Flags().StringToIntVar(&opt.constraints.TableIndexLimits, "table-index-limits", map[string]int{}, "the max number of new indexes on tables, e.g. 'db.t1=1,t2=0'")
+	cmd.Flags().StringVar(&opt.constraintsFile, "constraints-file", "", "a JSON file of constraints with the fields 'exclude_tables', 'exclude_columns', 'propose_indexes', 'keep_indexes' and 'table_index_limits', which are merged with the flags above")
 
 	cmd.Flags().StringVar(&opt.dsn, "dsn", "root:@tcp(127.0.0.1:4000)/test", "dsn")
 	cmd.Flags().StringVar(&opt.output, "output", "", "output directory to save the result")
@@ -58,7 +87,7 @@ How it work:
 	cmd.Flags().StringSliceVar(&opt.querySchemas, "query-schemas", []string{}, "a list of schema(database), e.g. 'test1, test2', queries that are running under these schemas will be considered")
 	cmd.Flags().IntVar(&opt.queryExecTimeThreshold, "query-exec-time-threshold", 0, "the threshold of query execution time(in milliseconds), e.g. '300', queries that are running longer than this threshold will be considered")
 	cmd.Flags().IntVar(&opt.queryExecCountThreshold, "query-exec-count-threshold", 0, "the threshold of query execution count, e.g. '20', queries that are executed more than this threshold will be considered")
-	cmd.Flags().StringVar(&opt.queryPath, "query-path", "", "the path that contains queries, e.g. 'queries.sql', if this variable is specified, the above variables like 'query-*' will be ignored")
+	cmd.Flags().StringVar(&opt.queryPath, "query-path", "", "the path that contains queries, e.g. 'queries.sql' or 'queries.json', if this variable is specified, the above variables like 'query-*' will be ignored")
 	return cmd
 }
 
@@ -79,10 +108,31 @@ func adviseOnlineMode(opt adviseOnlineCmdOpt) (utils.Set[utils.Index], *utils.Wo
 		utils.Infof("no query is found")
 		return nil, nil, nil, nil
 	}
+	if err := utils.WeightQueries(info.Queries, opt.queryWeight); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := markTargetQueries(info.Queries, opt.targets, opt.targetsFile, opt.targetRatio); err != nil {
+		return nil, nil, nil, err
+	}
+	_, dbName := utils.GetDBNameFromDSN(opt.dsn)
+	constraints, err := parseConstraints(opt.constraints, opt.constraintsFile, dbName)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 
-	result, err := advisor.IndexAdvise(db, *info, advisor.Parameter{
+	result, err := advisor.IndexAdvise(db, info, advisor.Parameter{
 		MaxNumberIndexes: opt.maxNumIndexes,
 		MaxIndexWidth:    opt.maxIndexWidth,
+		MaxNumQueries:    opt.maxNumQueries,
+		MaxNumSamples:    opt.maxNumSamples,
+		CompressionAlgo:  opt.compressAlgo,
+		IndexableAlgo:    opt.indexableAlgo,
+
+		RegressionGuard:    opt.maxRegression >= 0,
+		MaxQueryRegression: opt.maxRegression,
+		ProtectedQueries:   opt.protectedQs,
+
+		Constraints: constraints,
 	})
 	return result, info, db, err
 }
@@ -91,7 +141,8 @@ func prepareWorkloadOnlineMode(db optimizer.WhatIfOptimizer, opt adviseOnlineCmd
 	var err error
 	var queries utils.Set[utils.Query]
 	if opt.queryPath == "" {
-		queries, err = readQueriesFromStatementSummary(db, opt.querySchemas, opt.queryExecTimeThreshold, opt.queryExecCountThreshold)
+		queries, err = readQueriesFromStatementSummary(db, opt.querySchemas, opt.queryExecTimeThreshold, opt.queryExecCountThreshold,
+			opt.maxNumSamples > 1, opt.queryWeight == utils.WeightByP99Latency)
 		if err != nil {
 			return nil, err
 		}
@@ -116,16 +167,24 @@ func prepareWorkloadOnlineMode(db optimizer.WhatIfOptimizer, opt adviseOnlineCmd
 	if err != nil {
 		return nil, err
 	}
+	views, err := readViews(db, nil, tableNames)
+	if err != nil {
+		return nil, err
+	}
+	if tableNames, err = utils.ExpandViews(tableNames, views); err != nil {
+		return nil, err
+	}
 	tables, err := getTableSchemas(db, tableNames)
 	if err != nil {
 		return nil, err
 	}
-	queries, err = filterSQLAccessingDroppedTable(queries, tables)
+	queries, err = filterSQLAccessingDroppedTable(queries, tables, views)
 	if err != nil {
 		return nil, err
 	}
 	return &utils.WorkloadInfo{
 		Queries:      queries,
 		TableSchemas: tables,
+		Views:        views,
 	}, nil
 }
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"math"
 	"os"
 	"path"
 	"path/filepath"
@@ -11,50 +12,116 @@ import (
 	"strings"
 
 	"github.com/go-sql-driver/mysql"
+	"github.com/pingcap/parser/ast"
+	"github.com/qw4990/index_advisor/advisor"
 	"github.com/qw4990/index_advisor/optimizer"
 	"github.com/qw4990/index_advisor/utils"
 )
 
-// loadWorkloadIntoCluster loads the schema the TiDB cluster
-func loadSchemaIntoCluster(db optimizer.WhatIfOptimizer, schemaFilePath string) (dbName string, err error) {
+// loadSchemaIntoCluster loads the schema into the TiDB cluster, and returns the current database after loading and a
+// model of the loaded schema. Statements which can't be parsed or executed don't abort the loading, they are reported
+// at last, and DML statements (e.g. `insert`) are skipped since only the schema is needed.
+// If isolation is not nil, all schemas are renamed to the isolated ones, and the returned dbName is the original one,
+// while the model uses the isolated names, which are the ones in the cluster.
+func loadSchemaIntoCluster(db optimizer.WhatIfOptimizer, schemaFilePath string, isolation *workloadIsolation) (dbName string, model *utils.SchemaModel, err error) {
+	currentDB := "test" // the default DB `test`
+	if isolation != nil {
+		currentDB = isolation.schema(currentDB)
+	}
+	model = utils.NewSchemaModel(currentDB)
 	if schemaFilePath == "" {
-		return "", nil
+		return "", model, nil
 	}
 	utils.Infof("load schema info from %v into the TiDB instance", schemaFilePath)
-	rawSQLs, err := utils.ParseStmtsFromFile(schemaFilePath)
+	stmts, err := utils.LoadStmtsFromFile(schemaFilePath)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
-	if len(rawSQLs) == 0 {
-		return "", nil
+	if len(stmts) == 0 {
+		return "", model, nil
 	}
 
-	currentDB := "test" // the default DB `test`
-	for _, stmt := range rawSQLs {
-		switch utils.GetStmtType(stmt) {
-		case utils.StmtUseDB:
-			currentDB = utils.GetDBNameFromUseDBStmt(stmt)
-		case utils.StmtCreateDB:
-			dbName := utils.GetDBNameFromCreateDBStmt(stmt)
-			exist, err := dbExists(dbName, db)
-			if err != nil {
-				return "", err
+	if isolation != nil {
+		if err := db.Execute(fmt.Sprintf("create database if not exists `%v`", currentDB)); err != nil {
+			return "", nil, err
+		}
+		if err := db.Execute(fmt.Sprintf("use `%v`", currentDB)); err != nil {
+			return "", nil, err
+		}
+	}
+	var executed int
+	var skipped, failed []string
+	for _, s := range stmts {
+		stmt, err := utils.ParseOneSQL(s.Text)
+		if err != nil {
+			if isolation != nil { // can't be renamed without parsing
+				failed = append(failed, fmt.Sprintf("%v: %v", s.Pos(), err))
+				continue
 			}
-			if exist {
+			// the TiDB may support the syntax, so still execute it, but it can't be tracked in the model
+			if execErr := db.Execute(s.Text); execErr != nil {
+				failed = append(failed, fmt.Sprintf("%v: %v", s.Pos(), execErr))
+			} else {
+				executed++
+				skipped = append(skipped, fmt.Sprintf("%v: executed but not tracked since it can't be parsed: %v", s.Pos(), err))
+			}
+			continue
+		}
+
+		switch x := stmt.(type) {
+		case ast.DMLNode:
+			skipped = append(skipped, fmt.Sprintf("%v: skip the DML statement", s.Pos()))
+			continue
+		case *ast.CreateDatabaseStmt:
+			name := x.Name
+			if isolation != nil {
+				name = isolation.schema(name)
+			}
+			if exist, err := dbExists(name, db); err != nil {
+				return "", nil, err
+			} else if exist {
+				skipped = append(skipped, fmt.Sprintf("%v: database %v already exists", s.Pos(), x.Name))
 				continue
 			}
-		case utils.StmtCreateTable:
-			table, err := utils.ParseCreateTableStmt(currentDB, stmt)
-			if err != nil {
-				return "", err
+		}
+
+		text := s.Text
+		if isolation != nil {
+			if text, err = isolation.renamer.RenameStmt(stmt); err != nil {
+				failed = append(failed, fmt.Sprintf("%v: failed to isolate the statement: %v", s.Pos(), err))
+				continue
 			}
-			utils.Infof("create table %s.%s", table.SchemaName, table.TableName)
+			stmt.SetText(text)
+		}
+		if err := db.Execute(text); err != nil {
+			failed = append(failed, fmt.Sprintf("%v: %v", s.Pos(), err))
+			continue
 		}
-		if err := db.Execute(stmt); err != nil {
-			return "", err
+		executed++
+		if err := model.Apply(stmt); err != nil {
+			utils.Warningf("%v: the schema model may be inconsistent with the TiDB instance: %v", s.Pos(), err)
+		}
+		if x, ok := stmt.(*ast.CreateTableStmt); ok {
+			schemaName := x.Table.Schema.O
+			if schemaName == "" {
+				schemaName = model.CurrentDB
+			}
+			utils.Infof("create table %s.%s", schemaName, x.Table.Name.O)
 		}
 	}
-	return currentDB, nil
+
+	utils.Infof("load schema from %v: %v statements executed, %v skipped, %v failed", schemaFilePath, executed, len(skipped), len(failed))
+	for _, msg := range skipped {
+		utils.Warningf("skipped %v", msg)
+	}
+	for _, msg := range failed {
+		utils.Warningf("failed %v", msg)
+	}
+	currentDB = model.CurrentDB
+	if isolation != nil {
+		currentDB = isolation.originalSchema(currentDB)
+	}
+	return currentDB, model, nil
 }
 
 // loadStatsIntoCluster loads the stats into the TiDB cluster
@@ -111,6 +178,82 @@ func getStatsFileTableName(statsFile string) (utils.TableName, error) {
 	return utils.TableName{stats.DatabaseName, stats.TableName}, nil
 }
 
+// readViews reads the definitions of views in tableNames and the views they reference, from the schema model if it
+// knows the table, or the cluster. The model can be nil.
+func readViews(db optimizer.WhatIfOptimizer, model *utils.SchemaModel, tableNames utils.Set[utils.TableName]) (utils.Set[utils.ViewSchema], error) {
+	views := utils.NewSet[utils.ViewSchema]()
+	visited := utils.NewSet[utils.TableName]()
+	pending := tableNames.ToList()
+	for len(pending) > 0 {
+		t := pending[0]
+		pending = pending[1:]
+		if visited.Contains(t) || utils.IsTiDBSystemTableName(t) {
+			continue
+		}
+		visited.Add(t)
+
+		var view utils.ViewSchema
+		if v, ok := model.View(t.SchemaName, t.TableName); ok {
+			view = v
+		} else if _, ok := model.Table(t.SchemaName, t.TableName); ok {
+			continue
+		} else {
+			exist, err := viewExists(t.SchemaName, t.TableName, db)
+			if err != nil {
+				return nil, err
+			}
+			if !exist {
+				continue
+			}
+			if view, err = getViewSchema(db, t.SchemaName, t.TableName); err != nil {
+				return nil, err
+			}
+		}
+		utils.Infof("read the definition of view %v", view.Key())
+		views.Add(view)
+		referenced, err := utils.CollectTableNamesFromView(view)
+		if err != nil {
+			return nil, fmt.Errorf("invalid view %v: %v", view.Key(), err)
+		}
+		pending = append(pending, referenced.ToList()...)
+	}
+	return views, nil
+}
+
+func getViewSchema(db optimizer.WhatIfOptimizer, schemaName, viewName string) (utils.ViewSchema, error) {
+	r, err := db.Query(fmt.Sprintf("show create view `%v`.`%v`", schemaName, viewName))
+	if err != nil {
+		return utils.ViewSchema{}, err
+	}
+	defer r.Close()
+	if !r.Next() {
+		return utils.ViewSchema{}, fmt.Errorf("view %v.%v does not exist", schemaName, viewName)
+	}
+	var name, createViewStmt, charset, collation string
+	if err := r.Scan(&name, &createViewStmt, &charset, &collation); err != nil {
+		return utils.ViewSchema{}, err
+	}
+	return utils.ParseCreateViewStmt(strings.ToLower(schemaName), createViewStmt)
+}
+
+func viewExists(schemaName, viewName string, db optimizer.WhatIfOptimizer) (bool, error) {
+	q := fmt.Sprintf("select count(*) from information_schema.VIEWS where lower(table_schema) = '%s' and lower(table_name)='%s'",
+		strings.ToLower(schemaName), strings.ToLower(viewName))
+	r, err := db.Query(q)
+	if err != nil {
+		return false, err
+	}
+	r.Next()
+	var count int
+	if err := r.Scan(&count); err != nil {
+		return false, err
+	}
+	if err := r.Close(); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
 func tableExists(schemaName, tableName string, db optimizer.WhatIfOptimizer) (bool, error) {
 	q := fmt.Sprintf("select count(*) from information_schema.TABLES where lower(table_schema) = '%s' and lower(table_name)='%s'",
 		strings.ToLower(schemaName), strings.ToLower(tableName))
@@ -176,8 +319,42 @@ func redactLogEnabled(db optimizer.WhatIfOptimizer) bool {
 	return false
 }
 
+// markTargetQueries marks target queries specified by the text and the file.
+func markTargetQueries(queries utils.Set[utils.Query], text, filePath string, defaultReduction float64) error {
+	if filePath != "" {
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		text += "\n" + string(content)
+	}
+	targets, err := utils.ParseQueryTargets(text, defaultReduction)
+	if err != nil {
+		return err
+	}
+	if len(targets) > 0 {
+		utils.MarkTargetQueries(queries, targets)
+	}
+	return nil
+}
+
+// parseConstraints merges the constraints from flags and the constraints file, and parses them with the default schema.
+func parseConstraints(spec advisor.ConstraintsSpec, filePath, defaultSchema string) (advisor.Constraints, error) {
+	if filePath != "" {
+		fileSpec, err := advisor.LoadConstraintsSpec(filePath)
+		if err != nil {
+			return advisor.Constraints{}, err
+		}
+		spec = fileSpec.Merge(spec)
+	}
+	return advisor.ParseConstraints(spec, defaultSchema)
+}
+
+// readQueriesFromStatementSummary reads queries from the statement summary, more samples of these queries with
+// different constants from the slow log if readSamples is true, and their p99 latencies from the slow log if readP99
+// is true.
 func readQueriesFromStatementSummary(db optimizer.WhatIfOptimizer, querySchemas []string,
-	queryExecTimeThreshold, queryExecCountThreshold int) (utils.Set[utils.Query], error) {
+	queryExecTimeThreshold, queryExecCountThreshold int, readSamples, readP99 bool) (utils.Set[utils.Query], error) {
 	var condition []string
 	condition = append(condition, "stmt_type='Select'")
 	if len(querySchemas) > 0 {
@@ -209,10 +386,21 @@ func readQueriesFromStatementSummary(db optimizer.WhatIfOptimizer, querySchemas
 			}
 			execCount, err := strconv.Atoi(execCountStr.String)
 			if err != nil {
-				return nil, err
+				utils.Warningf("skip query %v with an invalid execution count %q: %v", digest.String, execCountStr.String, err)
+				continue
+			}
+			var avgLat float64 // in nanoseconds, 0 means no latency
+			if avgLatStr.Valid && avgLatStr.String != "" {
+				if avgLat, err = strconv.ParseFloat(avgLatStr.String, 64); err != nil {
+					utils.Warningf("ignore the invalid average latency %q of query %v: %v", avgLatStr.String, digest.String, err)
+					avgLat = 0
+				}
 			}
 			if _, err := utils.ParseOneSQL(text.String); err != nil {
 				// some queries may be truncated, we skip them.
+				if utils.UsesMemberOf(text.String) {
+					utils.Warningf("skip query %v: %v", digest.String, err)
+				}
 				continue
 			}
 
@@ -223,15 +411,133 @@ func readQueriesFromStatementSummary(db optimizer.WhatIfOptimizer, querySchemas
 				SchemaName: schemaName.String, // can be empty (null)
 				Text:       text.String,
 				Frequency:  execCount,
+				AvgLatency: avgLat / 1e6,
 			})
 		}
 		if err := rows.Close(); err != nil {
 			return nil, err
 		}
 	}
+	if readSamples {
+		if err := readQuerySamplesFromSlowLog(db, s); err != nil {
+			utils.Warningf("failed to read query samples from the slow log, use samples in the statement summary only: %v", err)
+		}
+	}
+	if readP99 {
+		if err := readP99LatencyFromSlowLog(db, s); err != nil {
+			utils.Warningf("failed to read p99 latencies of queries from the slow log: %v", err)
+		}
+	}
 	return s, nil
 }
 
+// readP99LatencyFromSlowLog sets p99 latencies of queries from the slow log. The p99 latency of a digest is the k-th
+// longest execution time of it where k is 1% of its execution count, which is known only if there are at least k
+// executions in the slow log, otherwise it's below the slow log threshold and left unknown. Only the k-th execution
+// of each digest is returned by TiDB.
+func readP99LatencyFromSlowLog(db optimizer.WhatIfOptimizer, queries utils.Set[utils.Query]) error {
+	digestCount := make(map[string]int)
+	for _, q := range queries.ToList() {
+		digestCount[q.Alias] += q.Frequency
+	}
+	var digests, ranks []string
+	for digest, count := range digestCount {
+		if k := int(math.Ceil(float64(count) * 0.01)); k > 0 {
+			digests = append(digests, digest)
+			ranks = append(ranks, fmt.Sprintf("when '%s' then %v", digest, k))
+		}
+	}
+	if len(digests) == 0 {
+		return nil
+	}
+	// window functions are disabled by default on clusters upgraded from versions before v3.0
+	if err := db.Execute(`set @@session.tidb_enable_window_function = 1`); err != nil {
+		return err
+	}
+	rows, err := db.Query(fmt.Sprintf(`select Digest, Query_time from (
+		select Digest, Query_time, row_number() over (partition by Digest order by Query_time desc) as k
+		from information_schema.slow_query where Is_internal = false and Digest in ('%s')) t
+		where k = case Digest %s end`, strings.Join(digests, "', '"), strings.Join(ranks, " ")))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	digestP99 := make(map[string]float64) // in seconds
+	for rows.Next() {
+		var digest string
+		var queryTime float64
+		if err := rows.Scan(&digest, &queryTime); err != nil {
+			return err
+		}
+		digestP99[digest] = queryTime
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, q := range queries.ToList() {
+		if p99, ok := digestP99[q.Alias]; ok {
+			q.P99Latency = p99 * 1000
+			queries.Add(q)
+		}
+	}
+	return nil
+}
+
+// readQuerySamplesFromSlowLog adds queries in the slow log with the same digests as the given ones but different
+// texts, as more samples of these digests with different constants. Each sample takes its execution count in the slow
+// log from the most frequent query with the same digest, so the total frequency of each digest is unchanged.
+func readQuerySamplesFromSlowLog(db optimizer.WhatIfOptimizer, queries utils.Set[utils.Query]) error {
+	digestQuery := make(map[string]utils.Query) // the most frequent query of each digest
+	for _, q := range queries.ToList() {
+		if existing, ok := digestQuery[q.Alias]; !ok || q.Frequency > existing.Frequency {
+			digestQuery[q.Alias] = q
+		}
+	}
+	if len(digestQuery) == 0 {
+		return nil
+	}
+	digests := make([]string, 0, len(digestQuery))
+	for digest := range digestQuery {
+		digests = append(digests, digest)
+	}
+	rows, err := db.Query(fmt.Sprintf(`select Digest, Query, count(*) from information_schema.slow_query
+		where Is_internal = false and Digest in ('%s') group by Digest, Query`, strings.Join(digests, "', '")))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var digest, text string
+		var execCount int
+		if err := rows.Scan(&digest, &text, &execCount); err != nil {
+			return err
+		}
+		text = strings.TrimSuffix(strings.TrimSpace(text), ";")
+		q := digestQuery[digest]
+		if queries.ContainsKey(text) || q.Frequency <= 1 {
+			continue
+		}
+		if _, err := utils.ParseOneSQL(text); err != nil {
+			if utils.UsesMemberOf(text) {
+				utils.Warningf("skip a sample of query %v: %v", digest, err)
+			}
+			continue // some queries may be truncated or redacted
+		}
+		execCount = utils.Min(execCount, q.Frequency-1)
+		q.Frequency -= execCount
+		queries.Add(q)
+		digestQuery[digest] = q
+		queries.Add(utils.Query{
+			Alias:      digest,
+			SchemaName: q.SchemaName,
+			Text:       text,
+			Frequency:  execCount,
+			AvgLatency: q.AvgLatency,
+		})
+	}
+	return rows.Err()
+}
+
 func readTableSchemas(db optimizer.WhatIfOptimizer, schemas []string) (utils.Set[utils.TableSchema], error) {
 	s := utils.NewSet[utils.TableSchema]()
 	for _, schemaName := range schemas {
@@ -308,7 +614,7 @@ func filterSQLAccessingSystemTables(sqls utils.Set[utils.Query]) (utils.Set[util
 	return s, nil
 }
 
-func filterSQLAccessingDroppedTable(sqls utils.Set[utils.Query], tables utils.Set[utils.TableSchema]) (utils.Set[utils.Query], error) {
+func filterSQLAccessingDroppedTable(sqls utils.Set[utils.Query], tables utils.Set[utils.TableSchema], views utils.Set[utils.ViewSchema]) (utils.Set[utils.Query], error) {
 	tableNames := utils.NewSet[utils.TableName]()
 	for _, t := range tables.ToList() {
 		tableNames.Add(utils.TableName{
@@ -323,6 +629,9 @@ func filterSQLAccessingDroppedTable(sqls utils.Set[utils.Query], tables utils.Se
 		if err != nil {
 			return nil, err
 		}
+		if tables, err = utils.ExpandViews(tables, views); err != nil {
+			return nil, err
+		}
 		noTableFlag := false
 		for _, t := range tables.ToList() {
 			if !tableNames.Contains(utils.TableName{
@@ -340,3 +649,34 @@ func filterSQLAccessingDroppedTable(sqls utils.Set[utils.Query], tables utils.Se
 	}
 	return s, nil
 }
+
+// readTiDBVersion returns the release version of this TiDB instance, e.g. 'v7.1.0'.
+func readTiDBVersion(db optimizer.WhatIfOptimizer) (string, error) {
+	rows, err := db.Query(`select tidb_version()`)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return "", fmt.Errorf("no result for tidb_version()")
+	}
+	var info string
+	if err := rows.Scan(&info); err != nil {
+		return "", err
+	}
+	return utils.ParseTiDBVersion(info), nil
+}
+
+// readCostModelVersion returns the cost model version of this TiDB instance, returns an empty string if it's unknown.
+func readCostModelVersion(db optimizer.WhatIfOptimizer) string {
+	rows, err := db.Query(`select @@tidb_cost_model_version`)
+	if err != nil { // not supported in old versions
+		return ""
+	}
+	defer rows.Close()
+	var ver string
+	if !rows.Next() || rows.Scan(&ver) != nil {
+		return ""
+	}
+	return ver
+}
@@ -0,0 +1,503 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pingcap/tidb/types"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+	"github.com/qw4990/index_advisor/utils"
+	"github.com/spf13/cobra"
+)
+
+type workloadAnonymizeCmdOpt struct {
+	input           string
+	output          string
+	mappingPath     string
+	keepStatsValues bool
+	logLevel        string
+}
+
+func NewWorkloadAnonymizeCmd() *cobra.Command {
+	var opt workloadAnonymizeCmdOpt
+	cmd := &cobra.Command{
+		Use:   "workload-anonymize",
+		Short: "anonymize a workload exported by `workload-export` before sharing it, use `index_advisor workload-anonymize --help` to see more details",
+		Long: `anonymize a workload exported by 'workload-export' before sharing it.
+How it work:
+1. rename all schemas, tables, columns and indexes consistently across 'schema.sql', 'queries.sql', 'queries.json' and the stats files
+2. replace all literals in queries with type-preserving placeholders, e.g. 'alice' -> 's1', '2023-01-01' -> '2000-01-02',
+   except JSON paths and format strings, keep the wildcards of LIKE patterns, e.g. 'alice%' -> 's1%', the structure of
+   JSON documents, e.g. '["alice", 1]' -> '["s1", 2]', and the formats of dates in str_to_date, e.g. '1995/01/01' -> '2000/01/02'
+3. remove all table, column and index comments, and values in histograms and TopN of stats files
+4. save the anonymized workload into the output directory, and save the mapping between real names and anonymized names into the mapping file
+5. use 'workload-deanonymize' with the mapping file to map the advised 'ddl.sql' back to real names
+
+Notice: the mapping file contains all real names, keep it private and don't share it with the anonymized workload.
+Notice: values in histograms and TopN of stats files are real data and don't match the anonymized literals, so they are removed by default, which makes the cost estimation less accurate; use '--keep-stats-values' to keep them only if the data is not sensitive.
+`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			utils.SetLogLevel(opt.logLevel)
+			if opt.input == "" || opt.output == "" {
+				return fmt.Errorf("--input and --output should be specified")
+			}
+			if opt.mappingPath == "" {
+				opt.mappingPath = strings.TrimRight(opt.output, "/") + ".mapping.json"
+			}
+			utils.Infof("[workload-anonymize] start anonymizing workload %v into %v", opt.input, opt.output)
+			err := anonymizeWorkload(opt)
+			if err == nil {
+				utils.Infof("[workload-anonymize] anonymize workload successfully into %v, the mapping file is %v", opt.output, opt.mappingPath)
+			} else {
+				utils.Infof("[workload-anonymize] anonymize workload failed: %v", err)
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().StringVar(&opt.input, "input", "", "the workload directory exported by 'workload-export'")
+	cmd.Flags().StringVar(&opt.output, "output", "", "output directory to save the anonymized workload")
+	cmd.Flags().StringVar(&opt.mappingPath, "mapping", "", "path to save the private mapping file, default to '<output>.mapping.json'")
+	cmd.Flags().BoolVar(&opt.keepStatsValues, "keep-stats-values", false, "keep histogram buckets and TopN values in the stats files, which are real data and are not anonymized")
+	cmd.Flags().StringVar(&opt.logLevel, "log-level", "info", "log level, one of 'debug', 'info', 'warning', 'error'")
+	return cmd
+}
+
+type workloadDeanonymizeCmdOpt struct {
+	mappingPath string
+	ddlPath     string
+	output      string
+	logLevel    string
+}
+
+func NewWorkloadDeanonymizeCmd() *cobra.Command {
+	var opt workloadDeanonymizeCmdOpt
+	cmd := &cobra.Command{
+		Use:   "workload-deanonymize",
+		Short: "map the advised DDL statements on an anonymized workload back to real names",
+		Long: `map the advised DDL statements on an anonymized workload back to real names.
+The mapping file is the one written by 'workload-anonymize'.
+Indexes advised on the anonymized workload are renamed according to their real column names.
+`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			utils.SetLogLevel(opt.logLevel)
+			if opt.mappingPath == "" || opt.ddlPath == "" {
+				return fmt.Errorf("--mapping and --ddl-path should be specified")
+			}
+			m, err := loadAnonymizeMapping(opt.mappingPath)
+			if err != nil {
+				return err
+			}
+			stmts, err := utils.ParseStmtsFromFile(opt.ddlPath)
+			if err != nil {
+				return err
+			}
+			var ddls []string
+			for _, stmt := range stmts {
+				ddl, err := m.deanonymizeIndexDDL(stmt)
+				if err != nil {
+					return fmt.Errorf("failed to de-anonymize %v: %v", stmt, err)
+				}
+				ddls = append(ddls, ddl)
+			}
+			content := strings.Join(ddls, ";\n")
+			if opt.output == "" {
+				fmt.Println(content)
+				return nil
+			}
+			utils.Infof("[workload-deanonymize] save de-anonymized DDL statements into %v", opt.output)
+			return utils.SaveContentTo(opt.output, content)
+		},
+	}
+
+	cmd.Flags().StringVar(&opt.mappingPath, "mapping", "", "the mapping file written by 'workload-anonymize'")
+	cmd.Flags().StringVar(&opt.ddlPath, "ddl-path", "", "the 'ddl.sql' file advised on the anonymized workload")
+	cmd.Flags().StringVar(&opt.output, "output", "", "path to save the de-anonymized DDL statements, print them if not specified")
+	cmd.Flags().StringVar(&opt.logLevel, "log-level", "info", "log level, one of 'debug', 'info', 'warning', 'error'")
+	return cmd
+}
+
+// anonymizeMapping maps real (lower-case) names to anonymized names.
+type anonymizeMapping struct {
+	Schemas  map[string]string `json:"schemas"`
+	Tables   map[string]string `json:"tables"` // tables, views, CTEs and table aliases
+	Columns  map[string]string `json:"columns"`
+	Indexes  map[string]string `json:"indexes"`
+	Literals map[string]string `json:"literals"`
+
+	numStrings, numNumbers, numDates int
+}
+
+func newAnonymizeMapping() *anonymizeMapping {
+	return &anonymizeMapping{
+		Schemas:  make(map[string]string),
+		Tables:   make(map[string]string),
+		Columns:  make(map[string]string),
+		Indexes:  make(map[string]string),
+		Literals: make(map[string]string),
+	}
+}
+
+func loadAnonymizeMapping(fpath string) (*anonymizeMapping, error) {
+	data, err := os.ReadFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+	m := newAnonymizeMapping()
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("invalid mapping file %v: %v", fpath, err)
+	}
+	return m, nil
+}
+
+func (m *anonymizeMapping) save(fpath string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.SaveContentTo(fpath, string(data))
+}
+
+func anonymizeName(names map[string]string, prefix, name string) string {
+	name = strings.ToLower(name)
+	if _, ok := names[name]; !ok {
+		names[name] = fmt.Sprintf("%v%v", prefix, len(names)+1)
+	}
+	return names[name]
+}
+
+func (m *anonymizeMapping) schema(name string) string {
+	if utils.IsTiDBSystemTableName(utils.TableName{SchemaName: name}) {
+		return name
+	}
+	return anonymizeName(m.Schemas, "db", name)
+}
+
+func (m *anonymizeMapping) table(name string) string {
+	return anonymizeName(m.Tables, "t", name)
+}
+
+func (m *anonymizeMapping) column(name string) string {
+	return anonymizeName(m.Columns, "c", name)
+}
+
+func (m *anonymizeMapping) index(name string) string {
+	if strings.EqualFold(name, "primary") {
+		return name
+	}
+	return anonymizeName(m.Indexes, "idx", name)
+}
+
+var dateLiteralPattern = regexp.MustCompile(`^\d{4}-\d{1,2}-\d{1,2}( \d{1,2}:\d{1,2}:\d{1,2}(\.\d+)?)?$`)
+
+// literal replaces the literal with a placeholder of the same type.
+// The same literal is always replaced with the same placeholder.
+func (m *anonymizeMapping) literal(v *driver.ValueExpr) {
+	var key string
+	switch v.Kind() {
+	case types.KindInt64, types.KindUint64, types.KindFloat32, types.KindFloat64,
+		types.KindMysqlDecimal, types.KindString, types.KindBytes:
+		str, err := v.ToString()
+		if err != nil {
+			return
+		}
+		key = fmt.Sprintf("%v:%v", v.Kind(), str)
+	default: // NULL, bit and hex literals, etc.
+		return
+	}
+
+	placeholder, ok := m.Literals[key]
+	if !ok {
+		switch v.Kind() {
+		case types.KindString, types.KindBytes:
+			str := v.GetString()
+			if dateLiteralPattern.MatchString(str) {
+				m.numDates++
+				t := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, m.numDates)
+				if strings.Contains(str, " ") {
+					placeholder = t.Format("2006-01-02 15:04:05")
+				} else {
+					placeholder = t.Format("2006-01-02")
+				}
+			} else {
+				m.numStrings++
+				placeholder = fmt.Sprintf("s%v", m.numStrings)
+			}
+		case types.KindMysqlDecimal:
+			m.numNumbers++
+			_, frac := v.GetMysqlDecimal().PrecisionAndFrac()
+			placeholder = fmt.Sprintf("%v", m.numNumbers)
+			if frac > 0 {
+				placeholder += "." + strings.Repeat("0", frac)
+			}
+		case types.KindFloat32, types.KindFloat64:
+			m.numNumbers++
+			placeholder = fmt.Sprintf("%v.5", m.numNumbers)
+		default:
+			m.numNumbers++
+			placeholder = fmt.Sprintf("%v", m.numNumbers)
+		}
+		m.Literals[key] = placeholder
+	}
+
+	switch v.Kind() {
+	case types.KindString:
+		v.SetString(placeholder, v.Collation())
+	case types.KindBytes:
+		v.SetBytes([]byte(placeholder))
+	case types.KindMysqlDecimal:
+		d := new(types.MyDecimal)
+		if err := d.FromString([]byte(placeholder)); err == nil {
+			v.SetMysqlDecimal(d)
+		}
+	case types.KindFloat32, types.KindFloat64:
+		var f float64
+		fmt.Sscanf(placeholder, "%g", &f)
+		v.SetFloat64(f)
+	case types.KindInt64:
+		var i int64
+		fmt.Sscanf(placeholder, "%d", &i)
+		v.SetInt64(i)
+	case types.KindUint64:
+		var u uint64
+		fmt.Sscanf(placeholder, "%d", &u)
+		v.SetUint64(u)
+	}
+}
+
+// renamer returns a SQLRenamer which anonymizes identifiers, and also literals if anonymizeLiterals is true.
+// Literals in DDL statements like default values and partition bounds are kept to keep the schema valid.
+func (m *anonymizeMapping) renamer(anonymizeLiterals bool) *utils.SQLRenamer {
+	r := &utils.SQLRenamer{
+		Schema:        m.schema,
+		Table:         m.table,
+		Column:        m.column,
+		Index:         m.index,
+		StripComments: true,
+	}
+	if anonymizeLiterals {
+		r.Literal = m.literal
+	}
+	return r
+}
+
+func (m *anonymizeMapping) anonymizeSQLFile(src, dst string, anonymizeLiterals bool) error {
+	stmts, err := utils.ParseStmtsFromFile(src)
+	if err != nil {
+		return err
+	}
+	r := m.renamer(anonymizeLiterals)
+	var buf strings.Builder
+	for _, stmt := range stmts {
+		anonymized, err := r.RenameSQL(stmt)
+		if err != nil {
+			return fmt.Errorf("failed to anonymize %v in %v: %v", stmt, src, err)
+		}
+		buf.WriteString(anonymized)
+		buf.WriteString(";\n\n")
+	}
+	utils.Infof("[workload-anonymize] save %v anonymized statements from %v into %v", len(stmts), src, dst)
+	return utils.SaveContentTo(dst, buf.String())
+}
+
+func (m *anonymizeMapping) anonymizeWorkloadFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	var f utils.WorkloadFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("invalid workload file %v: %v", src, err)
+	}
+	r := m.renamer(true)
+	for i, q := range f.Queries {
+		if q.SchemaName != "" {
+			f.Queries[i].SchemaName = m.schema(q.SchemaName)
+		}
+		text, err := r.RenameSQL(q.Text)
+		if err != nil {
+			return fmt.Errorf("failed to anonymize query %v in %v: %v", q.Alias, src, err)
+		}
+		f.Queries[i].Text = text
+	}
+	data, err = json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	utils.Infof("[workload-anonymize] save %v anonymized queries from %v into %v", len(f.Queries), src, dst)
+	return utils.SaveContentTo(dst, string(data))
+}
+
+// anonymizeStats renames the schema, table, columns and indexes of a stats file in the JSON format of `/stats/dump`.
+func (m *anonymizeMapping) anonymizeStats(stats map[string]interface{}, isPartition, dropValues bool) {
+	if db, ok := stats["database_name"].(string); ok {
+		stats["database_name"] = m.schema(db)
+	}
+	if t, ok := stats["table_name"].(string); ok && !isPartition {
+		stats["table_name"] = m.table(t)
+	}
+	for _, item := range []struct {
+		key    string
+		rename func(string) string
+	}{{"columns", m.column}, {"indices", m.index}} {
+		hists, ok := stats[item.key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		renamed := make(map[string]interface{}, len(hists))
+		for name, hist := range hists {
+			if dropValues {
+				dropStatsValues(hist)
+			}
+			renamed[item.rename(name)] = hist
+		}
+		stats[item.key] = renamed
+	}
+	if partitions, ok := stats["partitions"].(map[string]interface{}); ok {
+		for _, p := range partitions {
+			if pStats, ok := p.(map[string]interface{}); ok {
+				m.anonymizeStats(pStats, true, dropValues)
+			}
+		}
+	}
+}
+
+func dropStatsValues(hist interface{}) {
+	h, ok := hist.(map[string]interface{})
+	if !ok {
+		return
+	}
+	if histogram, ok := h["histogram"].(map[string]interface{}); ok {
+		histogram["buckets"] = []interface{}{}
+	}
+	if cms, ok := h["cm_sketch"].(map[string]interface{}); ok {
+		delete(cms, "top_n")
+	}
+}
+
+func (m *anonymizeMapping) anonymizeStatsDir(src, dst string, dropValues bool) error {
+	files, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	if err := utils.PrepareDir(dst); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(path.Join(src, file.Name()))
+		if err != nil {
+			return err
+		}
+		stats := make(map[string]interface{})
+		if err := json.Unmarshal(data, &stats); err != nil {
+			return fmt.Errorf("invalid stats file %v: %v", file.Name(), err)
+		}
+		m.anonymizeStats(stats, false, dropValues)
+		data, err = json.Marshal(stats)
+		if err != nil {
+			return err
+		}
+		fpath := path.Join(dst, fmt.Sprintf("%v_%v.json", stats["database_name"], stats["table_name"]))
+		utils.Infof("[workload-anonymize] save anonymized stats from %v into %v", file.Name(), fpath)
+		if err := utils.SaveContentTo(fpath, string(data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func anonymizeWorkload(opt workloadAnonymizeCmdOpt) error {
+	if err := utils.PrepareDir(opt.output); err != nil {
+		return err
+	}
+	m := newAnonymizeMapping()
+	// anonymize the schema first to let names of tables and columns follow their definition order
+	for _, f := range []struct {
+		name              string
+		anonymizeLiterals bool
+	}{{"schema.sql", false}, {"queries.sql", true}} {
+		src := path.Join(opt.input, f.name)
+		if !fileExists(src) {
+			utils.Warningf("[workload-anonymize] no %v under %v, skip it", f.name, opt.input)
+			continue
+		}
+		if err := m.anonymizeSQLFile(src, path.Join(opt.output, f.name), f.anonymizeLiterals); err != nil {
+			return err
+		}
+	}
+	if src := path.Join(opt.input, "queries.json"); fileExists(src) {
+		if err := m.anonymizeWorkloadFile(src, path.Join(opt.output, "queries.json")); err != nil {
+			return err
+		}
+	}
+	if src := path.Join(opt.input, "stats"); dirExists(src) {
+		if err := m.anonymizeStatsDir(src, path.Join(opt.output, "stats"), !opt.keepStatsValues); err != nil {
+			return err
+		}
+	}
+	return m.save(opt.mappingPath)
+}
+
+func fileExists(fpath string) bool {
+	exist, isDir := utils.FileExists(fpath)
+	return exist && !isDir
+}
+
+func dirExists(fpath string) bool {
+	exist, isDir := utils.FileExists(fpath)
+	return exist && isDir
+}
+
+func reverseMapping(names map[string]string) map[string]string {
+	reversed := make(map[string]string, len(names))
+	for real, anonymized := range names {
+		reversed[anonymized] = real
+	}
+	return reversed
+}
+
+// deanonymizeIndexDDL maps a `CREATE INDEX` statement on the anonymized workload back to real names.
+// Indexes advised on the anonymized workload don't exist in the mapping, they are renamed by their real columns.
+func (m *anonymizeMapping) deanonymizeIndexDDL(ddl string) (string, error) {
+	schemas, tables, columns, indexes := reverseMapping(m.Schemas), reverseMapping(m.Tables), reverseMapping(m.Columns), reverseMapping(m.Indexes)
+	var unknown []string
+	lookup := func(kind string, names map[string]string) func(string) string {
+		return func(name string) string {
+			realName, ok := names[strings.ToLower(name)]
+			if !ok {
+				unknown = append(unknown, fmt.Sprintf("%v %v", kind, name))
+			}
+			return realName
+		}
+	}
+	r := &utils.SQLRenamer{
+		Schema: lookup("schema", schemas),
+		Table:  lookup("table", tables),
+		Column: lookup("column", columns),
+	}
+	realDDL, err := r.RenameSQL(ddl)
+	if err != nil {
+		return "", err
+	}
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("unknown names in the mapping file: %v", strings.Join(unknown, ", "))
+	}
+	index, err := utils.ParseCreateIndexStmt(realDDL)
+	if err != nil {
+		return "", err
+	}
+	if realName, ok := indexes[strings.ToLower(index.IndexName)]; ok {
+		index.IndexName = realName
+	} else if name := fmt.Sprintf("idx_%v", strings.Join(index.ColumnNames(), "_")); len(name) <= 64 {
+		index.IndexName = name
+	}
+	return index.DDL(), nil
+}
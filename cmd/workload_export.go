@@ -2,9 +2,11 @@ package cmd
 
 import (
 	"bytes"
+	"database/sql"
 	"fmt"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/qw4990/index_advisor/optimizer"
 	"github.com/qw4990/index_advisor/utils"
@@ -12,10 +14,14 @@ import (
 )
 
 type workloadExportCmdOpt struct {
-	dsn        string
-	statusAddr string
-	output     string
-	logLevel   string
+	dsn           string
+	statusAddr    string
+	output        string
+	statsSource   string
+	bundle        string
+	maxNumSamples int
+	readP99       bool
+	logLevel      string
 }
 
 func NewWorkloadExportCmd() *cobra.Command {
@@ -26,10 +32,11 @@ func NewWorkloadExportCmd() *cobra.Command {
 		Long: `export workload information (queries, table schema, table statistics) from your TiDB cluster.
 How it work:
 1. connect to your TiDB cluster through the DSN
-2. read all queries from the 'STATEMENT_SUMMARY' system table
+2. read all queries from the 'STATEMENT_SUMMARY' system table, and more samples of them from the slow log if '--max-num-samples' is larger than 1
 3. read all table schema from the 'INFORMATION_SCHEMA' database
-4. read all statistics from the 'mysql.stats_xxx' system tables
+4. read all statistics through the status address, or from the 'mysql.stats_xxx' system tables if '--stats-source=sql'
 5. store all data into the specified output directory
+6. pack the output directory into a bundle file with a manifest if '--bundle' is specified
 `,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			utils.SetLogLevel(opt.logLevel)
@@ -47,11 +54,18 @@ How it work:
 	cmd.Flags().StringVar(&opt.dsn, "dsn", "root:@tcp(127.0.0.1:4000)/test", "dsn")
 	cmd.Flags().StringVar(&opt.statusAddr, "status_address", "http://127.0.0.1:10080", "status address used to download table statistics")
 	cmd.Flags().StringVar(&opt.output, "output", "", "output directory to save the result")
+	cmd.Flags().StringVar(&opt.statsSource, "stats-source", "http", "where to read table statistics from, 'http' (through the status address) or 'sql' (from the 'mysql.stats_xxx' system tables, used when the status address is unreachable)")
+	cmd.Flags().IntVar(&opt.maxNumSamples, "max-num-samples", 1, "read more samples with different constants of each query digest from the slow log if it's larger than 1, which are kept by 'advise-offline --max-num-samples'")
+	cmd.Flags().BoolVar(&opt.readP99, "read-p99-latency", false, "read p99 latencies of queries from the slow log, which are used by 'advise-offline --query-weight=p99-latency'")
+	cmd.Flags().StringVar(&opt.bundle, "bundle", "", "(optional) path of a bundle file (*.tar.gz) to pack the output directory into, which can be used by 'advise-offline --dir-path' directly")
 	cmd.Flags().StringVar(&opt.logLevel, "log-level", "info", "log level, one of 'debug', 'info', 'warning', 'error'")
 	return cmd
 }
 
 func exportWorkload(opt workloadExportCmdOpt) error {
+	if opt.statsSource != "http" && opt.statsSource != "sql" {
+		return fmt.Errorf("unknown stats source %v, should be 'http' or 'sql'", opt.statsSource)
+	}
 	utils.Infof("[workload-export] prepare dir %v", opt.output)
 	if err := utils.PrepareDir(opt.output); err != nil {
 		return err
@@ -61,7 +75,7 @@ func exportWorkload(opt workloadExportCmdOpt) error {
 	if err != nil {
 		return err
 	}
-	queries, err := readQueriesFromStatementSummary(db, nil, 0, 0)
+	queries, err := readQueriesFromStatementSummary(db, nil, 0, 0, opt.maxNumSamples > 1, opt.readP99)
 	if err != nil {
 		return err
 	}
@@ -73,11 +87,18 @@ func exportWorkload(opt workloadExportCmdOpt) error {
 	if err != nil {
 		return err
 	}
+	views, err := readViews(db, nil, tableNames)
+	if err != nil {
+		return err
+	}
+	if tableNames, err = utils.ExpandViews(tableNames, views); err != nil {
+		return err
+	}
 	tables, err := getTableSchemas(db, tableNames)
 	if err != nil {
 		return err
 	}
-	queries, err = filterSQLAccessingDroppedTable(queries, tables)
+	queries, err = filterSQLAccessingDroppedTable(queries, tables, views)
 	if err != nil {
 		return err
 	}
@@ -85,7 +106,7 @@ func exportWorkload(opt workloadExportCmdOpt) error {
 	if err := saveQueries(opt, queries); err != nil {
 		return err
 	}
-	if err := saveTableSchemas(opt, tables); err != nil {
+	if err := saveTableSchemas(opt, tables, views); err != nil {
 		return err
 	}
 
@@ -96,7 +117,12 @@ func exportWorkload(opt workloadExportCmdOpt) error {
 		return err
 	}
 	for _, t := range tables.ToList() {
-		stats, err := fetchTableStats(opt, t)
+		var stats []byte
+		if opt.statsSource == "sql" {
+			stats, err = fetchTableStatsBySQL(db, t)
+		} else {
+			stats, err = fetchTableStats(opt, t)
+		}
 		if err != nil {
 			return err
 		}
@@ -106,9 +132,46 @@ func exportWorkload(opt workloadExportCmdOpt) error {
 		}
 		utils.Infof("[workload-export] save table statistics for %v to %v", t.Key(), fpath)
 	}
+
+	if opt.bundle != "" {
+		return packWorkloadBundle(opt, db, tables)
+	}
 	return nil
 }
 
+func packWorkloadBundle(opt workloadExportCmdOpt, db optimizer.WhatIfOptimizer, tables utils.Set[utils.TableSchema]) error {
+	tidbVersion, err := readTiDBVersion(db)
+	if err != nil {
+		return err
+	}
+	manifest := utils.BundleManifest{
+		SourceTiDBVersion: tidbVersion,
+		ExportTime:        time.Now(),
+		CostModelVersion:  readCostModelVersion(db),
+		TableRowCounts:    make(map[string]int64),
+	}
+	for _, t := range tables.ToList() {
+		rows, err := db.Query(fmt.Sprintf(`select TABLE_ROWS from information_schema.TABLES where lower(TABLE_SCHEMA)='%s' and lower(TABLE_NAME)='%s'`,
+			strings.ToLower(t.SchemaName), strings.ToLower(t.TableName)))
+		if err != nil {
+			return err
+		}
+		var rowCount sql.NullInt64
+		if rows.Next() {
+			if err := rows.Scan(&rowCount); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+		manifest.TableRowCounts[fmt.Sprintf("%v.%v", t.SchemaName, t.TableName)] = rowCount.Int64
+	}
+	utils.Infof("[workload-export] pack %v into bundle %v", opt.output, opt.bundle)
+	return utils.PackBundle(opt.output, opt.bundle, manifest)
+}
+
 func fetchTableStats(opt workloadExportCmdOpt, table utils.TableSchema) ([]byte, error) {
 	// http://${tidb-server-ip}:${tidb-server-status-port}/stats/dump/${db_name}/${table_name}
 	url := fmt.Sprintf("%s/stats/dump/%s/%s", opt.statusAddr, table.SchemaName, table.TableName)
@@ -136,10 +199,17 @@ func saveQueries(opt workloadExportCmdOpt, queries utils.Set[utils.Query]) error
 	}
 	fpath := path.Join(opt.output, "queries.sql")
 	utils.Infof("[workload-export] save queries to %v", fpath)
-	return utils.SaveContentTo(fpath, buf.String())
+	if err := utils.SaveContentTo(fpath, buf.String()); err != nil {
+		return err
+	}
+
+	// queries.json keeps the frequency and latency of each query, which are lost in queries.sql
+	fpath = path.Join(opt.output, "queries.json")
+	utils.Infof("[workload-export] save queries with their frequencies to %v", fpath)
+	return utils.SaveWorkloadFile(fpath, queries)
 }
 
-func saveTableSchemas(opt workloadExportCmdOpt, tables utils.Set[utils.TableSchema]) error {
+func saveTableSchemas(opt workloadExportCmdOpt, tables utils.Set[utils.TableSchema], views utils.Set[utils.ViewSchema]) error {
 	var buf bytes.Buffer
 	for _, t := range tables.ToList() {
 		buf.WriteString(fmt.Sprintf("create database if not exists %s;\n", t.SchemaName))
@@ -151,6 +221,12 @@ func saveTableSchemas(opt workloadExportCmdOpt, tables utils.Set[utils.TableSche
 		}
 		buf.WriteString("\n\n")
 	}
+	// views are created after tables and the views they reference
+	for _, v := range utils.SortViewsByDependency(views) {
+		buf.WriteString(fmt.Sprintf("create database if not exists %s;\n", v.SchemaName))
+		buf.WriteString(fmt.Sprintf("use %s;\n", v.SchemaName))
+		buf.WriteString(v.DDL() + ";\n\n")
+	}
 	fpath := path.Join(opt.output, "schema.sql")
 	utils.Infof("[workload-export] save table schema into %s", fpath)
 	return utils.SaveContentTo(fpath, buf.String())
@@ -0,0 +1,481 @@
+package cmd
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/tipb/go-tipb"
+	"github.com/qw4990/index_advisor/optimizer"
+	"github.com/qw4990/index_advisor/utils"
+)
+
+// exportedTableStats is the stats JSON format of `/stats/dump` and `load stats`, see `handle.JSONTable` in TiDB.
+type exportedTableStats struct {
+	DatabaseName string                          `json:"database_name"`
+	TableName    string                          `json:"table_name"`
+	Columns      map[string]*exportedColumnStats `json:"columns"`
+	Indices      map[string]*exportedColumnStats `json:"indices"`
+	ExtStats     []interface{}                   `json:"ext_stats"`
+	Count        int64                           `json:"count"`
+	ModifyCount  int64                           `json:"modify_count"`
+	Partitions   map[string]*exportedTableStats  `json:"partitions"`
+}
+
+// exportedColumnStats is the stats of a column or an index, see `handle.jsonColumn` in TiDB.
+type exportedColumnStats struct {
+	Histogram         *tipb.Histogram `json:"histogram"`
+	CMSketch          *tipb.CMSketch  `json:"cm_sketch"`
+	FMSketch          *tipb.FMSketch  `json:"fm_sketch"`
+	NullCount         int64           `json:"null_count"`
+	TotColSize        int64           `json:"tot_col_size"`
+	LastUpdateVersion uint64          `json:"last_update_version"`
+	Correlation       float64         `json:"correlation"`
+	StatsVer          *int64          `json:"stats_ver"`
+}
+
+type histKey struct {
+	isIndex int
+	histID  int64
+}
+
+type histSummary struct {
+	ndv       int64
+	nullCount int64
+}
+
+// sqlStatsExporter rebuilds the stats JSON of a table from `mysql.stats_meta`, `mysql.stats_histograms`,
+// `mysql.stats_buckets`, `mysql.stats_top_n` and `mysql.stats_fm_sketch` through the SQL connection.
+// It's used when the status address (`/stats/dump`) is unreachable.
+type sqlStatsExporter struct {
+	db    optimizer.WhatIfOptimizer
+	table utils.TableSchema
+
+	// IDs of columns are not exposed through SQL, they're the same as ordinal positions if no columns have been
+	// added, dropped or modified since the table was created, which is checked through `information_schema.DDL_JOBS`.
+	// Matched columns are also verified by their NDV and null count in `show stats_histograms`.
+	columnIDs  map[int64]string
+	indexNames map[int64]string
+	shownHists map[string]map[string]histSummary // partition name -> column name -> summary
+}
+
+func fetchTableStatsBySQL(db optimizer.WhatIfOptimizer, table utils.TableSchema) ([]byte, error) {
+	e := &sqlStatsExporter{db: db, table: table}
+	stats, err := e.export()
+	if err != nil {
+		utils.Infof("[workload-export] fail to export statistics for %v through SQL, err: %v", table.Key(), err)
+		return nil, err
+	}
+	utils.Infof("[workload-export] succeed to export statistics for %v through SQL", table.Key())
+	return json.Marshal(stats)
+}
+
+func (e *sqlStatsExporter) export() (*exportedTableStats, error) {
+	if err := e.readColumnsAndIndexes(); err != nil {
+		return nil, err
+	}
+	tableID, partitions, err := e.readPhysicalIDs()
+	if err != nil {
+		return nil, err
+	}
+	if err := e.checkColumnIDs(tableID); err != nil {
+		return nil, err
+	}
+	if len(partitions) == 0 {
+		stats, err := e.exportPhysicalTable(tableID, "")
+		if err != nil {
+			return nil, err
+		}
+		if stats == nil {
+			return nil, fmt.Errorf("no statistics for table %v", e.table.Key())
+		}
+		return stats, nil
+	}
+
+	stats := &exportedTableStats{
+		DatabaseName: strings.ToLower(e.table.SchemaName),
+		TableName:    strings.ToLower(e.table.TableName),
+		Partitions:   make(map[string]*exportedTableStats),
+	}
+	for name, pid := range partitions {
+		pStats, err := e.exportPhysicalTable(pid, name)
+		if err != nil {
+			return nil, err
+		}
+		if pStats != nil {
+			stats.Partitions[name] = pStats
+		}
+	}
+	globalStats, err := e.exportPhysicalTable(tableID, "global")
+	if err != nil {
+		return nil, err
+	}
+	if globalStats != nil {
+		stats.Partitions["global"] = globalStats
+	}
+	return stats, nil
+}
+
+func (e *sqlStatsExporter) readColumnsAndIndexes() error {
+	schemaName, tableName := strings.ToLower(e.table.SchemaName), strings.ToLower(e.table.TableName)
+	e.columnIDs = make(map[int64]string)
+	rows, err := e.db.Query(fmt.Sprintf(`select COLUMN_NAME, ORDINAL_POSITION from information_schema.COLUMNS where lower(TABLE_SCHEMA)='%s' and lower(TABLE_NAME)='%s'`, schemaName, tableName))
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name string
+		var pos int64
+		if err := rows.Scan(&name, &pos); err != nil {
+			rows.Close()
+			return err
+		}
+		e.columnIDs[pos] = strings.ToLower(name)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	e.indexNames = make(map[int64]string)
+	rows, err = e.db.Query(fmt.Sprintf(`select distinct KEY_NAME, INDEX_ID from information_schema.TIDB_INDEXES where lower(TABLE_SCHEMA)='%s' and lower(TABLE_NAME)='%s'`, schemaName, tableName))
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name string
+		var id int64
+		if err := rows.Scan(&name, &id); err != nil {
+			rows.Close()
+			return err
+		}
+		e.indexNames[id] = strings.ToLower(name)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	e.shownHists = make(map[string]map[string]histSummary)
+	rows, err = e.db.Query(fmt.Sprintf(`show stats_histograms where db_name='%s' and table_name='%s'`, schemaName, tableName))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		values := make([]sql.NullString, len(cols))
+		dest := make([]interface{}, len(cols))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		row := make(map[string]string)
+		for i, col := range cols {
+			row[strings.ToLower(col)] = values[i].String
+		}
+		if row["is_index"] != "0" {
+			continue
+		}
+		ndv, err1 := strconv.ParseInt(row["distinct_count"], 10, 64)
+		nullCount, err2 := strconv.ParseInt(row["null_count"], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		partition := strings.ToLower(row["partition_name"])
+		if e.shownHists[partition] == nil {
+			e.shownHists[partition] = make(map[string]histSummary)
+		}
+		e.shownHists[partition][strings.ToLower(row["column_name"])] = histSummary{ndv, nullCount}
+	}
+	return rows.Err()
+}
+
+func (e *sqlStatsExporter) readPhysicalIDs() (tableID int64, partitions map[string]int64, err error) {
+	schemaName, tableName := strings.ToLower(e.table.SchemaName), strings.ToLower(e.table.TableName)
+	rows, err := e.db.Query(fmt.Sprintf(`select TIDB_TABLE_ID from information_schema.TABLES where lower(TABLE_SCHEMA)='%s' and lower(TABLE_NAME)='%s'`, schemaName, tableName))
+	if err != nil {
+		return 0, nil, err
+	}
+	if !rows.Next() {
+		err := rows.Err()
+		rows.Close()
+		if err != nil {
+			return 0, nil, err
+		}
+		return 0, nil, fmt.Errorf("table %v doesn't exist", e.table.Key())
+	}
+	if err := rows.Scan(&tableID); err != nil {
+		rows.Close()
+		return 0, nil, err
+	}
+	if err := rows.Close(); err != nil {
+		return 0, nil, err
+	}
+
+	partitions = make(map[string]int64)
+	rows, err = e.db.Query(fmt.Sprintf(`select PARTITION_NAME, TIDB_PARTITION_ID from information_schema.PARTITIONS where lower(TABLE_SCHEMA)='%s' and lower(TABLE_NAME)='%s' and PARTITION_NAME is not null`, schemaName, tableName))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var id int64
+		if err := rows.Scan(&name, &id); err != nil {
+			return 0, nil, err
+		}
+		partitions[strings.ToLower(name)] = id
+	}
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+	return tableID, partitions, nil
+}
+
+// checkColumnIDs checks whether IDs of columns are still the same as their ordinal positions. A column gets a new ID
+// when it's added or modified, and IDs of dropped columns are never reused, so IDs and positions can differ after
+// these DDLs, or if the table is created by `create table ... like`.
+// Jobs are matched by both the table ID and the table name, since `truncate table` changes the table ID.
+func (e *sqlStatsExporter) checkColumnIDs(tableID int64) error {
+	schemaName, tableName := strings.ToLower(e.table.SchemaName), strings.ToLower(e.table.TableName)
+	rows, err := e.db.Query(fmt.Sprintf(`select JOB_TYPE, QUERY from information_schema.DDL_JOBS where TABLE_ID=%v or (lower(DB_NAME)='%s' and lower(TABLE_NAME)='%s')`, tableID, schemaName, tableName))
+	if err != nil {
+		return fmt.Errorf("can't read DDL jobs of %v to determine IDs of its columns: %v", e.table.Key(), err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var jobType, query string
+		if err := rows.Scan(&jobType, &query); err != nil {
+			return err
+		}
+		changed := false
+		switch jobType {
+		case "add column", "add multi-columns", "drop column", "drop multi-columns", "modify column":
+			changed = true
+		case "create table":
+			stmt, err := utils.ParseOneSQL(query)
+			if err != nil {
+				return fmt.Errorf("can't parse the DDL %v of %v: %v", query, e.table.Key(), err)
+			}
+			if create, ok := stmt.(*ast.CreateTableStmt); ok && create.ReferTable != nil {
+				changed = true
+			}
+		}
+		if changed {
+			return fmt.Errorf("IDs of columns of %v can't be determined through SQL since they're changed by the DDL '%v', please use '--stats-source=http' instead", e.table.Key(), query)
+		}
+	}
+	return rows.Err()
+}
+
+// exportPhysicalTable returns the stats of a table or a partition, returns nil if it has no stats.
+func (e *sqlStatsExporter) exportPhysicalTable(physicalID int64, partitionName string) (*exportedTableStats, error) {
+	stats := &exportedTableStats{
+		DatabaseName: strings.ToLower(e.table.SchemaName),
+		TableName:    strings.ToLower(e.table.TableName),
+		Columns:      make(map[string]*exportedColumnStats),
+		Indices:      make(map[string]*exportedColumnStats),
+	}
+	rows, err := e.db.Query(fmt.Sprintf(`select modify_count, count from mysql.stats_meta where table_id=%v`, physicalID))
+	if err != nil {
+		return nil, err
+	}
+	hasMeta := rows.Next()
+	if hasMeta {
+		if err := rows.Scan(&stats.ModifyCount, &stats.Count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+	} else if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, err
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	hists, err := e.readHistograms(physicalID)
+	if err != nil {
+		return nil, err
+	}
+	if !hasMeta && len(hists) == 0 {
+		return nil, nil
+	}
+	if err := e.readBuckets(physicalID, hists); err != nil {
+		return nil, err
+	}
+	if err := e.readTopN(physicalID, hists); err != nil {
+		return nil, err
+	}
+	if err := e.readFMSketches(physicalID, hists); err != nil {
+		return nil, err
+	}
+
+	for key, hist := range hists {
+		if key.isIndex == 1 {
+			if name, ok := e.indexNames[key.histID]; ok {
+				stats.Indices[name] = hist
+			} else {
+				utils.Debugf("[workload-export] skip stats of unknown index %v of %v", key.histID, e.table.Key())
+			}
+			continue
+		}
+		name, ok := e.columnIDs[key.histID]
+		if !ok { // hidden columns of expression indexes are not in `information_schema.COLUMNS`
+			utils.Debugf("[workload-export] skip stats of unknown column %v of %v", key.histID, e.table.Key())
+			continue
+		}
+		if err := e.verifyColumn(name, partitionName, hist); err != nil {
+			return nil, err
+		}
+		stats.Columns[name] = hist
+	}
+	return stats, nil
+}
+
+func (e *sqlStatsExporter) shownSummaries(partitionName string) map[string]histSummary {
+	if shown := e.shownHists[partitionName]; len(shown) > 0 || partitionName != "global" {
+		return shown
+	}
+	return e.shownHists[""]
+}
+
+// verifyColumn checks whether the NDV and null count of the column in `show stats_histograms` are the same as the histogram.
+func (e *sqlStatsExporter) verifyColumn(name, partitionName string, hist *exportedColumnStats) error {
+	shown := e.shownSummaries(partitionName)
+	if len(shown) == 0 { // nothing to verify
+		return nil
+	}
+	if s, ok := shown[name]; !ok || s != (histSummary{hist.Histogram.Ndv, hist.NullCount}) {
+		return fmt.Errorf("the histogram of column %v of %v doesn't match 'show stats_histograms', please use '--stats-source=http' instead", name, e.table.Key())
+	}
+	return nil
+}
+
+func (e *sqlStatsExporter) readHistograms(physicalID int64) (map[histKey]*exportedColumnStats, error) {
+	rows, err := e.db.Query(fmt.Sprintf(`select is_index, hist_id, distinct_count, version, null_count, cm_sketch, tot_col_size, stats_ver, correlation from mysql.stats_histograms where table_id=%v`, physicalID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hists := make(map[histKey]*exportedColumnStats)
+	for rows.Next() {
+		var key histKey
+		var cmSketch []byte
+		hist := &exportedColumnStats{Histogram: &tipb.Histogram{}, StatsVer: new(int64)}
+		if err := rows.Scan(&key.isIndex, &key.histID, &hist.Histogram.Ndv, &hist.LastUpdateVersion, &hist.NullCount,
+			&cmSketch, &hist.TotColSize, hist.StatsVer, &hist.Correlation); err != nil {
+			return nil, err
+		}
+		if cmSketch != nil {
+			hist.CMSketch = &tipb.CMSketch{}
+			if err := hist.CMSketch.Unmarshal(cmSketch); err != nil {
+				return nil, fmt.Errorf("invalid cm_sketch of histogram %v: %v", key.histID, err)
+			}
+		}
+		hists[key] = hist
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return hists, nil
+}
+
+func (e *sqlStatsExporter) readBuckets(physicalID int64, hists map[histKey]*exportedColumnStats) error {
+	q := `select is_index, hist_id, count, repeats, lower_bound, upper_bound, ndv from mysql.stats_buckets where table_id=%v order by is_index, hist_id, bucket_id`
+	rows, err := e.db.Query(fmt.Sprintf(q, physicalID))
+	withNDV := err == nil
+	if err != nil { // the column `ndv` is not supported in old versions
+		rows, err = e.db.Query(fmt.Sprintf(strings.Replace(q, ", ndv", "", 1), physicalID))
+		if err != nil {
+			return err
+		}
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key histKey
+		bucket := &tipb.Bucket{Ndv: new(int64)}
+		dest := []interface{}{&key.isIndex, &key.histID, &bucket.Count, &bucket.Repeats, &bucket.LowerBound, &bucket.UpperBound}
+		if withNDV {
+			dest = append(dest, bucket.Ndv)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		hist, ok := hists[key]
+		if !ok {
+			continue
+		}
+		// counts in `mysql.stats_buckets` are not accumulated, while the JSON format requires accumulated counts
+		if n := len(hist.Histogram.Buckets); n > 0 {
+			bucket.Count += hist.Histogram.Buckets[n-1].Count
+		}
+		hist.Histogram.Buckets = append(hist.Histogram.Buckets, bucket)
+	}
+	return rows.Err()
+}
+
+func (e *sqlStatsExporter) readTopN(physicalID int64, hists map[histKey]*exportedColumnStats) error {
+	rows, err := e.db.Query(fmt.Sprintf(`select is_index, hist_id, value, count from mysql.stats_top_n where table_id=%v`, physicalID))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key histKey
+		topN := &tipb.CMSketchTopN{}
+		if err := rows.Scan(&key.isIndex, &key.histID, &topN.Data, &topN.Count); err != nil {
+			return err
+		}
+		hist, ok := hists[key]
+		if !ok {
+			continue
+		}
+		if hist.CMSketch == nil {
+			hist.CMSketch = &tipb.CMSketch{}
+		}
+		hist.CMSketch.TopN = append(hist.CMSketch.TopN, topN)
+	}
+	return rows.Err()
+}
+
+// readFMSketches attaches FMSketches to column stats, which are only used to merge global stats of partitioned tables.
+func (e *sqlStatsExporter) readFMSketches(physicalID int64, hists map[histKey]*exportedColumnStats) error {
+	rows, err := e.db.Query(fmt.Sprintf(`select hist_id, value from mysql.stats_fm_sketch where table_id=%v and is_index=0`, physicalID))
+	if err != nil { // `mysql.stats_fm_sketch` is not supported in old versions
+		utils.Debugf("[workload-export] skip reading FMSketches of %v: %v", e.table.Key(), err)
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var histID int64
+		var value []byte
+		if err := rows.Scan(&histID, &value); err != nil {
+			return err
+		}
+		hist, ok := hists[histKey{0, histID}]
+		if !ok || value == nil {
+			continue
+		}
+		hist.FMSketch = &tipb.FMSketch{}
+		if err := hist.FMSketch.Unmarshal(value); err != nil {
+			return fmt.Errorf("invalid fm_sketch of histogram %v: %v", histID, err)
+		}
+	}
+	return rows.Err()
+}
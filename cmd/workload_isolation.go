@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/qw4990/index_advisor/advisor"
+	"github.com/qw4990/index_advisor/optimizer"
+	"github.com/qw4990/index_advisor/utils"
+)
+
+// workloadIsolation loads a workload into an existing TiDB under auto-generated database names to avoid conflicting
+// with the databases already there, and maps these names back to the original ones in the advise result.
+type workloadIsolation struct {
+	dsn     string
+	prefix  string                 // e.g. 'index_advisor_1a2b3c4d_'
+	schemas map[string]string      // lower-case original schema name -> isolated schema name
+	queries map[string]utils.Query // key of the isolated query -> the original query
+	renamer *utils.SQLRenamer
+	tmpDirs []string
+
+	mu          sync.Mutex // protects schemas and tmpDirs, which are read when interrupted
+	cleanupOnce sync.Once
+	unregister  func()
+}
+
+func newWorkloadIsolation(dsn string) (*workloadIsolation, error) {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	w := &workloadIsolation{
+		dsn:     dsn,
+		prefix:  fmt.Sprintf("%v%v_", utils.IsolatedSchemaPrefix, hex.EncodeToString(buf)),
+		schemas: make(map[string]string),
+		queries: make(map[string]utils.Query),
+	}
+	w.renamer = &utils.SQLRenamer{Schema: w.schema}
+	w.unregister = utils.RegisterCleanup(w.cleanup)
+	utils.Infof("load the workload into databases with the prefix %v", w.prefix)
+	return w, nil
+}
+
+// schema returns the isolated name of the schema.
+func (w *workloadIsolation) schema(name string) string {
+	if name == "" || utils.IsTiDBSystemTableName(utils.TableName{SchemaName: name}) {
+		return name
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	key := strings.ToLower(name)
+	if isolated, ok := w.schemas[key]; ok {
+		return isolated
+	}
+	isolated := w.prefix + key
+	if len(isolated) > 64 { // the max length of database names
+		isolated = fmt.Sprintf("%vdb%v", w.prefix, len(w.schemas))
+	}
+	w.schemas[key] = isolated
+	return isolated
+}
+
+// originalSchema returns the original name of the isolated schema.
+func (w *workloadIsolation) originalSchema(name string) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for original, isolated := range w.schemas {
+		if strings.EqualFold(isolated, name) {
+			return original
+		}
+	}
+	return name
+}
+
+// isolateSQL renames all schemas in the SQL to the isolated ones.
+func (w *workloadIsolation) isolateSQL(sql string) (string, error) {
+	isolated, err := w.renamer.RenameSQL(sql)
+	if err != nil {
+		return "", fmt.Errorf("failed to isolate SQL %v: %v", sql, err)
+	}
+	return isolated, nil
+}
+
+// isolateQueries renames all schemas in the queries to the isolated ones, queries which can't be parsed are skipped.
+func (w *workloadIsolation) isolateQueries(queries utils.Set[utils.Query]) utils.Set[utils.Query] {
+	isolated := utils.NewSet[utils.Query]()
+	for _, q := range queries.ToList() {
+		text, err := w.isolateSQL(q.Text)
+		if err != nil {
+			utils.Warningf("skip query %v: %v", q.Alias, err)
+			continue
+		}
+		iq := q
+		iq.SchemaName = w.schema(q.SchemaName)
+		iq.Text = text
+		w.queries[iq.Key()] = q
+		isolated.Add(iq)
+	}
+	return isolated
+}
+
+// isolateStatsDir copies all stats files to a temporary directory with the isolated schema names.
+func (w *workloadIsolation) isolateStatsDir(statsDirPath string) (string, error) {
+	if exist, isDir := utils.FileExists(statsDirPath); !exist || !isDir {
+		return statsDirPath, nil
+	}
+	tmpDir, err := os.MkdirTemp("", "index_advisor_stats_")
+	if err != nil {
+		return "", err
+	}
+	w.mu.Lock()
+	w.tmpDirs = append(w.tmpDirs, tmpDir)
+	w.mu.Unlock()
+	statsFiles, err := os.ReadDir(statsDirPath)
+	if err != nil {
+		return "", err
+	}
+	for _, statsFile := range statsFiles {
+		data, err := os.ReadFile(path.Join(statsDirPath, statsFile.Name()))
+		if err != nil {
+			return "", err
+		}
+		var stats map[string]json.RawMessage
+		if err := json.Unmarshal(data, &stats); err != nil {
+			return "", fmt.Errorf("invalid stats file %v: %v", statsFile.Name(), err)
+		}
+		var dbName string
+		if err := json.Unmarshal(stats["database_name"], &dbName); err != nil {
+			return "", fmt.Errorf("invalid stats file %v: %v", statsFile.Name(), err)
+		}
+		if stats["database_name"], err = json.Marshal(w.schema(dbName)); err != nil {
+			return "", err
+		}
+		if data, err = json.Marshal(stats); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(path.Join(tmpDir, statsFile.Name()), data, 0644); err != nil {
+			return "", err
+		}
+	}
+	return tmpDir, nil
+}
+
+// isolateConstraints renames all schemas in the constraints to the isolated ones.
+func (w *workloadIsolation) isolateConstraints(c advisor.Constraints) advisor.Constraints {
+	isolated := advisor.Constraints{TableLimits: make(map[utils.TableName]int)}
+	for _, t := range c.ExcludedTables {
+		isolated.ExcludedTables = append(isolated.ExcludedTables, utils.TableName{SchemaName: w.schema(t.SchemaName), TableName: t.TableName})
+	}
+	for _, col := range c.ExcludedColumns {
+		col.SchemaName = w.schema(col.SchemaName)
+		isolated.ExcludedColumns = append(isolated.ExcludedColumns, col)
+	}
+	isolateIndex := func(index utils.Index) utils.Index {
+		cols := make([]utils.Column, len(index.Columns))
+		for i, col := range index.Columns {
+			col.SchemaName = w.schema(col.SchemaName)
+			cols[i] = col
+		}
+		return utils.NewIndexWithColumns(index.IndexName, cols...)
+	}
+	for _, index := range c.ProposedIndexes {
+		isolated.ProposedIndexes = append(isolated.ProposedIndexes, isolateIndex(index))
+	}
+	for _, index := range c.KeptIndexes {
+		isolated.KeptIndexes = append(isolated.KeptIndexes, isolateIndex(index))
+	}
+	for t, limit := range c.TableLimits {
+		isolated.TableLimits[utils.TableName{SchemaName: w.schema(t.SchemaName), TableName: t.TableName}] = limit
+	}
+	return isolated
+}
+
+// restoreIndex maps the isolated index back to the original schema.
+func (w *workloadIsolation) restoreIndex(index utils.Index) utils.Index {
+	if w == nil {
+		return index
+	}
+	restored := index
+	restored.SchemaName = strings.ToLower(w.originalSchema(index.SchemaName))
+	restored.Columns = make([]utils.Column, len(index.Columns))
+	for i, col := range index.Columns {
+		col.SchemaName = restored.SchemaName
+		restored.Columns[i] = col
+	}
+	return restored
+}
+
+// restoreQuery maps the isolated query back to the original one.
+func (w *workloadIsolation) restoreQuery(q utils.Query) utils.Query {
+	if w == nil {
+		return q
+	}
+	if original, ok := w.queries[q.Key()]; ok {
+		return original
+	}
+	return q
+}
+
+// cleanup drops all isolated databases, it's safe to call it more than once.
+func (w *workloadIsolation) cleanup() {
+	w.cleanupOnce.Do(func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		// use a new connection since the current one may be in use when interrupted
+		db, err := optimizer.NewTiDBWhatIfOptimizer(w.dsn)
+		if err != nil {
+			utils.Errorf("failed to connect to %v to drop databases with the prefix %v: %v", w.dsn, w.prefix, err)
+		} else {
+			for _, isolated := range w.schemas {
+				utils.Infof("drop database %v", isolated)
+				if err := db.Execute(fmt.Sprintf("drop database if exists `%v`", isolated)); err != nil {
+					utils.Errorf("failed to drop database %v: %v", isolated, err)
+				}
+			}
+			db.Close()
+		}
+		for _, dir := range w.tmpDirs {
+			os.RemoveAll(dir)
+		}
+	})
+}
+
+// Release drops all isolated databases and stops watching signals.
+func (w *workloadIsolation) Release() {
+	w.cleanup()
+	w.unregister()
+}
@@ -20,6 +20,8 @@ func init() {
 	rootCmd.AddCommand(cmd.NewPreCheckCmd())
 	rootCmd.AddCommand(cmd.NewEvaluateCmd())
 	rootCmd.AddCommand(cmd.NewWorkloadExportCmd())
+	rootCmd.AddCommand(cmd.NewWorkloadAnonymizeCmd())
+	rootCmd.AddCommand(cmd.NewWorkloadDeanonymizeCmd())
 }
 
 func main() {
@@ -0,0 +1,476 @@
+//go:build embedded
+
+package optimizer
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/config"
+	"github.com/pingcap/tidb/domain"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/server"
+	"github.com/pingcap/tidb/session"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/statistics"
+	"github.com/pingcap/tidb/store/mockstore"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/codec"
+	"github.com/pingcap/tidb/util/logutil"
+	"github.com/qw4990/index_advisor/utils"
+)
+
+// EmbeddedTiDB is an in-process TiDB server on the unistore mock storage, which needs no network and no external process.
+type EmbeddedTiDB struct {
+	store kv.Storage
+	dom   *domain.Domain
+	svr   *server.Server
+	port  int
+
+	// Hypothetical indexes are not supported by the embedded TiDB, they are emulated by real indexes on the empty
+	// tables, which are visible to all sessions. To avoid affecting each other, an optimizer owns a table from creating
+	// its first index on it to dropping its last index on it, and owns the tables of a query when explaining it.
+	tableMu     sync.Mutex
+	tableCond   *sync.Cond
+	tableOwners map[string]*embeddedWhatIfOptimizer // key of the table -> its owner
+	lastOptID   uint64
+
+	// ddlLock serializes DDLs, since TiDB notifies waiting sessions through a shared channel, and concurrent DDLs have
+	// to poll their results with an interval of 0.5s or more.
+	ddlLock sync.Mutex
+}
+
+// StartEmbeddedTiDB starts an in-process TiDB server.
+func StartEmbeddedTiDB() (*EmbeddedTiDB, error) {
+	if err := logutil.InitZapLogger(logutil.NewLogConfig("error", logutil.DefaultLogFormat, "", logutil.EmptyFileLogConfig, false)); err != nil {
+		return nil, err
+	}
+	port, err := utils.GetFreePort()
+	if err != nil {
+		return nil, err
+	}
+	store, err := mockstore.NewMockStore(mockstore.WithStoreType(mockstore.EmbedUnistore))
+	if err != nil {
+		return nil, err
+	}
+	session.SetSchemaLease(0)
+	session.SetStatsLease(0) // stats are loaded by `load stats` instead of the background worker
+	dom, err := session.BootstrapSession(store)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	cfg := config.NewConfig()
+	cfg.Host = "127.0.0.1"
+	cfg.Port = uint(port)
+	cfg.Socket = ""
+	cfg.Status.ReportStatus = false
+	cfg.Experimental.AllowsExpressionIndex = true // expression indexes are experimental in this TiDB version
+	config.StoreGlobalConfig(cfg)
+	svr, err := server.NewServer(cfg, server.NewTiDBDriver(store))
+	if err != nil {
+		dom.Close()
+		store.Close()
+		return nil, err
+	}
+	svr.SetDomain(dom)
+	go func() {
+		if err := svr.Run(); err != nil {
+			utils.Errorf("embedded TiDB server exits with error: %v", err)
+		}
+	}()
+	t := &EmbeddedTiDB{store: store, dom: dom, svr: svr, port: port, tableOwners: make(map[string]*embeddedWhatIfOptimizer)}
+	t.tableCond = sync.NewCond(&t.tableMu)
+	utils.Infof("start embedded TiDB server on %v", t.DSN())
+	return t, nil
+}
+
+// DSN returns the DSN of this embedded TiDB.
+func (t *EmbeddedTiDB) DSN() string {
+	return fmt.Sprintf("root:@tcp(127.0.0.1:%v)/", t.port)
+}
+
+// Release stops this embedded TiDB.
+func (t *EmbeddedTiDB) Release() {
+	t.svr.Close()
+	t.dom.Close()
+	if err := t.store.Close(); err != nil {
+		utils.Warningf("failed to close the embedded TiDB storage: %v", err)
+	}
+}
+
+// allTables is a special table key which conflicts with all tables, it's used when the tables of a query are unknown.
+const allTables = "*"
+
+// lockTables makes the optimizer own the tables. It follows the wait-die scheme to avoid deadlocks: an optimizer owning
+// tables only waits for younger owners, and returns false instead of waiting for older ones, then it should release
+// its tables and lock them again together with new ones.
+func (t *EmbeddedTiDB) lockTables(o *embeddedWhatIfOptimizer, tables []string) bool {
+	t.tableMu.Lock()
+	defer t.tableMu.Unlock()
+	all := containsString(tables, allTables)
+	for {
+		owning, waiting, dying := false, false, false
+		for table, owner := range t.tableOwners {
+			if owner == o {
+				owning = true
+			} else if all || table == allTables || containsString(tables, table) {
+				waiting = true
+				dying = dying || owner.id < o.id
+			}
+		}
+		if !waiting {
+			for _, table := range tables {
+				t.tableOwners[table] = o
+			}
+			return true
+		}
+		if owning && dying {
+			return false
+		}
+		t.tableCond.Wait()
+	}
+}
+
+// unlockTables releases the tables owned by the optimizer.
+func (t *EmbeddedTiDB) unlockTables(o *embeddedWhatIfOptimizer, tables []string) {
+	t.tableMu.Lock()
+	defer t.tableMu.Unlock()
+	for _, table := range tables {
+		if t.tableOwners[table] == o {
+			delete(t.tableOwners, table)
+		}
+	}
+	t.tableCond.Broadcast()
+}
+
+// baseTables returns keys of all tables referenced by the query, views are replaced by their base tables.
+// It returns false if they can't be determined, e.g. the query has tables without schema names and no schema is used.
+func (t *EmbeddedTiDB) baseTables(defaultSchema, query string) ([]string, bool) {
+	names, err := utils.CollectTableNamesFromSQL(defaultSchema, query)
+	if err != nil {
+		return nil, false
+	}
+	is := t.dom.InfoSchema()
+	var tables []string
+	visited := make(map[string]bool)
+	pending := names.ToList()
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		if name.SchemaName == "" {
+			return nil, false
+		}
+		if visited[name.Key()] || utils.IsTiDBSystemTableName(name) {
+			continue
+		}
+		visited[name.Key()] = true
+		tbl, err := is.TableByName(model.NewCIStr(name.SchemaName), model.NewCIStr(name.TableName))
+		if err != nil || !tbl.Meta().IsView() {
+			tables = append(tables, name.Key())
+			continue
+		}
+		referenced, err := utils.CollectTableNamesFromSQL(name.SchemaName, tbl.Meta().View.SelectStmt)
+		if err != nil {
+			return nil, false
+		}
+		pending = append(pending, referenced.ToList()...)
+	}
+	return tables, true
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// embeddedWhatIfOptimizer is the what-if optimizer implementation on the embedded TiDB.
+type embeddedWhatIfOptimizer struct {
+	*TiDBWhatIfOptimizer
+	tidb    *EmbeddedTiDB
+	id      uint64        // the age of this optimizer used to avoid deadlocks, smaller is older
+	schema  string        // the current schema of the session
+	indexes []utils.Index // indexes created by this optimizer
+}
+
+// NewEmbeddedWhatIfOptimizer creates a new what-if optimizer on the embedded TiDB.
+func NewEmbeddedWhatIfOptimizer(t *EmbeddedTiDB) (WhatIfOptimizer, error) {
+	o, err := NewTiDBWhatIfOptimizer(t.DSN())
+	if err != nil {
+		return nil, err
+	}
+	return &embeddedWhatIfOptimizer{TiDBWhatIfOptimizer: o.(*TiDBWhatIfOptimizer), tidb: t, id: atomic.AddUint64(&t.lastOptID, 1)}, nil
+}
+
+// Clone clones this optimizer.
+func (o *embeddedWhatIfOptimizer) Clone() (WhatIfOptimizer, error) {
+	cloned, err := o.TiDBWhatIfOptimizer.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return &embeddedWhatIfOptimizer{TiDBWhatIfOptimizer: cloned.(*TiDBWhatIfOptimizer), tidb: o.tidb,
+		id: atomic.AddUint64(&o.tidb.lastOptID, 1)}, nil
+}
+
+// Execute executes the statement, and records the current schema to know which tables a query references.
+func (o *embeddedWhatIfOptimizer) Execute(sql string) error {
+	if err := o.TiDBWhatIfOptimizer.Execute(sql); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(sql)), "use") {
+		return nil
+	}
+	if stmt, err := utils.ParseOneSQL(sql); err == nil {
+		if use, ok := stmt.(*ast.UseStmt); ok {
+			o.schema = strings.ToLower(use.DBName)
+		}
+	}
+	return nil
+}
+
+// Close releases the underlying database connection and drops all indexes created by this optimizer.
+func (o *embeddedWhatIfOptimizer) Close() error {
+	o.rollback()
+	return o.TiDBWhatIfOptimizer.Close()
+}
+
+// CreateHypoIndex creates a real index to emulate a hypothetical index, and derives its stats from its columns.
+func (o *embeddedWhatIfOptimizer) CreateHypoIndex(index utils.Index) error {
+	defer o.recordStats(time.Now(), &o.stats.CreateOrDropHypoIdxTime, &o.stats.CreateOrDropHypoIdxCount)
+	table := indexTable(index)
+	if err := o.lockTables([]string{table}); err != nil {
+		return err
+	}
+	if err := o.createIndex(index); err != nil {
+		o.rollback()
+		o.tidb.unlockTables(o, []string{table})
+		return err
+	}
+	return nil
+}
+
+// createIndex creates the index on the table owned by this optimizer.
+func (o *embeddedWhatIfOptimizer) createIndex(index utils.Index) error {
+	createStmt := fmt.Sprintf(`create index %v on %v.%v (%v)`, index.IndexName, index.SchemaName, index.TableName, strings.Join(index.KeyParts(), ", "))
+	if err := o.executeDDL(createStmt); err != nil {
+		utils.Errorf("failed to create hypo index '%v': %v", createStmt, err)
+		return err
+	}
+	o.indexes = append(o.indexes, index)
+	if err := o.tidb.deriveIndexStats(index); err != nil {
+		utils.Errorf("failed to derive stats for hypo index %v: %v", index.Key(), err)
+		return err
+	}
+	return nil
+}
+
+// lockTables makes this optimizer own the tables besides the ones it already owns. If it has to release its tables to
+// avoid deadlocks, its indexes are dropped and then created again after owning all these tables.
+func (o *embeddedWhatIfOptimizer) lockTables(tables []string) error {
+	if o.tidb.lockTables(o, tables) {
+		return nil
+	}
+	indexes := o.indexes
+	o.rollback()
+	for _, index := range indexes {
+		tables = append(tables, indexTable(index))
+	}
+	o.tidb.lockTables(o, tables) // never fails since this optimizer owns no tables now
+	for _, index := range indexes {
+		if err := o.createIndex(index); err != nil {
+			o.rollback()
+			o.tidb.unlockTables(o, tables)
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *embeddedWhatIfOptimizer) executeDDL(sql string) error {
+	o.tidb.ddlLock.Lock()
+	defer o.tidb.ddlLock.Unlock()
+	return o.Execute(sql)
+}
+
+// ownsIndexOn returns whether this optimizer has indexes on the table.
+func (o *embeddedWhatIfOptimizer) ownsIndexOn(table string) bool {
+	for _, index := range o.indexes {
+		if indexTable(index) == table {
+			return true
+		}
+	}
+	return false
+}
+
+func indexTable(index utils.Index) string {
+	return utils.TableName{SchemaName: index.SchemaName, TableName: index.TableName}.Key()
+}
+
+// DropHypoIndex drops the real index which emulates a hypothetical index.
+func (o *embeddedWhatIfOptimizer) DropHypoIndex(index utils.Index) error {
+	defer o.recordStats(time.Now(), &o.stats.CreateOrDropHypoIdxTime, &o.stats.CreateOrDropHypoIdxCount)
+	pos := -1
+	for i, idx := range o.indexes {
+		if idx.IndexName == index.IndexName && idx.SchemaName == index.SchemaName && idx.TableName == index.TableName {
+			pos = i
+		}
+	}
+	if pos == -1 {
+		return fmt.Errorf("hypo index %v doesn't exist", index.IndexName)
+	}
+	if err := o.executeDDL(fmt.Sprintf("drop index %v on %v.%v", index.IndexName, index.SchemaName, index.TableName)); err != nil {
+		o.rollback()
+		return err
+	}
+	o.indexes = append(o.indexes[:pos], o.indexes[pos+1:]...)
+	if table := indexTable(index); !o.ownsIndexOn(table) {
+		o.tidb.unlockTables(o, []string{table})
+	}
+	return nil
+}
+
+// rollback drops all indexes created by this optimizer and releases their tables, it's called on errors to let
+// other optimizers continue.
+func (o *embeddedWhatIfOptimizer) rollback() {
+	if len(o.indexes) == 0 {
+		return
+	}
+	var tables []string
+	for _, index := range o.indexes {
+		if err := o.executeDDL(fmt.Sprintf("drop index if exists %v on %v.%v", index.IndexName, index.SchemaName, index.TableName)); err != nil {
+			utils.Warningf("failed to drop hypo index %v: %v", index.Key(), err)
+		}
+		tables = append(tables, indexTable(index))
+	}
+	o.indexes = nil
+	o.tidb.unlockTables(o, tables)
+}
+
+func (o *embeddedWhatIfOptimizer) ExplainQ(query utils.Query) (plan utils.Plan, err error) {
+	if query.SchemaName != "" {
+		if err := o.Execute(fmt.Sprintf("use %v", query.SchemaName)); err != nil {
+			return nil, err
+		}
+	}
+	return o.Explain(query.Text)
+}
+
+// Explain returns the execution plan of the specified query.
+func (o *embeddedWhatIfOptimizer) Explain(query string) (plan utils.Plan, err error) {
+	unlock, err := o.lockQueryTables(query)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return o.TiDBWhatIfOptimizer.Explain(query)
+}
+
+// ExplainAnalyze returns the execution plan of the specified query.
+func (o *embeddedWhatIfOptimizer) ExplainAnalyze(query string) (plan utils.Plan, err error) {
+	unlock, err := o.lockQueryTables(query)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return o.TiDBWhatIfOptimizer.ExplainAnalyze(query)
+}
+
+// lockQueryTables owns the tables referenced by the query to keep indexes of other optimizers away from them, or owns
+// allTables if they can't be determined. The returned function releases the tables which have no indexes created by
+// this optimizer.
+func (o *embeddedWhatIfOptimizer) lockQueryTables(query string) (unlock func(), err error) {
+	tables, ok := o.tidb.baseTables(o.schema, query)
+	if !ok {
+		tables = []string{allTables}
+	}
+	if err := o.lockTables(tables); err != nil {
+		return nil, err
+	}
+	return func() {
+		var unused []string
+		for _, table := range tables {
+			if !o.ownsIndexOn(table) {
+				unused = append(unused, table)
+			}
+		}
+		o.tidb.unlockTables(o, unused)
+	}, nil
+}
+
+// deriveIndexStats derives the stats of a new index from the stats of its columns, otherwise the optimizer estimates
+// the index with pseudo stats since the table is empty and the index can't be analyzed.
+// The stats of a multi-column index are approximate: its NDV is derived from all key columns by assuming they are
+// independent, which over-estimates it for correlated columns, while its buckets, TopN and null count only describe
+// the first column, so ranges on later columns are estimated by the NDV instead of their real distributions.
+func (t *EmbeddedTiDB) deriveIndexStats(index utils.Index) error {
+	is := t.dom.InfoSchema()
+	tbl, err := is.TableByName(model.NewCIStr(index.SchemaName), model.NewCIStr(index.TableName))
+	if err != nil {
+		return err
+	}
+	tblInfo := tbl.Meta()
+	idxInfo := tblInfo.FindIndexByName(strings.ToLower(index.IndexName))
+	if idxInfo == nil {
+		return fmt.Errorf("index %v doesn't exist", index.IndexName)
+	}
+	h := t.dom.StatsHandle()
+	tblStats, err := h.TableStatsFromStorage(tblInfo, tblInfo.ID, true, 0)
+	if err != nil || tblStats == nil || tblStats.Pseudo {
+		return err // no stats for this table, use pseudo stats
+	}
+
+	// the NDV of a multi-column index is estimated by assuming its columns are independent
+	ndv := 1.0
+	var firstCol *statistics.Column
+	for i, idxCol := range idxInfo.Columns {
+		col := tblStats.Columns[tblInfo.Columns[idxCol.Offset].ID]
+		if col == nil || col.Histogram.Len() == 0 {
+			if i == 0 {
+				return nil // no stats for the first column, use pseudo stats
+			}
+			continue
+		}
+		if i == 0 {
+			firstCol = col
+		}
+		ndv *= float64(col.Histogram.NDV)
+	}
+	ndv = math.Min(ndv, float64(tblStats.Count))
+
+	sc := &stmtctx.StatementContext{TimeZone: time.UTC}
+	hist := statistics.NewHistogram(idxInfo.ID, int64(ndv), firstCol.NullCount, 0, types.NewFieldType(mysql.TypeBlob), firstCol.Histogram.Len(), 0)
+	for i := 0; i < firstCol.Histogram.Len(); i++ {
+		lower, err := codec.EncodeKey(sc, nil, *firstCol.Histogram.GetLower(i))
+		if err != nil {
+			return err
+		}
+		upper, err := codec.EncodeKey(sc, nil, *firstCol.Histogram.GetUpper(i))
+		if err != nil {
+			return err
+		}
+		lowerDatum, upperDatum := types.NewBytesDatum(lower), types.NewBytesDatum(upper)
+		bucket := firstCol.Histogram.Buckets[i]
+		hist.AppendBucketWithNDV(&lowerDatum, &upperDatum, bucket.Count, bucket.Repeat, bucket.NDV)
+	}
+	// TopN of columns are encoded in the same way as indexes only in stats version 2
+	var topN *statistics.TopN
+	if firstCol.StatsVer >= statistics.Version2 && firstCol.TopN != nil {
+		topN = firstCol.TopN.Copy()
+	}
+	if err := h.SaveStatsToStorage(tblInfo.ID, tblStats.Count, 1, hist, nil, topN, nil, int(firstCol.StatsVer), 1); err != nil {
+		return err
+	}
+	return h.Update(is)
+}
@@ -0,0 +1,29 @@
+//go:build !embedded
+
+package optimizer
+
+import "errors"
+
+var errEmbeddedNotBuilt = errors.New("the embedded TiDB is not built in this binary, " +
+	"please rebuild it with `go build -tags embedded -ldflags=-checklinkname=0`")
+
+// EmbeddedTiDB is an in-process TiDB server, which is only available with the `embedded` build tag.
+type EmbeddedTiDB struct{}
+
+// StartEmbeddedTiDB starts an in-process TiDB server, which is only available with the `embedded` build tag.
+func StartEmbeddedTiDB() (*EmbeddedTiDB, error) {
+	return nil, errEmbeddedNotBuilt
+}
+
+// DSN returns the DSN of this embedded TiDB.
+func (t *EmbeddedTiDB) DSN() string {
+	return ""
+}
+
+// Release stops this embedded TiDB.
+func (t *EmbeddedTiDB) Release() {}
+
+// NewEmbeddedWhatIfOptimizer creates a new what-if optimizer on the embedded TiDB.
+func NewEmbeddedWhatIfOptimizer(t *EmbeddedTiDB) (WhatIfOptimizer, error) {
+	return nil, errEmbeddedNotBuilt
+}
@@ -0,0 +1,139 @@
+//go:build embedded
+
+package optimizer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pingcap/parser/model"
+	"github.com/qw4990/index_advisor/utils"
+)
+
+func TestEmbeddedWhatIfOptimizer(t *testing.T) {
+	tidb, err := StartEmbeddedTiDB()
+	must(err)
+	defer tidb.Release()
+	o, err := NewEmbeddedWhatIfOptimizer(tidb)
+	must(err)
+	defer o.Close()
+
+	must(o.Execute(`create database test_embedded`))
+	must(o.Execute(`use test_embedded`))
+	must(o.Execute(`create table t (a int, b int)`))
+	must(o.Execute(`set @@tidb_analyze_version=1`))
+	for i := 0; i < 100; i++ {
+		must(o.Execute(fmt.Sprintf(`insert into t values (%v, %v)`, i, i%10)))
+	}
+	must(o.Execute(`analyze table t`))
+
+	// dump the stats and load them into an empty table, which is how the offline mode works
+	statsPath := path.Join(t.TempDir(), "stats.json")
+	h := tidb.dom.StatsHandle()
+	is := tidb.dom.InfoSchema()
+	tbl, err := is.TableByName(model.NewCIStr("test_embedded"), model.NewCIStr("t"))
+	must(err)
+	jsonTbl, err := h.DumpStatsToJSON("test_embedded", tbl.Meta(), nil)
+	must(err)
+	data, err := json.Marshal(jsonTbl)
+	must(err)
+	must(os.WriteFile(statsPath, data, 0644))
+	must(o.Execute(`truncate table t`))
+	mysql.RegisterLocalFile(statsPath)
+	must(o.Execute(fmt.Sprintf(`load stats '%v'`, statsPath)))
+
+	q := `select * from t where a=1`
+	p1, err := o.Explain(q)
+	must(err)
+	idx := utils.NewIndex("test_embedded", "t", "idx_a", "a")
+	must(o.CreateHypoIndex(idx))
+	p2, err := o.Explain(q)
+	must(err)
+	if len(p2) != 3 || p2[1][1] != "1.00" { // the index stats are derived from the stats of column a
+		t.Fatalf("unexpected plan %v", p2)
+	}
+	must(o.DropHypoIndex(idx))
+	p3, err := o.Explain(q)
+	must(err)
+	if !(p2.PlanCost() < p1.PlanCost()) || p1.PlanCost() != p3.PlanCost() {
+		t.Fatalf("unexpected plan costs %v, %v, %v", p1.PlanCost(), p2.PlanCost(), p3.PlanCost())
+	}
+}
+
+func TestEmbeddedWhatIfOptimizerTableLocks(t *testing.T) {
+	tidb, err := StartEmbeddedTiDB()
+	must(err)
+	defer tidb.Release()
+	o1, err := NewEmbeddedWhatIfOptimizer(tidb)
+	must(err)
+	defer o1.Close()
+	must(o1.Execute(`create database test_locks`))
+	must(o1.Execute(`use test_locks`))
+	must(o1.Execute(`create table t (a int, b int)`))
+	must(o1.Execute(`create table s (a int, b int)`))
+	o2, err := o1.Clone()
+	must(err)
+	defer o2.Close()
+	must(o2.Execute(`use test_locks`))
+
+	explained := func(o WhatIfOptimizer, q string) chan utils.Plan {
+		ch := make(chan utils.Plan, 1)
+		go func() {
+			p, err := o.Explain(q)
+			must(err)
+			ch <- p
+		}()
+		return ch
+	}
+	usesIndex := func(p utils.Plan, name string) bool {
+		return strings.Contains(fmt.Sprint(p), name)
+	}
+
+	// indexes on different tables don't block each other
+	idxT, idxS := utils.NewIndex("test_locks", "t", "idx_t", "a"), utils.NewIndex("test_locks", "s", "idx_s", "a")
+	must(o1.CreateHypoIndex(idxT))
+	select {
+	case p := <-explained(o2, `select * from s where a=1`):
+		if usesIndex(p, "idx_t") {
+			t.Fatalf("unexpected plan %v", p)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("explaining a query on another table is blocked")
+	}
+
+	// queries on the same table wait for the index to be dropped
+	ch := explained(o2, `select * from t where a=1`)
+	select {
+	case p := <-ch:
+		t.Fatalf("explaining a query on the indexed table isn't blocked, plan: %v", p)
+	case <-time.After(500 * time.Millisecond):
+	}
+	must(o1.DropHypoIndex(idxT))
+	if p := <-ch; usesIndex(p, "idx_t") {
+		t.Fatalf("unexpected plan %v", p)
+	}
+
+	// optimizers explaining queries on each other's tables don't deadlock, the younger one releases its tables
+	must(o1.CreateHypoIndex(idxT))
+	must(o2.CreateHypoIndex(idxS))
+	ch1, ch2 := explained(o1, `select * from s where a=1`), explained(o2, `select * from t where a=1`)
+	select {
+	case p := <-ch1:
+		if usesIndex(p, "idx_s") {
+			t.Fatalf("unexpected plan %v", p)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatalf("optimizers are deadlocked")
+	}
+	must(o1.DropHypoIndex(idxT))
+	if p := <-ch2; usesIndex(p, "idx_t") {
+		t.Fatalf("unexpected plan %v", p)
+	}
+	must(o2.DropHypoIndex(idxS)) // it's created again after owning both tables
+}
@@ -0,0 +1,225 @@
+package utils
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// BundleVersion is the current version of the workload bundle format.
+	BundleVersion = 1
+	// BundleManifestName is the name of the manifest file in a workload bundle.
+	BundleManifestName = "manifest.json"
+)
+
+// BundleManifest describes the content of a workload bundle.
+type BundleManifest struct {
+	Version           int               `json:"version"`
+	SourceTiDBVersion string            `json:"source_tidb_version"` // e.g. 'v7.1.0'
+	ExportTime        time.Time         `json:"export_time"`
+	CostModelVersion  string            `json:"cost_model_version,omitempty"` // empty if unknown
+	TableRowCounts    map[string]int64  `json:"table_row_counts"`             // schema.table -> row count
+	Checksums         map[string]string `json:"checksums"`                    // relative file path -> sha256
+}
+
+// IsBundlePath returns whether the given path is a workload bundle.
+func IsBundlePath(fpath string) bool {
+	fpath = strings.ToLower(fpath)
+	return strings.HasSuffix(fpath, ".tar.gz") || strings.HasSuffix(fpath, ".tgz")
+}
+
+// PackBundle packs all files under the dir into a compressed workload bundle with the manifest.
+// Checksums of the manifest are calculated here.
+func PackBundle(dir, bundlePath string, manifest BundleManifest) error {
+	files, err := listFiles(dir)
+	if err != nil {
+		return err
+	}
+	manifest.Version = BundleVersion
+	manifest.Checksums = make(map[string]string, len(files))
+	for _, f := range files {
+		checksum, err := fileChecksum(path.Join(dir, f))
+		if err != nil {
+			return err
+		}
+		manifest.Checksums[f] = checksum
+	}
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	gw := gzip.NewWriter(out)
+	tw := tar.NewWriter(gw)
+	// the manifest is the first entry to let readers check it before extracting other files
+	if err := tw.WriteHeader(&tar.Header{Name: BundleManifestName, Mode: 0644, Size: int64(len(manifestData)), ModTime: manifest.ExportTime}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifestData); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := addFileToTar(tw, path.Join(dir, f), f); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// UnpackBundle extracts the workload bundle into the dir, and verifies the checksums of all files.
+func UnpackBundle(bundlePath, dir string) (BundleManifest, error) {
+	var manifest BundleManifest
+	in, err := os.Open(bundlePath)
+	if err != nil {
+		return manifest, err
+	}
+	defer in.Close()
+	gr, err := gzip.NewReader(in)
+	if err != nil {
+		return manifest, fmt.Errorf("invalid bundle %v: %v", bundlePath, err)
+	}
+	tr := tar.NewReader(gr)
+	var extracted []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, fmt.Errorf("invalid bundle %v: %v", bundlePath, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || strings.HasPrefix(name, "..") {
+			return manifest, fmt.Errorf("invalid file path %v in bundle %v", hdr.Name, bundlePath)
+		}
+		if name == BundleManifestName {
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return manifest, fmt.Errorf("invalid manifest in bundle %v: %v", bundlePath, err)
+			}
+			if manifest.Version > BundleVersion {
+				return manifest, fmt.Errorf("unsupported bundle version %v, the latest supported version is %v", manifest.Version, BundleVersion)
+			}
+			continue
+		}
+		fpath := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			return manifest, err
+		}
+		f, err := os.Create(fpath)
+		if err != nil {
+			return manifest, err
+		}
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			return manifest, err
+		}
+		if err := f.Close(); err != nil {
+			return manifest, err
+		}
+		extracted = append(extracted, name)
+	}
+
+	if manifest.Version == 0 {
+		return manifest, fmt.Errorf("no manifest in bundle %v", bundlePath)
+	}
+	if len(extracted) != len(manifest.Checksums) {
+		return manifest, fmt.Errorf("bundle %v contains %v files but its manifest lists %v files", bundlePath, len(extracted), len(manifest.Checksums))
+	}
+	for _, name := range extracted {
+		expected, ok := manifest.Checksums[name]
+		if !ok {
+			return manifest, fmt.Errorf("file %v is not listed in the manifest of bundle %v", name, bundlePath)
+		}
+		checksum, err := fileChecksum(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return manifest, err
+		}
+		if checksum != expected {
+			return manifest, fmt.Errorf("checksum mismatch for %v in bundle %v, the bundle may be corrupted", name, bundlePath)
+		}
+	}
+	return manifest, nil
+}
+
+// listFiles returns relative paths of all regular files under the dir in a stable order.
+func listFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(fpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, fpath)
+		if err != nil {
+			return err
+		}
+		if rel = filepath.ToSlash(rel); rel != BundleManifestName {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+func addFileToTar(tw *tar.Writer, fpath, name string) error {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+func fileChecksum(fpath string) (string, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
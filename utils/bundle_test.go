@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestBundle(t *testing.T) {
+	dir := t.TempDir()
+	must(SaveContentTo(path.Join(dir, "schema.sql"), "create table t (a int)"))
+	must(SaveContentTo(path.Join(dir, "queries.sql"), "select * from t where a=1"))
+	must(os.MkdirAll(path.Join(dir, "stats"), 0755))
+	must(SaveContentTo(path.Join(dir, "stats", "test_t.json"), `{"database_name": "test", "table_name": "t"}`))
+
+	bundlePath := path.Join(t.TempDir(), "workload.tar.gz")
+	if !IsBundlePath(bundlePath) {
+		t.Fatalf("%v should be a bundle path", bundlePath)
+	}
+	must(PackBundle(dir, bundlePath, BundleManifest{
+		SourceTiDBVersion: "v7.1.0",
+		ExportTime:        time.Now(),
+		CostModelVersion:  "2",
+		TableRowCounts:    map[string]int64{"test.t": 100},
+	}))
+
+	outDir := t.TempDir()
+	manifest, err := UnpackBundle(bundlePath, outDir)
+	must(err)
+	if manifest.SourceTiDBVersion != "v7.1.0" || manifest.CostModelVersion != "2" ||
+		manifest.TableRowCounts["test.t"] != 100 || len(manifest.Checksums) != 3 {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+	data, err := os.ReadFile(path.Join(outDir, "stats", "test_t.json"))
+	must(err)
+	if string(data) != `{"database_name": "test", "table_name": "t"}` {
+		t.Fatalf("unexpected content %v", string(data))
+	}
+
+	// a bundle whose file doesn't match its checksum
+	f, err := os.Create(bundlePath)
+	must(err)
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	for name, content := range map[string]string{
+		BundleManifestName: `{"version": 1, "checksums": {"queries.sql": "bad"}}`,
+		"queries.sql":      "select * from t where a=1",
+	} {
+		must(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		must(err)
+	}
+	must(tw.Close())
+	must(gw.Close())
+	must(f.Close())
+	if _, err := UnpackBundle(bundlePath, t.TempDir()); err == nil {
+		t.Fatalf("expect a checksum mismatch error")
+	}
+}
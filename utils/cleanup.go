@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var (
+	cleanupMu      sync.Mutex
+	cleanupFuncs   []*cleanupFunc
+	cleanupWatcher sync.Once
+)
+
+type cleanupFunc struct {
+	f func()
+}
+
+// RegisterCleanup registers a function to release external resources (e.g. processes and databases) when this
+// process is interrupted by SIGINT or SIGTERM, functions are called in the reverse order of registration.
+// The returned function unregisters it, and should be called after releasing the resources normally.
+func RegisterCleanup(f func()) (unregister func()) {
+	cleanupWatcher.Do(func() {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			sig := <-ch
+			Warningf("receive signal %v, clean up and exit", sig)
+			runCleanups()
+			os.Exit(1)
+		}()
+	})
+
+	c := &cleanupFunc{f}
+	cleanupMu.Lock()
+	cleanupFuncs = append(cleanupFuncs, c)
+	cleanupMu.Unlock()
+	return func() {
+		cleanupMu.Lock()
+		defer cleanupMu.Unlock()
+		for i := range cleanupFuncs {
+			if cleanupFuncs[i] == c {
+				cleanupFuncs = append(cleanupFuncs[:i], cleanupFuncs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func runCleanups() {
+	cleanupMu.Lock()
+	funcs := cleanupFuncs
+	cleanupFuncs = nil
+	cleanupMu.Unlock()
+	for i := len(funcs) - 1; i >= 0; i-- {
+		funcs[i].f()
+	}
+}
@@ -0,0 +1,486 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pingcap/parser/ast"
+)
+
+// ViewSchema represents the schema of a view.
+type ViewSchema struct {
+	SchemaName     string
+	ViewName       string
+	Columns        []string // the column list of `create view v (a, b) as ...`, empty if not specified
+	SelectText     string   // the select statement of the view, tables in it are not qualified by the schema name
+	CreateStmtText string   // `create view v as ...`
+}
+
+// Key returns the key of the view schema, which is the same as the key of its TableName.
+func (v ViewSchema) Key() string {
+	return TableName{SchemaName: v.SchemaName, TableName: v.ViewName}.Key()
+}
+
+// SchemaModel is an in-memory model of tables, indexes and views, which is kept in sync with the DDL statements
+// applied to it. Schema, table, column and index names in it are in lower case, and CreateStmtText of tables is the
+// original create statement, which doesn't reflect later changes like `alter table`.
+type SchemaModel struct {
+	CurrentDB string // the database used by statements without a schema name
+
+	tables map[string]TableSchema // lower-case `schema.table`
+	views  map[string]ViewSchema  // lower-case `schema.view`
+}
+
+// NewSchemaModel creates an empty SchemaModel, currentDB is the database used at first.
+func NewSchemaModel(currentDB string) *SchemaModel {
+	return &SchemaModel{
+		CurrentDB: currentDB,
+		tables:    make(map[string]TableSchema),
+		views:     make(map[string]ViewSchema),
+	}
+}
+
+// Tables returns all tables in this model ordered by their names.
+func (m *SchemaModel) Tables() []TableSchema {
+	tables := make([]TableSchema, 0, len(m.tables))
+	for _, t := range m.tables {
+		tables = append(tables, t)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Key() < tables[j].Key() })
+	return tables
+}
+
+// Views returns all views in this model ordered by their names.
+func (m *SchemaModel) Views() []ViewSchema {
+	views := make([]ViewSchema, 0, len(m.views))
+	for _, v := range m.views {
+		views = append(views, v)
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].Key() < views[j].Key() })
+	return views
+}
+
+// Table returns the table with the given name, an empty schemaName means the current database.
+// It's safe to call it on a nil model.
+func (m *SchemaModel) Table(schemaName, tableName string) (TableSchema, bool) {
+	if m == nil {
+		return TableSchema{}, false
+	}
+	t, ok := m.tables[m.key(schemaName, tableName)]
+	return t, ok
+}
+
+// View returns the view with the given name, an empty schemaName means the current database.
+// It's safe to call it on a nil model.
+func (m *SchemaModel) View(schemaName, viewName string) (ViewSchema, bool) {
+	if m == nil {
+		return ViewSchema{}, false
+	}
+	v, ok := m.views[m.key(schemaName, viewName)]
+	return v, ok
+}
+
+func (m *SchemaModel) schemaName(name string) string {
+	if name == "" {
+		return m.CurrentDB
+	}
+	return name
+}
+
+func (m *SchemaModel) key(schemaName, name string) string {
+	return strings.ToLower(fmt.Sprintf("%v.%v", m.schemaName(schemaName), name))
+}
+
+// Apply applies the statement, which has been executed successfully, to this model.
+// Statements which don't change schemas (e.g. `insert` and `set`) are ignored, an error is returned if the statement
+// is inconsistent with this model, e.g. dropping a table that doesn't exist.
+func (m *SchemaModel) Apply(stmt ast.StmtNode) error {
+	switch x := stmt.(type) {
+	case *ast.UseStmt:
+		m.CurrentDB = x.DBName
+	case *ast.DropDatabaseStmt:
+		m.dropDatabase(x.Name)
+	case *ast.CreateTableStmt:
+		return m.createTable(x)
+	case *ast.DropTableStmt:
+		for _, t := range x.Tables {
+			if err := m.dropTable(t, x.IsView, x.IfExists); err != nil {
+				return err
+			}
+		}
+	case *ast.RenameTableStmt:
+		for _, t2t := range x.TableToTables {
+			if err := m.renameTable(t2t.OldTable, t2t.NewTable); err != nil {
+				return err
+			}
+		}
+	case *ast.CreateIndexStmt:
+		return m.alterTable(x.Table, func(t *TableSchema) error {
+			if x.IfNotExists && findIndex(t.Indexes, x.IndexName) != -1 {
+				return nil
+			}
+			return addIndex(t, x.IndexName, x.IndexPartSpecifications)
+		})
+	case *ast.DropIndexStmt:
+		return m.alterTable(x.Table, func(t *TableSchema) error {
+			return dropIndex(t, x.IndexName, x.IfExists)
+		})
+	case *ast.AlterTableStmt:
+		table := x.Table
+		for _, spec := range x.Specs {
+			if spec.Tp == ast.AlterTableRenameTable {
+				if err := m.renameTable(table, spec.NewTable); err != nil {
+					return err
+				}
+				table = spec.NewTable // following specs are applied to the new table
+				continue
+			}
+			if err := m.alterTable(table, func(t *TableSchema) error { return alterTableSpec(t, spec) }); err != nil {
+				return err
+			}
+		}
+	case *ast.CreateViewStmt:
+		return m.createView(x)
+	}
+	return nil
+}
+
+func (m *SchemaModel) dropDatabase(name string) {
+	for k, t := range m.tables {
+		if strings.EqualFold(t.SchemaName, name) {
+			delete(m.tables, k)
+		}
+	}
+	for k, v := range m.views {
+		if strings.EqualFold(v.SchemaName, name) {
+			delete(m.views, k)
+		}
+	}
+}
+
+func (m *SchemaModel) createTable(stmt *ast.CreateTableStmt) error {
+	schemaName := strings.ToLower(m.schemaName(stmt.Table.Schema.O))
+	k := m.key(schemaName, stmt.Table.Name.O)
+	if _, ok := m.tables[k]; ok {
+		if stmt.IfNotExists {
+			return nil
+		}
+		return fmt.Errorf("table %v.%v already exists", schemaName, stmt.Table.Name.O)
+	}
+
+	var t TableSchema
+	if stmt.ReferTable != nil { // create table t2 like t1
+		refer, ok := m.tables[m.key(stmt.ReferTable.Schema.O, stmt.ReferTable.Name.O)]
+		if !ok {
+			return fmt.Errorf("table %v.%v does not exist", m.schemaName(stmt.ReferTable.Schema.O), stmt.ReferTable.Name.O)
+		}
+		t = renameTableSchema(refer, schemaName, stmt.Table.Name.L)
+	} else {
+		t = createTableSchema(schemaName, stmt)
+	}
+	t.CreateStmtText = stmt.Text()
+	m.tables[k] = t
+	return nil
+}
+
+func (m *SchemaModel) dropTable(table *ast.TableName, isView, ifExists bool) error {
+	k := m.key(table.Schema.O, table.Name.O)
+	if isView {
+		if _, ok := m.views[k]; !ok && !ifExists {
+			return fmt.Errorf("view %v.%v does not exist", m.schemaName(table.Schema.O), table.Name.O)
+		}
+		delete(m.views, k)
+		return nil
+	}
+	if _, ok := m.tables[k]; !ok && !ifExists {
+		return fmt.Errorf("table %v.%v does not exist", m.schemaName(table.Schema.O), table.Name.O)
+	}
+	delete(m.tables, k)
+	return nil
+}
+
+func (m *SchemaModel) renameTable(oldTable, newTable *ast.TableName) error {
+	oldKey, newKey := m.key(oldTable.Schema.O, oldTable.Name.O), m.key(newTable.Schema.O, newTable.Name.O)
+	t, ok := m.tables[oldKey]
+	if !ok {
+		return fmt.Errorf("table %v.%v does not exist", m.schemaName(oldTable.Schema.O), oldTable.Name.O)
+	}
+	if _, ok := m.tables[newKey]; ok && newKey != oldKey {
+		return fmt.Errorf("table %v.%v already exists", m.schemaName(newTable.Schema.O), newTable.Name.O)
+	}
+	delete(m.tables, oldKey)
+	m.tables[newKey] = renameTableSchema(t, strings.ToLower(m.schemaName(newTable.Schema.O)), newTable.Name.L)
+	return nil
+}
+
+// alterTable applies f to a copy of the table, and saves the copy if f succeeds.
+func (m *SchemaModel) alterTable(table *ast.TableName, f func(t *TableSchema) error) error {
+	k := m.key(table.Schema.O, table.Name.O)
+	t, ok := m.tables[k]
+	if !ok {
+		return fmt.Errorf("table %v.%v does not exist", m.schemaName(table.Schema.O), table.Name.O)
+	}
+	t.Columns = append([]Column(nil), t.Columns...)
+	t.Indexes = append([]Index(nil), t.Indexes...)
+	if err := f(&t); err != nil {
+		return err
+	}
+	m.tables[k] = t
+	return nil
+}
+
+func (m *SchemaModel) createView(stmt *ast.CreateViewStmt) error {
+	schemaName := strings.ToLower(m.schemaName(stmt.ViewName.Schema.O))
+	k := m.key(schemaName, stmt.ViewName.Name.O)
+	if _, ok := m.views[k]; ok && !stmt.OrReplace {
+		return fmt.Errorf("view %v.%v already exists", schemaName, stmt.ViewName.Name.O)
+	}
+	v, err := createViewSchema(schemaName, stmt)
+	if err != nil {
+		return err
+	}
+	v.CreateStmtText = stmt.Text()
+	m.views[k] = v
+	return nil
+}
+
+// createTableSchema returns the TableSchema of the create table statement, indexes defined by constraints and
+// column options (e.g. `a int primary key`) are included.
+func createTableSchema(schemaName string, stmt *ast.CreateTableStmt) TableSchema {
+	t := TableSchema{
+		SchemaName: schemaName,
+		TableName:  stmt.Table.Name.L,
+	}
+	addColumns(&t, stmt.Cols)
+	for _, c := range stmt.Constraints {
+		addConstraint(&t, c)
+	}
+	return t
+}
+
+// addColumns adds the columns to the table, and the indexes defined by their options.
+func addColumns(t *TableSchema, cols []*ast.ColumnDef) {
+	for _, colDef := range cols {
+		t.Columns = append(t.Columns, Column{
+			SchemaName: t.SchemaName,
+			TableName:  t.TableName,
+			ColumnName: colDef.Name.Name.L,
+			ColumnType: colDef.Tp.Clone(),
+		})
+	}
+	for _, colDef := range cols {
+		key := []*ast.IndexPartSpecification{{Column: colDef.Name}}
+		for _, opt := range colDef.Options {
+			switch opt.Tp {
+			case ast.ColumnOptionPrimaryKey:
+				addIndex(t, "primary", key)
+			case ast.ColumnOptionUniqKey:
+				addIndex(t, "", key)
+			}
+		}
+	}
+}
+
+// addConstraint adds the index defined by the constraint to the table, other constraints are ignored.
+func addConstraint(t *TableSchema, c *ast.Constraint) {
+	switch c.Tp {
+	case ast.ConstraintPrimaryKey:
+		addIndex(t, "primary", c.Keys)
+	case ast.ConstraintKey, ast.ConstraintIndex, ast.ConstraintUniq, ast.ConstraintUniqKey, ast.ConstraintUniqIndex:
+		if c.IfNotExists && findIndex(t.Indexes, c.Name) != -1 {
+			return
+		}
+		addIndex(t, c.Name, c.Keys)
+	}
+}
+
+// addIndex adds an index to the table, an unnamed index is named after its first column like TiDB.
+// Indexes with expressions referring to more than one column are ignored since they can't be represented by columns.
+func addIndex(t *TableSchema, name string, keys []*ast.IndexPartSpecification) error {
+	var cols []Column
+	for _, key := range keys {
+		if key.Expr != nil {
+			text, column, ok, err := IndexExpression(key.Expr)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				Debugf("ignore the expression index %v on %v", name, t.Key())
+				return nil
+			}
+			cols = append(cols, NewExpressionColumn(t.SchemaName, t.TableName, column, text))
+			continue
+		}
+		col := NewColumn(t.SchemaName, t.TableName, key.Column.Name.L)
+		if key.Length > 0 {
+			col.Length = key.Length
+		}
+		cols = append(cols, col)
+	}
+	if len(cols) == 0 {
+		return nil
+	}
+	if name == "" {
+		prefix := cols[0].ColumnName
+		if cols[0].Expression != "" {
+			prefix = "expression_index" // named like TiDB
+		}
+		name = prefix
+		for i := 2; findIndex(t.Indexes, name) != -1; i++ {
+			name = fmt.Sprintf("%v_%v", prefix, i)
+		}
+	} else if findIndex(t.Indexes, name) != -1 {
+		return fmt.Errorf("index %v on %v already exists", name, t.Key())
+	}
+	t.Indexes = append(t.Indexes, NewIndexWithColumns(name, cols...))
+	return nil
+}
+
+func dropIndex(t *TableSchema, name string, ifExists bool) error {
+	i := findIndex(t.Indexes, name)
+	if i == -1 {
+		if ifExists {
+			return nil
+		}
+		return fmt.Errorf("index %v on %v does not exist", name, t.Key())
+	}
+	t.Indexes = append(t.Indexes[:i], t.Indexes[i+1:]...)
+	return nil
+}
+
+func findIndex(indexes []Index, name string) int {
+	for i, idx := range indexes {
+		if strings.EqualFold(idx.IndexName, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func findColumn(cols []Column, name string) int {
+	for i, col := range cols {
+		if strings.EqualFold(col.ColumnName, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// alterTableSpec applies an `alter table` spec except `rename to`, specs which don't change columns or indexes,
+// e.g. partitions and table options, are ignored.
+func alterTableSpec(t *TableSchema, spec *ast.AlterTableSpec) error {
+	switch spec.Tp {
+	case ast.AlterTableAddColumns:
+		for _, col := range spec.NewColumns {
+			if findColumn(t.Columns, col.Name.Name.O) != -1 {
+				return fmt.Errorf("column %v on %v already exists", col.Name.Name.O, t.Key())
+			}
+		}
+		addColumns(t, spec.NewColumns)
+		for _, c := range spec.NewConstraints {
+			addConstraint(t, c)
+		}
+	case ast.AlterTableAddConstraint:
+		if c := spec.Constraint; c.Tp == ast.ConstraintPrimaryKey {
+			return addIndex(t, "primary", c.Keys)
+		} else if c.IfNotExists && findIndex(t.Indexes, c.Name) != -1 {
+			return nil
+		}
+		switch spec.Constraint.Tp {
+		case ast.ConstraintKey, ast.ConstraintIndex, ast.ConstraintUniq, ast.ConstraintUniqKey, ast.ConstraintUniqIndex:
+			return addIndex(t, spec.Constraint.Name, spec.Constraint.Keys)
+		}
+	case ast.AlterTableDropIndex:
+		return dropIndex(t, spec.Name, spec.IfExists)
+	case ast.AlterTableDropPrimaryKey:
+		return dropIndex(t, "primary", false)
+	case ast.AlterTableRenameIndex:
+		i := findIndex(t.Indexes, spec.FromKey.O)
+		if i == -1 {
+			return fmt.Errorf("index %v on %v does not exist", spec.FromKey.O, t.Key())
+		}
+		t.Indexes[i].IndexName = spec.ToKey.L
+	case ast.AlterTableDropColumn:
+		name := spec.OldColumnName.Name.L
+		i := findColumn(t.Columns, name)
+		if i == -1 {
+			if spec.IfExists {
+				return nil
+			}
+			return fmt.Errorf("column %v on %v does not exist", name, t.Key())
+		}
+		t.Columns = append(t.Columns[:i], t.Columns[i+1:]...)
+		// the column is removed from indexes, and indexes without any column are dropped
+		indexes := t.Indexes[:0]
+		for _, idx := range t.Indexes {
+			cols := make([]Column, 0, len(idx.Columns))
+			for _, col := range idx.Columns {
+				if col.ColumnName != name {
+					cols = append(cols, col)
+				}
+			}
+			if len(cols) > 0 {
+				idx.Columns = cols
+				indexes = append(indexes, idx)
+			}
+		}
+		t.Indexes = indexes
+	case ast.AlterTableModifyColumn, ast.AlterTableChangeColumn:
+		oldName := spec.NewColumns[0].Name.Name.L
+		if spec.OldColumnName != nil { // change column
+			oldName = spec.OldColumnName.Name.L
+		}
+		i := findColumn(t.Columns, oldName)
+		if i == -1 {
+			return fmt.Errorf("column %v on %v does not exist", oldName, t.Key())
+		}
+		t.Columns[i].ColumnType = spec.NewColumns[0].Tp.Clone()
+		renameColumn(t, oldName, spec.NewColumns[0].Name.Name.L)
+	case ast.AlterTableRenameColumn:
+		if findColumn(t.Columns, spec.OldColumnName.Name.L) == -1 {
+			return fmt.Errorf("column %v on %v does not exist", spec.OldColumnName.Name.O, t.Key())
+		}
+		renameColumn(t, spec.OldColumnName.Name.L, spec.NewColumnName.Name.L)
+	}
+	return nil
+}
+
+// renameColumn renames the column in the table and its indexes.
+func renameColumn(t *TableSchema, oldName, newName string) {
+	if oldName == newName {
+		return
+	}
+	t.Columns[findColumn(t.Columns, oldName)].ColumnName = newName
+	for i, idx := range t.Indexes {
+		cols := append([]Column(nil), idx.Columns...)
+		for j := range cols {
+			if cols[j].ColumnName == oldName {
+				cols[j].ColumnName = newName
+			}
+		}
+		t.Indexes[i].Columns = cols
+	}
+}
+
+// renameTableSchema returns a copy of the table with the new name.
+func renameTableSchema(t TableSchema, schemaName, tableName string) TableSchema {
+	renamed := TableSchema{
+		SchemaName:     schemaName,
+		TableName:      tableName,
+		CreateStmtText: t.CreateStmtText,
+	}
+	for _, col := range t.Columns {
+		col.SchemaName, col.TableName = schemaName, tableName
+		renamed.Columns = append(renamed.Columns, col)
+	}
+	for _, idx := range t.Indexes {
+		cols := make([]Column, len(idx.Columns))
+		for i, col := range idx.Columns {
+			col.SchemaName, col.TableName = schemaName, tableName
+			cols[i] = col
+		}
+		renamed.Indexes = append(renamed.Indexes, NewIndexWithColumns(idx.IndexName, cols...))
+	}
+	return renamed
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func applySchema(m *SchemaModel, script string) error {
+	stmts, err := SplitStmts("", script)
+	if err != nil {
+		return err
+	}
+	for _, s := range stmts {
+		stmt, err := ParseOneSQL(s.Text)
+		if err != nil {
+			return err
+		}
+		if err := m.Apply(stmt); err != nil {
+			return fmt.Errorf("%v: %v", s.Pos(), err)
+		}
+	}
+	return nil
+}
+
+func tableIndexes(t TableSchema) string {
+	var indexes []string
+	for _, idx := range t.Indexes {
+		indexes = append(indexes, fmt.Sprintf("%v(%v)", idx.IndexName, strings.Join(idx.KeyParts(), ",")))
+	}
+	return strings.Join(indexes, " ")
+}
+
+func TestSchemaModel(t *testing.T) {
+	m := NewSchemaModel("test")
+	must(applySchema(m, `
+create database db1;
+use DB1;
+create table t1 (a int primary key, b int unique, c int, d int, key (c), key idx_cd (c, d), unique (b, c), key ((c+1)));
+create table t2 like t1;
+alter table t1 add index idx_d (d), drop index c, rename index idx_cd to idx_cd2;
+create index idx_bd on t1 (b, d);
+alter table t1 drop column c, change d e bigint;
+drop index b on db1.t1;
+alter table t1 partition by hash(a) partitions 4;
+rename table t2 to test.t3;
+insert into t1 values (1, 2, 3);
+create view v1 (x, y) as select a, b from t1 where b > 1;
+create view v2 as select * from t1;
+drop view v2;
+`))
+
+	t1, ok := m.Table("db1", "T1")
+	if !ok {
+		t.Fatalf("table db1.t1 not found")
+	}
+	if indexes := tableIndexes(t1); indexes != "primary(a) idx_cd2(e) b_2(b) idx_d(e) idx_bd(b,e)" {
+		t.Fatalf("unexpected indexes of t1: %v", indexes)
+	}
+	if len(t1.Columns) != 3 || t1.Columns[2].ColumnName != "e" || t1.Columns[2].ColumnType.String() != "bigint(20)" {
+		t.Fatalf("unexpected columns of t1: %v", t1.Columns)
+	}
+	if _, ok := m.Table("db1", "t2"); ok {
+		t.Fatalf("table db1.t2 should be renamed")
+	}
+	t3, ok := m.Table("test", "t3")
+	if !ok || t3.Columns[0].SchemaName != "test" || t3.Indexes[0].TableName != "t3" {
+		t.Fatalf("unexpected table test.t3: %+v", t3)
+	}
+	if indexes := tableIndexes(t3); indexes != "primary(a) b(b) c(c) idx_cd(c,d) b_2(b,c) expression_index((`c` + 1))" {
+		t.Fatalf("unexpected indexes of t3: %v", indexes)
+	}
+
+	views := m.Views()
+	if len(views) != 1 {
+		t.Fatalf("expect 1 view, got %v", views)
+	}
+	if v := views[0]; v.Key() != "db1.v1" || strings.Join(v.Columns, ",") != "x,y" ||
+		v.SelectText != "select `a`,`b` from `t1` where `b` > 1" {
+		t.Fatalf("unexpected view: %+v", v)
+	}
+
+	// inconsistent statements
+	for _, sql := range []string{
+		"create table t1 (a int)",
+		"drop table t2",
+		"alter table t1 add index idx_d (a)",
+		"drop index idx_x on t1",
+		"alter table t1 rename column x to y",
+		"rename table t1 to test.t3",
+	} {
+		if err := applySchema(m, sql); err == nil {
+			t.Fatalf("expect an error for %v", sql)
+		}
+	}
+	must(applySchema(m, "create table if not exists t1 (a int); drop table if exists t2; drop database db1"))
+	if len(m.Tables()) != 1 || len(m.Views()) != 0 {
+		t.Fatalf("unexpected tables and views after dropping db1: %v %v", m.Tables(), m.Views())
+	}
+}
+
+func TestParseCreateTableStmtIndexes(t *testing.T) {
+	table, err := ParseCreateTableStmt("test", "create table t (a int, b int, c int, primary key (a, b), key idx_c (c), unique key (b))")
+	must(err)
+	if indexes := tableIndexes(table); indexes != "primary(a,b) idx_c(c) b(b)" {
+		t.Fatalf("unexpected indexes: %v", indexes)
+	}
+	if _, err := ParseCreateTableStmt("test", "create view v as select 1"); err == nil {
+		t.Fatalf("expect an error for create view statements")
+	}
+}
@@ -1,11 +1,14 @@
 package utils
 
 import (
+	"fmt"
 	"github.com/pingcap/parser/format"
+	"regexp"
 	"strings"
 
 	"github.com/pingcap/parser"
 	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/model"
 	"github.com/pingcap/parser/opcode"
 	_ "github.com/pingcap/tidb/types/parser_driver"
 	driver "github.com/pingcap/tidb/types/parser_driver"
@@ -63,10 +66,24 @@ func GetDBNameFromCreateDBStmt(stmt string) string {
 	return db
 }
 
+// memberOfPattern matches predicates like `1 MEMBER OF (tags)`.
+var memberOfPattern = regexp.MustCompile(`(?i)\bmember\s+of\s*\(`)
+
+// UsesMemberOf returns whether the Query text may use `MEMBER OF`, which is not supported by the SQL parser yet, so
+// these queries can't be parsed and are skipped or rejected.
+func UsesMemberOf(sqlText string) bool {
+	return memberOfPattern.MatchString(sqlText)
+}
+
 // ParseOneSQL parses the given Query text and returns the AST.
 func ParseOneSQL(sqlText string) (ast.StmtNode, error) {
 	p := parser.New()
-	return p.ParseOneStmt(sqlText, "", "")
+	stmt, err := p.ParseOneStmt(sqlText, "", "")
+	if err != nil && UsesMemberOf(sqlText) {
+		return nil, fmt.Errorf("%v, MEMBER OF is not supported by the SQL parser yet, "+
+			"please rewrite `1 MEMBER OF (tags)` as `json_contains(tags, '1')`", err)
+	}
+	return stmt, err
 }
 
 // NormalizeDigest normalizes the given Query text and returns the normalized Query text and its digest.
@@ -89,6 +106,54 @@ func NormalizeQueryWithDB(queryText, db string) (normalizedText string, err erro
 	return sb.String(), nil
 }
 
+// IndexExpression returns the expression as the text of an index key part and the only column it refers to, e.g.
+// `lower(u.email)` is "lower(`email`)" on column `email`, ok is false if it refers to no column or more than one column.
+func IndexExpression(expr ast.ExprNode) (text, column string, ok bool, err error) {
+	c := &columnNameCollector{}
+	expr.Accept(c)
+	if len(c.cols) == 0 {
+		return "", "", false, nil
+	}
+	for _, col := range c.cols {
+		if col.Name.L != c.cols[0].Name.L {
+			return "", "", false, nil
+		}
+	}
+
+	// key parts refer to columns without qualifiers
+	qualifiers := make([][2]string, len(c.cols))
+	for i, col := range c.cols {
+		qualifiers[i] = [2]string{col.Schema.O, col.Table.O}
+		col.Schema, col.Table = model.NewCIStr(""), model.NewCIStr("")
+	}
+	defer func() {
+		for i, col := range c.cols {
+			col.Schema, col.Table = model.NewCIStr(qualifiers[i][0]), model.NewCIStr(qualifiers[i][1])
+		}
+	}()
+	var sb strings.Builder
+	ctx := format.NewRestoreCtx(format.RestoreStringSingleQuotes|format.RestoreKeyWordLowercase|format.RestoreNameBackQuotes|format.RestoreSpacesAroundBinaryOperation|format.RestoreStringWithoutCharset, &sb)
+	if err := expr.Restore(ctx); err != nil {
+		return "", "", false, err
+	}
+	return sb.String(), c.cols[0].Name.L, true, nil
+}
+
+type columnNameCollector struct {
+	cols []*ast.ColumnName
+}
+
+func (c *columnNameCollector) Enter(n ast.Node) (out ast.Node, skipChildren bool) {
+	if x, ok := n.(*ast.ColumnName); ok {
+		c.cols = append(c.cols, x)
+	}
+	return n, false
+}
+
+func (c *columnNameCollector) Leave(n ast.Node) (out ast.Node, ok bool) {
+	return n, true
+}
+
 type tableNameCollector struct {
 	defaultSchemaName string
 	tableNames        Set[TableName]
@@ -120,8 +185,8 @@ func (c *tableNameCollector) Leave(n ast.Node) (out ast.Node, ok bool) {
 }
 
 // CollectTableNamesFromSQL returns all referenced table names in the given Query text.
-// The returned format is `schemaName.tableName`.
-// TODO: handle views and CTEs.
+// The returned format is `schemaName.tableName`, views are returned as they are, use ExpandViews to get their base tables.
+// TODO: handle CTEs.
 func CollectTableNamesFromSQL(defaultSchemaName, sqlText string) (Set[TableName], error) {
 	node, err := ParseOneSQL(sqlText)
 	if err != nil {
@@ -293,7 +358,7 @@ func (d *dnfColExtractor) Enter(n ast.Node) (node ast.Node, skipChildren bool) {
 	}
 	switch x := n.(type) {
 	case *ast.SelectStmt:
-		cnf := flattenCNF(x.Where)
+		cnf := FlattenCNF(x.Where)
 		for _, expr := range cnf {
 			dnf := flattenDNF(expr)
 			if len(dnf) <= 1 {
@@ -346,15 +411,16 @@ func flattenColEQConst(expr ast.ExprNode) (*ast.ColumnNameExpr, *driver.ValueExp
 	return nil, nil
 }
 
-func flattenCNF(expr ast.ExprNode) []ast.ExprNode {
+// FlattenCNF splits the expression into conjuncts, e.g. `a=1 and (b=1 and c=1)` to [`a=1`, `b=1`, `c=1`].
+func FlattenCNF(expr ast.ExprNode) []ast.ExprNode {
 	if _, ok := expr.(*ast.ParenthesesExpr); ok {
-		return flattenCNF(expr.(*ast.ParenthesesExpr).Expr)
+		return FlattenCNF(expr.(*ast.ParenthesesExpr).Expr)
 	}
 
 	var cnf []ast.ExprNode
 	if op, ok := expr.(*ast.BinaryOperationExpr); ok && op.Op == opcode.LogicAnd {
-		cnf = append(cnf, flattenCNF(op.L)...)
-		cnf = append(cnf, flattenCNF(op.R)...)
+		cnf = append(cnf, FlattenCNF(op.L)...)
+		cnf = append(cnf, FlattenCNF(op.R)...)
 	} else {
 		cnf = append(cnf, expr)
 	}
@@ -0,0 +1,384 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/format"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/types"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+)
+
+// SQLRenamer renames identifiers and literals in SQL statements.
+// All functions are optional, a nil function or an empty returned name keeps the original one.
+// Tables in system schemas (e.g. `information_schema`) are never renamed.
+type SQLRenamer struct {
+	Schema  func(name string) string  // schemas
+	Table   func(name string) string  // tables, views, CTEs and table aliases
+	Column  func(name string) string  // columns and column aliases
+	Index   func(name string) string  // indexes and constraints
+	Literal func(v *driver.ValueExpr) // rewrites literals in place, see keptFuncArgs, jsonFuncArgs, renamePattern and renameDate for exceptions
+
+	StripComments bool // remove table, column and index comments
+}
+
+// RenameSQL renames the given SQL text and returns the restored SQL text.
+func (r *SQLRenamer) RenameSQL(sqlText string) (string, error) {
+	stmt, err := ParseOneSQL(sqlText)
+	if err != nil {
+		return "", err
+	}
+	return r.RenameStmt(stmt)
+}
+
+// RenameStmt renames the given statement in place and returns the restored SQL text.
+func (r *SQLRenamer) RenameStmt(stmt ast.StmtNode) (string, error) {
+	stmt.Accept(&sqlRenameVisitor{
+		r:        r,
+		kept:     make(map[*driver.ValueExpr]bool),
+		jsons:    make(map[*driver.ValueExpr]bool),
+		dates:    make(map[*driver.ValueExpr]string),
+		patterns: make(map[*driver.ValueExpr]byte),
+	})
+	var sb strings.Builder
+	ctx := format.NewRestoreCtx(format.RestoreStringSingleQuotes|format.RestoreKeyWordLowercase|format.RestoreNameBackQuotes|format.RestoreSpacesAroundBinaryOperation|format.RestoreStringWithoutCharset, &sb)
+	if err := stmt.Restore(ctx); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+func (r *SQLRenamer) rename(f func(string) string, name string) string {
+	if f == nil || name == "" {
+		return name
+	}
+	if newName := f(name); newName != "" {
+		return newName
+	}
+	return name
+}
+
+func (r *SQLRenamer) renameCIStr(f func(string) string, name model.CIStr) model.CIStr {
+	if f == nil || name.O == "" {
+		return name
+	}
+	return model.NewCIStr(r.rename(f, name.O))
+}
+
+func (r *SQLRenamer) renameCIStrs(f func(string) string, names []model.CIStr) {
+	for i := range names {
+		names[i] = r.renameCIStr(f, names[i])
+	}
+}
+
+// renamePattern rewrites the LIKE pattern segment by segment through Literal and keeps the wildcards, so that
+// `like 'abc%'` becomes `like 's1%'` if 'abc' becomes 's1'. Escaped wildcards are part of the segments.
+func (r *SQLRenamer) renamePattern(pattern *driver.ValueExpr, escape byte) {
+	str := pattern.GetString()
+	var renamed, segment strings.Builder
+	flush := func() {
+		if segment.Len() == 0 {
+			return
+		}
+		v := ast.NewValueExpr(segment.String(), pattern.Type.Charset, pattern.Collation()).(*driver.ValueExpr)
+		r.Literal(v)
+		renamed.WriteString(v.GetString())
+		segment.Reset()
+	}
+	for i := 0; i < len(str); i++ {
+		switch {
+		case str[i] == escape && i+1 < len(str):
+			segment.WriteByte(str[i])
+			segment.WriteByte(str[i+1])
+			i++
+		case str[i] == '%' || str[i] == '_':
+			flush()
+			renamed.WriteByte(str[i])
+		default:
+			segment.WriteByte(str[i])
+		}
+	}
+	flush()
+	pattern.SetString(renamed.String(), pattern.Collation())
+}
+
+// renameJSON rewrites scalars in the JSON document one by one through Literal and keeps its structure, so that
+// `'["alice", 1]'` becomes `'["s1", 2]'` if 'alice' becomes 's1' and 1 becomes 2. Numbers are passed as numbers and
+// strings as strings, object keys, booleans and nulls are kept. It returns false if the literal isn't a JSON document.
+func (r *SQLRenamer) renameJSON(doc *driver.ValueExpr) bool {
+	if doc.Kind() != types.KindString || !json.Valid([]byte(doc.GetString())) {
+		return false
+	}
+	dec := json.NewDecoder(strings.NewReader(doc.GetString()))
+	dec.UseNumber()
+	var val interface{}
+	if err := dec.Decode(&val); err != nil {
+		return false
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(r.renameJSONValue(val, doc)); err != nil {
+		return false
+	}
+	doc.SetString(strings.TrimSuffix(buf.String(), "\n"), doc.Collation())
+	return true
+}
+
+func (r *SQLRenamer) renameJSONValue(val interface{}, doc *driver.ValueExpr) interface{} {
+	switch x := val.(type) {
+	case map[string]interface{}:
+		for k, item := range x {
+			x[k] = r.renameJSONValue(item, doc)
+		}
+	case []interface{}:
+		for i, item := range x {
+			x[i] = r.renameJSONValue(item, doc)
+		}
+	case string:
+		v := ast.NewValueExpr(x, doc.Type.Charset, doc.Collation()).(*driver.ValueExpr)
+		r.Literal(v)
+		return v.GetString()
+	case json.Number:
+		var v *driver.ValueExpr
+		if i, err := x.Int64(); err == nil {
+			v = ast.NewValueExpr(i, "", "").(*driver.ValueExpr)
+		} else if f, err := x.Float64(); err == nil {
+			v = ast.NewValueExpr(f, "", "").(*driver.ValueExpr)
+		} else {
+			return x
+		}
+		r.Literal(v)
+		if str, err := v.ToString(); err == nil {
+			return json.Number(str)
+		}
+		return x
+	}
+	return val
+}
+
+// renameDate rewrites the date string of `str_to_date` through Literal in the standard format and restores it in the
+// kept format, so that `str_to_date('2023/01/02', '%Y/%m/%d')` becomes `str_to_date('2000/01/02', '%Y/%m/%d')` if
+// '2023-01-02' becomes '2000-01-02'. It returns false if the literal doesn't match the format.
+func (r *SQLRenamer) renameDate(date *driver.ValueExpr, format string) bool {
+	if date.Kind() != types.KindString {
+		return false
+	}
+	sc := &stmtctx.StatementContext{TimeZone: time.UTC}
+	var t types.Time
+	if !t.StrToDate(sc, date.GetString(), format) {
+		return false
+	}
+	if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Microsecond() == 0 {
+		t.SetType(mysql.TypeDate)
+	}
+	v := ast.NewValueExpr(t.String(), date.Type.Charset, date.Collation()).(*driver.ValueExpr)
+	r.Literal(v)
+	renamed := v.GetString()
+	if t, err := types.ParseDatetime(sc, renamed); err == nil {
+		if str, err := t.DateFormat(format); err == nil {
+			renamed = str
+		}
+	}
+	date.SetString(renamed, date.Collation())
+	return true
+}
+
+// keptFuncArgs are positions of function arguments which are JSON paths or format strings rather than data, literals
+// at these positions are kept since rewriting them breaks the statement or changes its meaning. `doc->'$.k'` and
+// `doc->>'$.k'` are parsed as json_extract.
+var keptFuncArgs = map[string]func(i int) bool{
+	ast.JSONExtract:      func(i int) bool { return i >= 1 },
+	ast.JSONRemove:       func(i int) bool { return i >= 1 },
+	ast.JSONKeys:         func(i int) bool { return i >= 1 },
+	ast.JSONLength:       func(i int) bool { return i >= 1 },
+	ast.JSONContainsPath: func(i int) bool { return i >= 1 }, // 'one' or 'all', and paths
+	ast.JSONContains:     func(i int) bool { return i >= 2 },
+	ast.JSONSearch:       func(i int) bool { return i == 1 || i >= 3 }, // 'one' or 'all', the escape char and paths
+	ast.JSONSet:          func(i int) bool { return i%2 == 1 },         // paths followed by values
+	ast.JSONInsert:       func(i int) bool { return i%2 == 1 },
+	ast.JSONReplace:      func(i int) bool { return i%2 == 1 },
+	ast.JSONArrayAppend:  func(i int) bool { return i%2 == 1 },
+	ast.JSONArrayInsert:  func(i int) bool { return i%2 == 1 },
+	ast.DateFormat:       func(i int) bool { return i == 1 },
+	ast.TimeFormat:       func(i int) bool { return i == 1 },
+	ast.StrToDate:        func(i int) bool { return i == 1 },
+	ast.FromUnixTime:     func(i int) bool { return i == 1 },
+	ast.GetFormat:        func(i int) bool { return true },
+}
+
+// jsonFuncArgs are positions of function arguments which are JSON documents, literals at these positions are rewritten
+// by renameJSON to keep them valid. json_overlaps has no constant in the parser.
+var jsonFuncArgs = map[string]func(i int) bool{
+	ast.JSONContains:    func(i int) bool { return i == 1 },
+	"json_overlaps":     func(i int) bool { return i <= 1 },
+	ast.JSONSet:         func(i int) bool { return i >= 2 && i%2 == 0 }, // values after paths
+	ast.JSONInsert:      func(i int) bool { return i >= 2 && i%2 == 0 },
+	ast.JSONReplace:     func(i int) bool { return i >= 2 && i%2 == 0 },
+	ast.JSONArrayAppend: func(i int) bool { return i >= 2 && i%2 == 0 },
+	ast.JSONArrayInsert: func(i int) bool { return i >= 2 && i%2 == 0 },
+}
+
+type sqlRenameVisitor struct {
+	r          *SQLRenamer
+	underLimit int
+
+	kept     map[*driver.ValueExpr]bool   // literals which are not passed to Literal
+	jsons    map[*driver.ValueExpr]bool   // literals which may be JSON documents
+	dates    map[*driver.ValueExpr]string // date strings of str_to_date -> their formats
+	patterns map[*driver.ValueExpr]byte   // LIKE patterns -> their escape chars
+}
+
+func (v *sqlRenameVisitor) Enter(n ast.Node) (out ast.Node, skipChildren bool) {
+	r := v.r
+	switch x := n.(type) {
+	case *ast.CreateDatabaseStmt:
+		x.Name = r.rename(r.Schema, x.Name)
+	case *ast.AlterDatabaseStmt:
+		x.Name = r.rename(r.Schema, x.Name)
+	case *ast.DropDatabaseStmt:
+		x.Name = r.rename(r.Schema, x.Name)
+	case *ast.UseStmt:
+		x.DBName = r.rename(r.Schema, x.DBName)
+	case *ast.TableName:
+		if IsTiDBSystemTableName(TableName{SchemaName: x.Schema.O}) {
+			return n, true
+		}
+		x.Schema = r.renameCIStr(r.Schema, x.Schema)
+		x.Name = r.renameCIStr(r.Table, x.Name)
+		for _, hint := range x.IndexHints {
+			r.renameCIStrs(r.Index, hint.IndexNames)
+		}
+	case *ast.TableSource:
+		x.AsName = r.renameCIStr(r.Table, x.AsName)
+	case *ast.WithClause:
+		for _, cte := range x.CTEs {
+			cte.Name = r.renameCIStr(r.Table, cte.Name)
+			r.renameCIStrs(r.Column, cte.ColNameList)
+		}
+	case *ast.ColumnName:
+		if IsTiDBSystemTableName(TableName{SchemaName: x.Schema.O}) {
+			return n, true
+		}
+		x.Schema = r.renameCIStr(r.Schema, x.Schema)
+		x.Table = r.renameCIStr(r.Table, x.Table)
+		x.Name = r.renameCIStr(r.Column, x.Name)
+	case *ast.SelectField:
+		x.AsName = r.renameCIStr(r.Column, x.AsName)
+		if x.WildCard != nil {
+			x.WildCard.Schema = r.renameCIStr(r.Schema, x.WildCard.Schema)
+			x.WildCard.Table = r.renameCIStr(r.Table, x.WildCard.Table)
+		}
+	case *ast.TableOptimizerHint:
+		for i := range x.Tables {
+			x.Tables[i].DBName = r.renameCIStr(r.Schema, x.Tables[i].DBName)
+			x.Tables[i].TableName = r.renameCIStr(r.Table, x.Tables[i].TableName)
+		}
+		r.renameCIStrs(r.Index, x.Indexes)
+	case *ast.CreateViewStmt:
+		r.renameCIStrs(r.Column, x.Cols)
+	case *ast.CreateTableStmt:
+		if r.StripComments {
+			var options []*ast.TableOption
+			for _, opt := range x.Options {
+				if opt.Tp != ast.TableOptionComment {
+					options = append(options, opt)
+				}
+			}
+			x.Options = options
+		}
+	case *ast.ColumnDef:
+		if r.StripComments {
+			var options []*ast.ColumnOption
+			for _, opt := range x.Options {
+				if opt.Tp != ast.ColumnOptionComment {
+					options = append(options, opt)
+				}
+			}
+			x.Options = options
+		}
+	case *ast.Constraint:
+		if !strings.EqualFold(x.Name, "primary") {
+			x.Name = r.rename(r.Index, x.Name)
+		}
+		if r.StripComments && x.Option != nil {
+			x.Option.Comment = ""
+		}
+	case *ast.CreateIndexStmt:
+		x.IndexName = r.rename(r.Index, x.IndexName)
+		if r.StripComments && x.IndexOption != nil {
+			x.IndexOption.Comment = ""
+		}
+	case *ast.DropIndexStmt:
+		x.IndexName = r.rename(r.Index, x.IndexName)
+	case *ast.AlterTableSpec:
+		switch x.Tp {
+		case ast.AlterTableDropIndex, ast.AlterTableDropForeignKey:
+			x.Name = r.rename(r.Index, x.Name)
+		case ast.AlterTableRenameIndex:
+			x.FromKey = r.renameCIStr(r.Index, x.FromKey)
+			x.ToKey = r.renameCIStr(r.Index, x.ToKey)
+		case ast.AlterTableIndexInvisible:
+			x.IndexName = r.renameCIStr(r.Index, x.IndexName)
+		case ast.AlterTableRenameColumn:
+			// NewColumnName is not visited by AlterTableSpec.Accept
+			x.NewColumnName.Name = r.renameCIStr(r.Column, x.NewColumnName.Name)
+		}
+	case *ast.Limit:
+		v.underLimit++
+	case *ast.FuncCallExpr:
+		if kept, ok := keptFuncArgs[x.FnName.L]; ok {
+			for i, arg := range x.Args {
+				if literal, ok := arg.(*driver.ValueExpr); ok && kept(i) {
+					v.kept[literal] = true
+				}
+			}
+		}
+		if isJSON, ok := jsonFuncArgs[x.FnName.L]; ok {
+			for i, arg := range x.Args {
+				if literal, ok := arg.(*driver.ValueExpr); ok && isJSON(i) {
+					v.jsons[literal] = true
+				}
+			}
+		}
+		if x.FnName.L == ast.StrToDate && len(x.Args) == 2 {
+			date, isDate := x.Args[0].(*driver.ValueExpr)
+			format, isFormat := x.Args[1].(*driver.ValueExpr)
+			if isDate && isFormat && format.Kind() == types.KindString {
+				v.dates[date] = format.GetString()
+			}
+		}
+	case *ast.PatternLikeExpr:
+		if pattern, ok := x.Pattern.(*driver.ValueExpr); ok && pattern.Kind() == types.KindString {
+			v.patterns[pattern] = x.Escape
+		}
+	case *driver.ValueExpr:
+		if r.Literal == nil || v.underLimit > 0 || v.kept[x] {
+			break
+		}
+		escape, isPattern := v.patterns[x]
+		format, isDate := v.dates[x]
+		switch {
+		case isPattern:
+			r.renamePattern(x, escape)
+		case v.jsons[x] && r.renameJSON(x):
+		case isDate && r.renameDate(x, format):
+		default:
+			r.Literal(x)
+		}
+	}
+	return n, false
+}
+
+func (v *sqlRenameVisitor) Leave(n ast.Node) (out ast.Node, ok bool) {
+	if _, isLimit := n.(*ast.Limit); isLimit {
+		v.underLimit--
+	}
+	return n, true
+}
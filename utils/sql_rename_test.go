@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/types"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+)
+
+func TestSQLRenamer(t *testing.T) {
+	mapper := func(prefix string, m map[string]string) func(string) string {
+		return func(name string) string {
+			name = strings.ToLower(name)
+			if _, ok := m[name]; !ok {
+				m[name] = fmt.Sprintf("%v%v", prefix, len(m)+1)
+			}
+			return m[name]
+		}
+	}
+	schemas, tables, columns, indexes := map[string]string{}, map[string]string{}, map[string]string{}, map[string]string{}
+	r := &SQLRenamer{
+		Schema: mapper("db", schemas),
+		Table:  mapper("t", tables),
+		Column: mapper("c", columns),
+		Index:  mapper("idx", indexes),
+		Literal: func(v *driver.ValueExpr) {
+			if v.Kind() == types.KindString {
+				v.SetString("s", v.Collation())
+			}
+		},
+		StripComments: true,
+	}
+
+	cases := []struct {
+		sql    string
+		expect string
+	}{
+		{"use tpch",
+			"use `db1`"},
+		{"create table orders (o_id int, o_name varchar(64) comment 'name', primary key (o_id), key idx_name (o_name)) comment='orders'",
+			"create table `t1` (`c1` int,`c2` varchar(64),primary key(`c1`),index `idx1`(`c2`))"},
+		{"create index idx_id_name on tpch.orders (o_id, o_name)",
+			"create index `idx2` on `db1`.`t1` (`c1`, `c2`)"},
+		{"select o.o_id as id, count(*) from tpch.orders o use index (idx_name) where o.o_name = 'alice' group by id order by id limit 10",
+			"select `t2`.`c1` as `c3`,count(1) from `db1`.`t1` as `t2` use index (`idx1`) where `t2`.`c2` = 's' group by `c3` order by `c3` limit 10"},
+		{"with cte as (select o_id from orders) select * from cte, information_schema.tables where cte.o_id = 1",
+			"with `t3` as (select `c1` from `t1`) select * from (`t3`) join `information_schema`.`tables` where `t3`.`c1` = 1"},
+	}
+	for _, c := range cases {
+		got, err := r.RenameSQL(c.sql)
+		must(err)
+		if got != c.expect {
+			t.Errorf("rename %v\nexpect %v\ngot    %v", c.sql, c.expect, got)
+		}
+	}
+}
+
+func TestSQLRenamerLiterals(t *testing.T) {
+	literals, dates := map[string]string{}, map[string]string{}
+	r := &SQLRenamer{
+		Literal: func(v *driver.ValueExpr) {
+			switch {
+			case v.Kind() != types.KindString:
+				v.SetInt64(0)
+			case isDateString(v.GetString(), "2006-01-02"):
+				if _, ok := dates[v.GetString()]; !ok {
+					dates[v.GetString()] = fmt.Sprintf("2000-01-%02d", len(dates)+1)
+				}
+				v.SetString(dates[v.GetString()], v.Collation())
+			case isDateString(v.GetString(), "2006-01-02 15:04:05"):
+				if _, ok := dates[v.GetString()]; !ok {
+					dates[v.GetString()] = fmt.Sprintf("2000-01-%02d 12:00:00", len(dates)+1)
+				}
+				v.SetString(dates[v.GetString()], v.Collation())
+			default:
+				if _, ok := literals[v.GetString()]; !ok {
+					literals[v.GetString()] = fmt.Sprintf("s%v", len(literals)+1)
+				}
+				v.SetString(literals[v.GetString()], v.Collation())
+			}
+		},
+	}
+
+	cases := []struct {
+		sql    string
+		expect string
+	}{
+		{"select json_extract(doc, '$.name') from t where json_extract(doc, '$.k') = 'alice'",
+			"select json_extract(`doc`, '$.name') from `t` where json_extract(`doc`, '$.k') = 's1'"},
+		{"select * from t where doc->'$.k' = 'alice' and doc->>'$.v' = 'bob'",
+			"select * from `t` where json_extract(`doc`, '$.k') = 's1' and json_unquote(json_extract(`doc`, '$.v')) = 's2'"},
+		{"select * from t where json_contains(doc, '\"alice\"', '$.names') and json_set(doc, '$.a', 'bob') is not null",
+			"select * from `t` where json_contains(`doc`, '\"s1\"', '$.names') and json_set(`doc`, '$.a', 's2') is not null"},
+		{"select * from t where json_overlaps(tags, '[\"alice\", 1.5, {\"k\": \"bob\", \"n\": null}]') and json_set(doc, '$.a', '{\"x\": 7}') is not null",
+			"select * from `t` where json_overlaps(`tags`, '[\"s1\",0,{\"k\":\"s2\",\"n\":null}]') and json_set(`doc`, '$.a', '{\"x\":0}') is not null"},
+		{"select * from t where name like 'alice%' and title like '%bob_x%' and code not like 'a\\_b%'",
+			"select * from `t` where `name` like 's1%' and `title` like '%s2_s3%' and `code` not like 's4%'"},
+		{"select * from t where name like 'a|%b%' escape '|'",
+			"select * from `t` where `name` like 's5%' escape '|'"},
+		{"select date_format(d, '%Y-%m'), str_to_date('2023/01/02', '%Y/%m/%d') from t where time_format(d, '%H') = '10'",
+			"select date_format(`d`, '%Y-%m'),str_to_date('2000/01/01', '%Y/%m/%d') from `t` where time_format(`d`, '%H') = 's6'"},
+		{"select * from t where d = '2023-01-02' or d = str_to_date('2023-01-02 10:30', '%Y-%m-%d %H:%i')",
+			"select * from `t` where `d` = '2000-01-01' or `d` = str_to_date('2000-01-02 12:00', '%Y-%m-%d %H:%i')"},
+	}
+	for _, c := range cases {
+		got, err := r.RenameSQL(c.sql)
+		must(err)
+		if got != c.expect {
+			t.Errorf("rename %v\nexpect %v\ngot    %v", c.sql, c.expect, got)
+		}
+	}
+}
+
+func isDateString(str, layout string) bool {
+	_, err := time.Parse(layout, str)
+	return err == nil
+}
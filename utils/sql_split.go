@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Stmt is a statement in a SQL script.
+type Stmt struct {
+	Text string // the statement without the delimiter and comments, executable comments and hints are kept
+	File string // the script file path, empty if unknown
+	Line int    // the line where the statement starts, 1-based
+}
+
+// Pos returns the position of the statement, e.g. 'schema.sql:12'.
+func (s Stmt) Pos() string {
+	if s.File == "" {
+		return fmt.Sprintf("line %v", s.Line)
+	}
+	return fmt.Sprintf("%v:%v", s.File, s.Line)
+}
+
+// LoadStmtsFromFile splits the SQL script file into statements, see SplitStmts.
+func LoadStmtsFromFile(fpath string) ([]Stmt, error) {
+	data, err := os.ReadFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+	return SplitStmts(fpath, string(data))
+}
+
+// SplitStmts splits the SQL script into statements like the MySQL client:
+//   - delimiters in single quotes, double quotes, backticks and comments are ignored;
+//   - comments (`-- `, `#` and `/* */`) are removed, while executable comments (`/*! */`, `/*T! */`) and optimizer
+//     hints (`/*+ */`) are kept since they are part of the statement;
+//   - `DELIMITER xx` changes the delimiter for following statements.
+func SplitStmts(file, script string) ([]Stmt, error) {
+	s := &stmtSplitter{file: file, script: script, line: 1, delimiter: ";"}
+	return s.split()
+}
+
+type stmtSplitter struct {
+	file      string
+	script    string
+	pos       int
+	line      int
+	delimiter string
+
+	stmts     []Stmt
+	current   strings.Builder
+	startLine int // the line where the current statement starts, 0 if the current statement is empty
+}
+
+func (s *stmtSplitter) split() ([]Stmt, error) {
+	for s.pos < len(s.script) {
+		c := s.script[s.pos]
+		switch {
+		case s.startLine == 0 && s.hasDelimiterCommand():
+			s.readDelimiterCommand()
+		case strings.HasPrefix(s.script[s.pos:], s.delimiter):
+			s.pos += len(s.delimiter)
+			s.finishStmt()
+		case c == '\'' || c == '"' || c == '`':
+			if err := s.readQuoted(c); err != nil {
+				return nil, err
+			}
+		case c == '#' || (strings.HasPrefix(s.script[s.pos:], "--") && (s.pos+2 == len(s.script) || isSpace(s.script[s.pos+2]))):
+			for s.pos < len(s.script) && s.script[s.pos] != '\n' {
+				s.pos++
+			}
+		case strings.HasPrefix(s.script[s.pos:], "/*"):
+			if err := s.readBlockComment(); err != nil {
+				return nil, err
+			}
+		default:
+			s.write(s.script[s.pos : s.pos+1])
+			s.pos++
+		}
+	}
+	s.finishStmt()
+	return s.stmts, nil
+}
+
+// write appends the text to the current statement, and records where the statement starts.
+func (s *stmtSplitter) write(text string) {
+	if s.startLine == 0 && strings.TrimSpace(text) == "" {
+		s.line += strings.Count(text, "\n")
+		return
+	}
+	if s.startLine == 0 {
+		s.startLine = s.line
+	}
+	s.current.WriteString(text)
+	s.line += strings.Count(text, "\n")
+}
+
+func (s *stmtSplitter) finishStmt() {
+	if text := strings.TrimSpace(s.current.String()); text != "" {
+		s.stmts = append(s.stmts, Stmt{Text: text, File: s.file, Line: s.startLine})
+	}
+	s.current.Reset()
+	s.startLine = 0
+}
+
+func (s *stmtSplitter) readQuoted(quote byte) error {
+	start, startLine := s.pos, s.line
+	s.pos++
+	for s.pos < len(s.script) {
+		c := s.script[s.pos]
+		if c == '\\' && quote != '`' {
+			s.pos += 2
+			continue
+		}
+		s.pos++
+		if c == quote {
+			s.write(s.script[start:s.pos])
+			return nil
+		}
+	}
+	return fmt.Errorf("%v: unterminated quoted string", Stmt{File: s.file, Line: startLine}.Pos())
+}
+
+func (s *stmtSplitter) readBlockComment() error {
+	start, startLine := s.pos, s.line
+	end := strings.Index(s.script[s.pos+2:], "*/")
+	if end == -1 {
+		return fmt.Errorf("%v: unterminated comment", Stmt{File: s.file, Line: startLine}.Pos())
+	}
+	s.pos += 2 + end + 2
+	comment := s.script[start:s.pos]
+	if strings.HasPrefix(comment, "/*!") || strings.HasPrefix(comment, "/*T!") || strings.HasPrefix(comment, "/*+") {
+		s.write(comment)
+	} else {
+		s.write(" ")
+		s.line += strings.Count(comment, "\n")
+	}
+	return nil
+}
+
+// hasDelimiterCommand returns whether a `DELIMITER xx` command is at the current position.
+func (s *stmtSplitter) hasDelimiterCommand() bool {
+	const cmd = "delimiter"
+	rest := s.script[s.pos:]
+	return len(rest) > len(cmd) && strings.EqualFold(rest[:len(cmd)], cmd) && isSpace(rest[len(cmd)]) &&
+		(s.pos == 0 || isSpace(s.script[s.pos-1]))
+}
+
+func (s *stmtSplitter) readDelimiterCommand() {
+	end := strings.IndexByte(s.script[s.pos:], '\n')
+	if end == -1 {
+		end = len(s.script) - s.pos
+	}
+	fields := strings.Fields(s.script[s.pos : s.pos+end])
+	if len(fields) > 1 {
+		s.delimiter = fields[1]
+	}
+	s.pos += end
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitStmts(t *testing.T) {
+	script := `-- a comment; with a semicolon
+create table t (a varchar(10) default 'x;y', b int comment "it's; ok"); # another comment;
+/* a block comment;
+   over lines */ select * from t where a = 'a\';b' and b--1 > 0;
+select /*+ use_index(t, a) */ * from ` + "`t;`" + `;
+create table t2 (a int) /*T! SHARD_ROW_ID_BITS=4 */;
+DELIMITER //
+create procedure p() begin select 1; select 2; end//
+delimiter ;
+select 1 -- the last one`
+
+	stmts, err := SplitStmts("test.sql", script)
+	must(err)
+	expected := []Stmt{
+		{"create table t (a varchar(10) default 'x;y', b int comment \"it's; ok\")", "test.sql", 2},
+		{"select * from t where a = 'a\\';b' and b--1 > 0", "test.sql", 4},
+		{"select /*+ use_index(t, a) */ * from `t;`", "test.sql", 5},
+		{"create table t2 (a int) /*T! SHARD_ROW_ID_BITS=4 */", "test.sql", 6},
+		{"create procedure p() begin select 1; select 2; end", "test.sql", 8},
+		{"select 1", "test.sql", 10},
+	}
+	if len(stmts) != len(expected) {
+		t.Fatalf("expect %v statements, got %v: %v", len(expected), len(stmts), stmts)
+	}
+	for i := range expected {
+		if stmts[i] != expected[i] {
+			t.Fatalf("expect %+v, got %+v", expected[i], stmts[i])
+		}
+	}
+
+	for _, c := range []struct {
+		script string
+		err    string
+	}{
+		{"select 1;\nselect 'abc;", "test.sql:2: unterminated quoted string"},
+		{"select 1;\n\n/* select 2;", "test.sql:3: unterminated comment"},
+	} {
+		_, err := SplitStmts("test.sql", c.script)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("expect error %v, got %v", c.err, err)
+		}
+	}
+}
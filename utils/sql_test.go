@@ -167,3 +167,31 @@ func must(err error) {
 		panic(err)
 	}
 }
+
+func TestParseExpressionIndex(t *testing.T) {
+	index, err := ParseCreateIndexStmt("create index idx on test.t (a, (lower(t.email)), (cast(json_extract(doc, '$.k') as char(32))))")
+	must(err)
+	if ddl := index.DDL(); ddl != "CREATE INDEX idx ON test.t (a, (lower(`email`)), (cast(json_extract(`doc`, '$.k') as char(32))))" {
+		t.Fatalf("unexpected DDL: %v", ddl)
+	}
+	if cols := index.ColumnNames(); strings.Join(cols, ",") != "a,email,doc" {
+		t.Fatalf("unexpected columns: %v", cols)
+	}
+	if !index.PrefixContain(NewIndexWithColumns("idx2", index.Columns[:2]...)) || index.PrefixContain(NewIndex("test", "t", "idx3", "a", "email")) {
+		t.Fatalf("unexpected prefixes of %v", index.Key())
+	}
+	if _, err := ParseCreateIndexStmt("create index idx on test.t ((a + b))"); err == nil {
+		t.Fatalf("expressions on more than one column are unexpected")
+	}
+}
+
+func TestParsePrefixIndex(t *testing.T) {
+	index, err := ParseCreateIndexStmt("create index idx on test.t (url(32), a)")
+	must(err)
+	if index.Key() != "test.t(url(32),a)" || index.Columns[0].IsPlain() || !index.Columns[1].IsPlain() {
+		t.Fatalf("unexpected index: %v", index.Key())
+	}
+	if index.PrefixContain(NewIndex("test", "t", "idx2", "url")) {
+		t.Fatalf("a prefix key part doesn't contain the full column")
+	}
+}
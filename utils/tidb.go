@@ -1,54 +1,171 @@
 package utils
 
 import (
-	"bytes"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"os/exec"
 	"path"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
+// LocalTiDBServerOptions configures how to start a local TiDB server.
+type LocalTiDBServerOptions struct {
+	Version      string        // the TiDB version to start through TiUP, e.g. 'nightly', 'v7.3.0'
+	BinaryPath   string        // start the TiDB server from this tidb-server binary on unistore instead of TiUP if not empty
+	StartTimeout time.Duration // how long to wait for the TiDB server to be ready, 0 means DefaultTiDBStartTimeout
+	LogDir       string        // copy the stdout, stderr and logs of the TiDB server into this directory when releasing it
+	Reuse        bool          // reuse the server cached by previous processes, and keep it running after releasing it
+}
+
+// DefaultTiDBStartTimeout is the default timeout of starting a local TiDB server.
+const DefaultTiDBStartTimeout = 2 * time.Minute
+
+// IsolatedSchemaPrefix is the prefix of databases created by this tool to load workloads into a shared TiDB server.
+const IsolatedSchemaPrefix = "index_advisor_"
+
+// nightlyTiDBTTL is how long a cached nightly TiDB server can be reused before starting a newer one.
+const nightlyTiDBTTL = 24 * time.Hour
+
+// LocalTiDBServer is a TiDB server process started by this tool.
 type LocalTiDBServer struct {
-	cmd    *exec.Cmd
-	port   int
-	tmpDir string
+	Pid     int       `json:"pid"`
+	Port    int       `json:"port"`
+	TmpDir  string    `json:"tmp_dir"`
+	Version string    `json:"version"` // the version reported by `select tidb_version()`
+	Started time.Time `json:"started"`
+
+	exited     chan struct{} // closed when the process exits, nil if the process is not a child of this process
+	logDir     string
+	lockFile   *os.File // the lock of the cached server, nil if not reused
+	unregister func()
+	release    sync.Once
 }
 
-func (s *LocalTiDBServer) Release() error {
-	Infof("Kill TiDB process pid: %v", s.cmd.Process.Pid)
-	err := syscall.Kill(s.cmd.Process.Pid, syscall.SIGQUIT)
-	if err != nil {
-		return err
-	}
+// Release stops the TiDB server and removes its temporary directory, it's safe to call it more than once.
+// A reused server is kept running for the next process, and only the lock of it is released.
+func (s *LocalTiDBServer) Release() (err error) {
+	s.release.Do(func() {
+		if s.unregister != nil {
+			s.unregister()
+		}
+		s.saveLogs()
+		if s.lockFile != nil {
+			Infof("release the cached TiDB server, pid: %v", s.Pid)
+			syscall.Flock(int(s.lockFile.Fd()), syscall.LOCK_UN)
+			err = s.lockFile.Close()
+			return
+		}
+		s.kill()
+		Infof("Clean tmpDir: %v", s.TmpDir)
+		err = os.RemoveAll(s.TmpDir)
+	})
+	return err
+}
 
+// kill kills the process group of the TiDB server, which includes the TiDB process started by TiUP.
+func (s *LocalTiDBServer) kill() {
+	Infof("Kill TiDB process pid: %v", s.Pid)
+	if err := syscall.Kill(-s.Pid, syscall.SIGTERM); err != nil {
+		Warningf("failed to kill TiDB process group %v: %v", s.Pid, err)
+		return
+	}
 	Infof("wait for TiDB to close")
-	time.Sleep(time.Second * 3)
+	deadline := time.After(10 * time.Second)
+	for {
+		select {
+		case <-s.exited:
+			return
+		case <-deadline:
+			Warningf("TiDB process %v doesn't exit in 10s, kill it forcibly", s.Pid)
+			syscall.Kill(-s.Pid, syscall.SIGKILL)
+			return
+		case <-time.After(100 * time.Millisecond):
+			if s.exited == nil && syscall.Kill(s.Pid, 0) != nil { // not a child of this process
+				return
+			}
+		}
+	}
+}
 
-	Infof("Clean tmpDir: %v", s.tmpDir)
-	os.RemoveAll(s.tmpDir)
-	return nil
+// saveLogs copies the stdout, stderr and logs of the TiDB server into the log directory.
+func (s *LocalTiDBServer) saveLogs() {
+	if s.logDir == "" {
+		return
+	}
+	if err := os.MkdirAll(s.logDir, 0755); err != nil {
+		Warningf("failed to create TiDB log directory %v: %v", s.logDir, err)
+		return
+	}
+	for _, name := range []string{"stdout.log", "stderr.log", "tidb.log", "tidb_slow.log"} {
+		if err := copyFile(path.Join(s.TmpDir, name), path.Join(s.logDir, name)); err != nil && !os.IsNotExist(err) {
+			Warningf("failed to save TiDB log %v: %v", name, err)
+		}
+	}
+	Infof("save TiDB logs into %v", s.logDir)
 }
 
 func (s *LocalTiDBServer) DSN() string {
-	return fmt.Sprintf("root:@tcp(127.0.0.1:%v)/", s.port)
+	return fmt.Sprintf("root:@tcp(127.0.0.1:%v)/", s.Port)
 }
 
 // StartLocalTiDBServer starts a TiDB server with the given version.
 func StartLocalTiDBServer(ver string) (*LocalTiDBServer, error) {
-	if ver == "" {
-		ver = "nightly"
+	return StartLocalTiDBServerWithOptions(LocalTiDBServerOptions{Version: ver})
+}
+
+// StartLocalTiDBServerWithOptions starts a TiDB server with the given options.
+func StartLocalTiDBServerWithOptions(opt LocalTiDBServerOptions) (*LocalTiDBServer, error) {
+	if opt.Version == "" {
+		opt.Version = "nightly"
+	}
+	if opt.StartTimeout <= 0 {
+		opt.StartTimeout = DefaultTiDBStartTimeout
+	}
+	var newCmd func(port, statusPort int, tmpDir string) *exec.Cmd
+	if opt.BinaryPath != "" {
+		if exist, isDir := FileExists(opt.BinaryPath); !exist || isDir {
+			return nil, fmt.Errorf("TiDB binary %v does not exist", opt.BinaryPath)
+		}
+		newCmd = func(port, statusPort int, tmpDir string) *exec.Cmd {
+			return exec.Command(opt.BinaryPath, "--store=unistore", fmt.Sprintf("--status=%v", statusPort),
+				fmt.Sprintf("-P=%v", port), fmt.Sprintf("--path=%v", tmpDir),
+				fmt.Sprintf("--log-file=%v", path.Join(tmpDir, "tidb.log")),
+				fmt.Sprintf("--log-slow-query=%v", path.Join(tmpDir, "tidb_slow.log")))
+		}
+	} else {
+		tiupPath, err := exec.LookPath("tiup")
+		if err != nil {
+			return nil, fmt.Errorf("failed to find tiup cmd: %v, please install tiup first: https://docs.pingcap.com/tidb/dev/tiup-overview", err)
+		}
+		newCmd = func(port, statusPort int, tmpDir string) *exec.Cmd {
+			return exec.Command(tiupPath, fmt.Sprintf("tidb:%v", opt.Version),
+				fmt.Sprintf("--status=%v", statusPort), fmt.Sprintf("-P=%v", port), fmt.Sprintf("--path=%v", tmpDir),
+				fmt.Sprintf("--log-file=%v", path.Join(tmpDir, "tidb.log")),
+				fmt.Sprintf("--log-slow-query=%v", path.Join(tmpDir, "tidb_slow.log")))
+		}
+	}
+
+	if opt.Reuse {
+		return startCachedTiDBServer(opt, newCmd)
 	}
-	tiupPath, err := exec.LookPath("tiup")
+	s, err := startLocalTiDBServer(opt, newCmd)
 	if err != nil {
-		return nil, fmt.Errorf("failed to find tiup cmd: %v, please install tiup first: https://docs.pingcap.com/tidb/dev/tiup-overview", err)
+		return nil, err
 	}
+	s.unregister = RegisterCleanup(func() { s.Release() })
+	return s, nil
+}
 
+func startLocalTiDBServer(opt LocalTiDBServerOptions, newCmd func(port, statusPort int, tmpDir string) *exec.Cmd) (*LocalTiDBServer, error) {
 	port, err := GetFreePort()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get a free port: %v", err)
@@ -61,45 +178,252 @@ func StartLocalTiDBServer(ver string) (*LocalTiDBServer, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to get a temp dir: %v", err)
 	}
-	logFilePath := path.Join(tmpDir, "tidb.log")
-	slowLogFilePath := path.Join(tmpDir, "tidb_slow.log")
 
-	cmd := exec.Command(tiupPath, fmt.Sprintf("tidb:%v", ver),
-		fmt.Sprintf("--status=%v", statusPort),
-		fmt.Sprintf("-P=%v", port),
-		fmt.Sprintf("--path=%v", tmpDir),
-		fmt.Sprintf("--log-file=%v", logFilePath),
-		fmt.Sprintf("--log-slow-query=%v", slowLogFilePath))
-	var stdErr bytes.Buffer
-	cmd.Stderr = &stdErr
+	// write outputs into files instead of pipes to let the process outlive this process if it's reused
+	stdout, err := os.Create(path.Join(tmpDir, "stdout.log"))
+	if err != nil {
+		return nil, err
+	}
+	defer stdout.Close()
+	stderr, err := os.Create(path.Join(tmpDir, "stderr.log"))
+	if err != nil {
+		return nil, err
+	}
+	defer stderr.Close()
+	cmd := newCmd(port, statusPort, tmpDir)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	// start the process in a new process group to kill it together with its children, and to avoid receiving the
+	// SIGINT from the terminal directly
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
 	Infof("Starting TiDB %v", cmd.String())
 	if err := cmd.Start(); err != nil {
+		os.RemoveAll(tmpDir)
 		return nil, fmt.Errorf("failed to start TiDB: %v", err)
 	}
+	s := &LocalTiDBServer{
+		Pid:     cmd.Process.Pid,
+		Port:    port,
+		TmpDir:  tmpDir,
+		Started: time.Now(),
+		exited:  make(chan struct{}),
+		logDir:  opt.LogDir,
+	}
+	go func() {
+		cmd.Wait()
+		close(s.exited)
+	}()
 
-	Infof("Wait for TiDB to start, pid: %v", cmd.Process.Pid)
-	ok := false
+	Infof("Wait for TiDB to start, pid: %v, timeout: %v", s.Pid, opt.StartTimeout)
 	dsn := fmt.Sprintf("root:@tcp(127.0.0.1:%v)/test", port)
-	for i := 0; i < 10; i++ {
-		if PingLocalTiDB(dsn) {
-			Infof("TiDB started, port: %v, tmpDir: %v", port, tmpDir)
-			ok = true
-			break
+	timeout := time.After(opt.StartTimeout)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for !PingLocalTiDB(dsn) {
+		select {
+		case <-s.exited:
+			errMsg := readFileTail(path.Join(tmpDir, "stderr.log"))
+			s.saveLogs()
+			os.RemoveAll(tmpDir)
+			return nil, fmt.Errorf("TiDB exits unexpectedly: %v, stderr: %v", cmd.ProcessState, errMsg)
+		case <-timeout:
+			errMsg := readFileTail(path.Join(tmpDir, "stderr.log"))
+			s.Release()
+			return nil, fmt.Errorf("failed to start TiDB in %v, stderr: %v", opt.StartTimeout, errMsg)
+		case <-ticker.C:
+		}
+	}
+
+	if s.Version, err = readLocalTiDBVersion(s.DSN()); err != nil {
+		s.Release()
+		return nil, fmt.Errorf("failed to read the version of TiDB: %v", err)
+	}
+	if !versionMatches(opt.Version, s.Version) && opt.BinaryPath == "" {
+		Warningf("the started TiDB version %v is different from the expected version %v", s.Version, opt.Version)
+	}
+	Infof("TiDB %v started, port: %v, tmpDir: %v", s.Version, port, tmpDir)
+	return s, nil
+}
+
+// startCachedTiDBServer reuses the cached TiDB server if it's still alive and has the expected version, otherwise
+// starts a new one and caches it. The cache is protected by a lock file, which is held until releasing the server,
+// so only one process can use the cached server at the same time.
+func startCachedTiDBServer(opt LocalTiDBServerOptions, newCmd func(port, statusPort int, tmpDir string) *exec.Cmd) (*LocalTiDBServer, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	key := "tidb-" + opt.Version
+	if opt.BinaryPath != "" {
+		key = "tidb-binary-" + strings.ReplaceAll(strings.Trim(opt.BinaryPath, "/"), "/", "_")
+	}
+	cacheDir = path.Join(cacheDir, "index_advisor", key)
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return nil, err
+	}
+	lockFile, err := os.OpenFile(path.Join(cacheDir, "lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	Infof("wait for the lock of the cached TiDB server %v", cacheDir)
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		lockFile.Close()
+		return nil, fmt.Errorf("failed to lock %v: %v", lockFile.Name(), err)
+	}
+
+	serverFile := path.Join(cacheDir, "server.json")
+	if s, err := loadCachedTiDBServer(serverFile, opt.Version); err == nil {
+		s.lockFile, s.logDir = lockFile, opt.LogDir
+		if err := s.reset(); err == nil {
+			Infof("reuse the cached TiDB %v, pid: %v, port: %v", s.Version, s.Pid, s.Port)
+			return s, nil
+		}
+		Warningf("failed to reset the cached TiDB server, start a new one: %v", err)
+		s.kill()
+		os.RemoveAll(s.TmpDir)
+	} else if !os.IsNotExist(err) {
+		Infof("the cached TiDB server is unavailable, start a new one: %v", err)
+	}
+
+	s, err := startLocalTiDBServer(opt, newCmd)
+	if err != nil {
+		lockFile.Close()
+		return nil, err
+	}
+	s.lockFile = lockFile
+	data, err := json.Marshal(s)
+	if err == nil {
+		err = os.WriteFile(serverFile, data, 0644)
+	}
+	if err != nil {
+		Warningf("failed to cache the TiDB server: %v", err)
+	}
+	return s, nil
+}
+
+func loadCachedTiDBServer(serverFile, expectedVersion string) (*LocalTiDBServer, error) {
+	data, err := os.ReadFile(serverFile)
+	if err != nil {
+		return nil, err
+	}
+	s := new(LocalTiDBServer)
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	if err := syscall.Kill(s.Pid, 0); err != nil {
+		return nil, fmt.Errorf("process %v is not alive: %v", s.Pid, err)
+	}
+	if expectedVersion == "nightly" && time.Since(s.Started) > nightlyTiDBTTL {
+		return nil, fmt.Errorf("the nightly version %v started at %v is stale", s.Version, s.Started.Format(time.RFC3339))
+	}
+	version, err := readLocalTiDBVersion(s.DSN())
+	if err != nil {
+		return nil, err
+	}
+	if version != s.Version || !versionMatches(expectedVersion, version) {
+		return nil, fmt.Errorf("version %v is different from the expected version %v", version, expectedVersion)
+	}
+	return s, nil
+}
+
+// reset drops all databases left by previous processes of this tool, which are the ones with IsolatedSchemaPrefix.
+// Other databases are kept since they may be created by users.
+func (s *LocalTiDBServer) reset() error {
+	db, err := sql.Open("mysql", s.DSN())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	rows, err := db.Query("select SCHEMA_NAME from INFORMATION_SCHEMA.SCHEMATA")
+	if err != nil {
+		return err
+	}
+	var schemas []string
+	for rows.Next() {
+		var schema string
+		if err := rows.Scan(&schema); err != nil {
+			rows.Close()
+			return err
+		}
+		schemas = append(schemas, schema)
+	}
+	rows.Close()
+	for _, schema := range schemas {
+		if !strings.HasPrefix(strings.ToLower(schema), IsolatedSchemaPrefix) {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("drop database `%v`", schema)); err != nil {
+			return err
 		}
-		time.Sleep(time.Second * 2)
-		Infof("Wait for TiDB to start, pid: %v", cmd.Process.Pid)
 	}
-	if !ok {
-		cmd.Process.Kill()
-		return nil, fmt.Errorf("failed to start TiDB, stderr: %v", stdErr.String())
+	_, err = db.Exec("create database if not exists test")
+	return err
+}
+
+// readLocalTiDBVersion returns the release version of the TiDB, e.g. 'v7.1.0'.
+func readLocalTiDBVersion(dsn string) (string, error) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+	var info string
+	if err := db.QueryRow("select tidb_version()").Scan(&info); err != nil {
+		return "", err
+	}
+	return ParseTiDBVersion(info), nil
+}
+
+// ParseTiDBVersion returns the release version from the result of `select tidb_version()`.
+func ParseTiDBVersion(info string) string {
+	// Release Version: v7.1.0
+	// Edition: Community
+	// ...
+	for _, line := range strings.Split(info, "\n") {
+		if strings.HasPrefix(line, "Release Version:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "Release Version:"))
+		}
 	}
+	return strings.TrimSpace(info)
+}
+
+// versionMatches returns whether the actual version, e.g. 'v7.3.0-alpha-123-g7f8c0a', matches the expected version
+// passed to TiUP, e.g. 'v7.3.0' or 'nightly'. The nightly version can't be resolved without TiUP, so it matches any
+// version, and cached nightly servers are restarted after nightlyTiDBTTL instead.
+func versionMatches(expected, actual string) bool {
+	if expected == "" || expected == "nightly" {
+		return true
+	}
+	return strings.HasPrefix(actual, expected)
+}
 
-	return &LocalTiDBServer{
-		cmd:    cmd,
-		port:   port,
-		tmpDir: tmpDir,
-	}, nil
+// readFileTail returns the last 4KB of the file.
+func readFileTail(fpath string) string {
+	data, err := os.ReadFile(fpath)
+	if err != nil {
+		return ""
+	}
+	if len(data) > 4096 {
+		data = data[len(data)-4096:]
+	}
+	return string(data)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
 }
 
 // GetTempDir returns an temporary directory path
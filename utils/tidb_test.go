@@ -1,7 +1,12 @@
 package utils
 
 import (
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestStartTiDB(t *testing.T) {
@@ -17,3 +22,35 @@ func TestStartTiDB(t *testing.T) {
 		panic("TiDB should be killed")
 	}
 }
+
+func TestParseTiDBVersion(t *testing.T) {
+	info := "Release Version: v7.3.0-alpha-123-g7f8c0a\nEdition: Community\nGit Commit Hash: 7f8c0a"
+	if v := ParseTiDBVersion(info); v != "v7.3.0-alpha-123-g7f8c0a" {
+		t.Fatalf("unexpected version %v", v)
+	}
+	if v := ParseTiDBVersion(" v7.1.0 "); v != "v7.1.0" {
+		t.Fatalf("unexpected version %v", v)
+	}
+	for _, c := range []struct {
+		expected, actual string
+		match            bool
+	}{
+		{"nightly", "v7.4.0-alpha", true},
+		{"v7.3.0", "v7.3.0-alpha-123-g7f8c0a", true},
+		{"v7.3.0", "v7.1.0", false},
+	} {
+		if versionMatches(c.expected, c.actual) != c.match {
+			t.Fatalf("unexpected match result for %v and %v", c.expected, c.actual)
+		}
+	}
+}
+
+func TestLoadStaleNightlyTiDB(t *testing.T) {
+	serverFile := path.Join(t.TempDir(), "server.json")
+	data, err := json.Marshal(LocalTiDBServer{Pid: os.Getpid(), Version: "v7.4.0-alpha", Started: time.Now().Add(-2 * nightlyTiDBTTL)})
+	must(err)
+	must(os.WriteFile(serverFile, data, 0644))
+	if _, err := loadCachedTiDBServer(serverFile, "nightly"); err == nil || !strings.Contains(err.Error(), "stale") {
+		t.Fatalf("expected the stale error, got %v", err)
+	}
+}
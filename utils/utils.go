@@ -81,31 +81,15 @@ func ParseStmtsFromDir(dirPath string) (sqls, fileNames []string, err error) {
 }
 
 // ParseStmtsFromFile parses raw Queries from the given file.
-// It ignore all comments, and assume all Queries are separated by ';'.
+// It ignores all comments, see SplitStmts for more details.
 func ParseStmtsFromFile(fpath string) ([]string, error) {
-	data, err := os.ReadFile(fpath)
+	stmts, err := LoadStmtsFromFile(fpath)
 	if err != nil {
 		return nil, err
 	}
-	lines := strings.Split(string(data), "\n")
-	var filteredLines []string
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" || strings.HasPrefix(line, "--") { // empty line or comment
-			continue
-		}
-		filteredLines = append(filteredLines, line)
-	}
-	content := strings.Join(filteredLines, "\n")
-
-	tmp := strings.Split(content, ";")
-	var sqls []string
-	for _, sql := range tmp {
-		sql = strings.TrimSpace(sql)
-		if sql == "" {
-			continue
-		}
-		sqls = append(sqls, sql)
+	sqls := make([]string, 0, len(stmts))
+	for _, stmt := range stmts {
+		sqls = append(sqls, stmt.Text)
 	}
 	return sqls, nil
 }
@@ -54,3 +54,15 @@ func TestPlanCost(t *testing.T) {
 		t.Error("plan cost error")
 	}
 }
+
+func TestIndexConfCostLessWithRegressions(t *testing.T) {
+	cheap := IndexConfCost{TotalWorkloadQueryCost: 100, NumRegressedQueries: 1}
+	expensive := IndexConfCost{TotalWorkloadQueryCost: 1000}
+	if cheap.Less(expensive) || !expensive.Less(cheap) {
+		t.Error("configurations with regressed queries should lose")
+	}
+	cheap.NumRegressedQueries = 0
+	if !cheap.Less(expensive) {
+		t.Error("the cheaper configuration should win")
+	}
+}
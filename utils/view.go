@@ -0,0 +1,344 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/format"
+)
+
+// ParseCreateViewStmt parses a create view statement and returns a ViewSchema.
+func ParseCreateViewStmt(schemaName, createViewStmt string) (ViewSchema, error) {
+	stmt, err := ParseOneSQL(createViewStmt)
+	if err != nil {
+		return ViewSchema{}, err
+	}
+	createView, ok := stmt.(*ast.CreateViewStmt)
+	if !ok {
+		return ViewSchema{}, fmt.Errorf("not a create view statement: %v", createViewStmt)
+	}
+	v, err := createViewSchema(schemaName, createView)
+	if err != nil {
+		return ViewSchema{}, err
+	}
+	v.CreateStmtText = createViewStmt
+	return v, nil
+}
+
+func createViewSchema(schemaName string, stmt *ast.CreateViewStmt) (ViewSchema, error) {
+	var sb strings.Builder
+	ctx := format.NewRestoreCtx(format.RestoreStringSingleQuotes|format.RestoreKeyWordLowercase|format.RestoreNameBackQuotes|format.RestoreSpacesAroundBinaryOperation|format.RestoreStringWithoutCharset, &sb)
+	if err := stmt.Select.Restore(ctx); err != nil {
+		return ViewSchema{}, err
+	}
+	v := ViewSchema{
+		SchemaName: schemaName,
+		ViewName:   stmt.ViewName.Name.L,
+		SelectText: sb.String(),
+	}
+	for _, col := range stmt.Cols {
+		v.Columns = append(v.Columns, col.L)
+	}
+	return v, nil
+}
+
+// DDL returns the DDL of the view, which should be executed under the view's schema.
+func (v ViewSchema) DDL() string {
+	var cols string
+	if len(v.Columns) > 0 {
+		cols = fmt.Sprintf(" (`%v`)", strings.Join(v.Columns, "`, `"))
+	}
+	return fmt.Sprintf("CREATE OR REPLACE VIEW `%v`%v AS %v", v.ViewName, cols, v.SelectText)
+}
+
+// CollectTableNamesFromView returns all tables and views referenced by the view.
+func CollectTableNamesFromView(v ViewSchema) (Set[TableName], error) {
+	return CollectTableNamesFromSQL(v.SchemaName, v.SelectText)
+}
+
+// ExpandViews replaces views in the table names with the tables they reference recursively.
+func ExpandViews(tableNames Set[TableName], views Set[ViewSchema]) (Set[TableName], error) {
+	expanded := NewSet[TableName]()
+	visited := NewSet[TableName]()
+	pending := tableNames.ToList()
+	for len(pending) > 0 {
+		t := pending[0]
+		pending = pending[1:]
+		if visited.Contains(t) {
+			continue
+		}
+		visited.Add(t)
+		v, ok := views.Find(t)
+		if !ok {
+			expanded.Add(t)
+			continue
+		}
+		referenced, err := CollectTableNamesFromView(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid view %v: %v", v.Key(), err)
+		}
+		pending = append(pending, referenced.ToList()...)
+	}
+	return expanded, nil
+}
+
+// SortViewsByDependency sorts views to make sure a view is after all views it references, so that they can be created
+// in this order.
+func SortViewsByDependency(views Set[ViewSchema]) []ViewSchema {
+	var sorted []ViewSchema
+	added := NewSet[ViewSchema]()
+	var add func(v ViewSchema, depth int)
+	add = func(v ViewSchema, depth int) {
+		if added.Contains(v) || depth > views.Size() { // the depth check avoids cycles
+			return
+		}
+		if referenced, err := CollectTableNamesFromView(v); err == nil {
+			for _, t := range referenced.ToList() {
+				if dep, ok := views.Find(t); ok {
+					add(dep, depth+1)
+				}
+			}
+		}
+		if !added.Contains(v) {
+			added.Add(v)
+			sorted = append(sorted, v)
+		}
+	}
+	for _, v := range views.ToList() {
+		add(v, 0)
+	}
+	return sorted
+}
+
+// ViewColumn is an output column of a view.
+type ViewColumn struct {
+	Name        string
+	BaseColumns []Column // the base-table columns it comes from, empty if it's an expression like `a+1` or `count(*)`
+}
+
+// ViewResolver maps columns of views to columns of their base tables.
+type ViewResolver struct {
+	tables    map[string]TableSchema // lower-case `schema.table`
+	views     Set[ViewSchema]
+	cache     map[string][]ViewColumn
+	resolving map[string]bool // views being resolved, to detect cycles
+}
+
+// NewViewResolver creates a ViewResolver, views can be nil.
+func NewViewResolver(tables Set[TableSchema], views Set[ViewSchema]) *ViewResolver {
+	r := &ViewResolver{
+		tables:    make(map[string]TableSchema),
+		views:     views,
+		cache:     make(map[string][]ViewColumn),
+		resolving: make(map[string]bool),
+	}
+	if r.views == nil {
+		r.views = NewSet[ViewSchema]()
+	}
+	if tables != nil {
+		for _, t := range tables.ToList() {
+			r.tables[strings.ToLower(t.Key())] = t
+		}
+	}
+	return r
+}
+
+// IsView returns whether the table is a view.
+func (r *ViewResolver) IsView(t TableName) bool {
+	return r.views.ContainsKey(t.Key())
+}
+
+// View returns the view with the given name.
+func (r *ViewResolver) View(t TableName) (ViewSchema, bool) {
+	return r.views.Find(t)
+}
+
+// Table returns the base table with the given name.
+func (r *ViewResolver) Table(t TableName) (TableSchema, bool) {
+	table, ok := r.tables[t.Key()]
+	return table, ok
+}
+
+// BaseColumns returns the base-table columns where the column of the view comes from.
+func (r *ViewResolver) BaseColumns(view TableName, columnName string) ([]Column, error) {
+	v, ok := r.views.Find(view)
+	if !ok {
+		return nil, fmt.Errorf("view %v not found", view.Key())
+	}
+	cols, err := r.ViewColumns(v)
+	if err != nil {
+		return nil, err
+	}
+	var baseCols []Column
+	for _, col := range cols {
+		if col.Name == strings.ToLower(columnName) {
+			baseCols = append(baseCols, col.BaseColumns...)
+		}
+	}
+	return baseCols, nil
+}
+
+// ViewColumns returns the output columns of the view.
+func (r *ViewResolver) ViewColumns(v ViewSchema) ([]ViewColumn, error) {
+	if cols, ok := r.cache[v.Key()]; ok {
+		return cols, nil
+	}
+	if r.resolving[v.Key()] {
+		return nil, fmt.Errorf("view %v references itself", v.Key())
+	}
+	r.resolving[v.Key()] = true
+	cols, err := r.viewColumns(v)
+	delete(r.resolving, v.Key())
+	if err != nil {
+		return nil, fmt.Errorf("invalid view %v: %v", v.Key(), err)
+	}
+	r.cache[v.Key()] = cols
+	return cols, nil
+}
+
+func (r *ViewResolver) viewColumns(v ViewSchema) ([]ViewColumn, error) {
+	stmt, err := ParseOneSQL(v.SelectText)
+	if err != nil {
+		return nil, err
+	}
+	var selects []*ast.SelectStmt
+	switch x := stmt.(type) {
+	case *ast.SelectStmt:
+		selects = append(selects, x)
+	case *ast.SetOprStmt: // union, the names are from the first select, and columns are merged by positions
+		selects = flattenSetOprSelects(x.SelectList)
+	}
+	if len(selects) == 0 {
+		return nil, fmt.Errorf("unsupported select statement %v", v.SelectText)
+	}
+
+	var cols []ViewColumn
+	for i, sel := range selects {
+		selCols, err := r.selectColumns(v.SchemaName, sel)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			cols = selCols
+			continue
+		}
+		for j := range cols {
+			if j < len(selCols) {
+				cols[j].BaseColumns = append(cols[j].BaseColumns, selCols[j].BaseColumns...)
+			}
+		}
+	}
+	if len(v.Columns) > 0 {
+		if len(v.Columns) != len(cols) {
+			return nil, fmt.Errorf("the view has %v columns but its select statement returns %v columns", len(v.Columns), len(cols))
+		}
+		for i := range cols {
+			cols[i].Name = v.Columns[i]
+		}
+	}
+	return cols, nil
+}
+
+func flattenSetOprSelects(list *ast.SetOprSelectList) []*ast.SelectStmt {
+	var selects []*ast.SelectStmt
+	for _, n := range list.Selects {
+		switch x := n.(type) {
+		case *ast.SelectStmt:
+			selects = append(selects, x)
+		case *ast.SetOprSelectList:
+			selects = append(selects, flattenSetOprSelects(x)...)
+		}
+	}
+	return selects
+}
+
+// viewSource is a table source in the from clause of a view.
+type viewSource struct {
+	alias string
+	table TableName
+	cols  []ViewColumn // nil if it's a subquery, which is not supported
+}
+
+func (r *ViewResolver) selectColumns(schemaName string, sel *ast.SelectStmt) ([]ViewColumn, error) {
+	var sources []viewSource
+	if sel.From != nil {
+		var err error
+		if sources, err = r.collectSources(schemaName, sel.From.TableRefs, sources); err != nil {
+			return nil, err
+		}
+	}
+
+	var cols []ViewColumn
+	for _, f := range sel.Fields.Fields {
+		if f.WildCard != nil { // `*` or `t.*`
+			for _, s := range sources {
+				if f.WildCard.Table.L != "" && f.WildCard.Table.L != s.alias {
+					continue
+				}
+				for _, sc := range s.cols { // copy base columns since they may be shared with other views
+					cols = append(cols, ViewColumn{Name: sc.Name, BaseColumns: append([]Column(nil), sc.BaseColumns...)})
+				}
+			}
+			continue
+		}
+		col := ViewColumn{Name: f.AsName.L}
+		if c, ok := f.Expr.(*ast.ColumnNameExpr); ok {
+			if col.Name == "" {
+				col.Name = c.Name.Name.L
+			}
+			for _, s := range sources {
+				if c.Name.Table.L != "" && c.Name.Table.L != s.alias {
+					continue
+				}
+				for _, sc := range s.cols {
+					if sc.Name == c.Name.Name.L {
+						col.BaseColumns = append(col.BaseColumns, sc.BaseColumns...)
+					}
+				}
+			}
+		}
+		cols = append(cols, col)
+	}
+	return cols, nil
+}
+
+func (r *ViewResolver) collectSources(schemaName string, n ast.ResultSetNode, sources []viewSource) ([]viewSource, error) {
+	switch x := n.(type) {
+	case *ast.Join:
+		var err error
+		if sources, err = r.collectSources(schemaName, x.Left, sources); err != nil {
+			return nil, err
+		}
+		if x.Right != nil {
+			if sources, err = r.collectSources(schemaName, x.Right, sources); err != nil {
+				return nil, err
+			}
+		}
+	case *ast.TableSource:
+		tn, ok := x.Source.(*ast.TableName)
+		if !ok {
+			return append(sources, viewSource{alias: x.AsName.L}), nil
+		}
+		s := viewSource{alias: x.AsName.L, table: TableName{SchemaName: tn.Schema.O, TableName: tn.Name.L}}
+		if s.alias == "" {
+			s.alias = tn.Name.L
+		}
+		if s.table.SchemaName == "" {
+			s.table.SchemaName = schemaName
+		}
+		if v, ok := r.views.Find(s.table); ok {
+			cols, err := r.ViewColumns(v)
+			if err != nil {
+				return nil, err
+			}
+			s.cols = cols
+		} else if t, ok := r.Table(s.table); ok {
+			for _, col := range t.Columns {
+				s.cols = append(s.cols, ViewColumn{Name: col.ColumnName, BaseColumns: []Column{col}})
+			}
+		}
+		sources = append(sources, s)
+	}
+	return sources, nil
+}
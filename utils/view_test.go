@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestViewResolver(t *testing.T) {
+	t1, err := ParseCreateTableStmt("test", "create table t1 (a int, b int, c int)")
+	must(err)
+	t2, err := ParseCreateTableStmt("db2", "create table t2 (a int, d int)")
+	must(err)
+	var views []ViewSchema
+	for _, sql := range []string{
+		"create view v1 as select a as x, b, c+1 as c1 from t1 where c > 1",
+		"create view v2 (p, q) as select x, d from v1 join db2.t2 tt on v1.x = tt.a",
+		"create view v3 as select * from v2 union all select a, b from t1",
+		"create view v4 as select tt.*, t1.b from db2.t2 tt, t1",
+	} {
+		v, err := ParseCreateViewStmt("test", sql)
+		must(err)
+		views = append(views, v)
+	}
+	viewSet := ListToSet(views...)
+	r := NewViewResolver(ListToSet(t1, t2), viewSet)
+
+	for _, c := range []struct {
+		view, col string
+		expected  string
+	}{
+		{"v1", "x", "test.t1.a"},
+		{"v1", "B", "test.t1.b"},
+		{"v1", "c1", ""},
+		{"v1", "c", ""},
+		{"v2", "p", "test.t1.a"},
+		{"v2", "q", "db2.t2.d"},
+		{"v3", "p", "test.t1.a,test.t1.a"},
+		{"v3", "q", "db2.t2.d,test.t1.b"},
+		{"v4", "a", "db2.t2.a"},
+		{"v4", "b", "test.t1.b"},
+	} {
+		cols, err := r.BaseColumns(TableName{SchemaName: "test", TableName: c.view}, c.col)
+		must(err)
+		var keys []string
+		for _, col := range cols {
+			keys = append(keys, col.Key())
+		}
+		if got := strings.Join(keys, ","); got != c.expected {
+			t.Fatalf("%v.%v: expect %v, got %v", c.view, c.col, c.expected, got)
+		}
+	}
+	if !r.IsView(TableName{SchemaName: "TEST", TableName: "V1"}) || r.IsView(TableName{SchemaName: "test", TableName: "t1"}) {
+		t.Fatalf("unexpected IsView result")
+	}
+
+	tables, err := ExpandViews(ListToSet(TableName{SchemaName: "test", TableName: "v3"}), viewSet)
+	must(err)
+	if keys := strings.Join(tables.ToKeyList(), ","); keys != "db2.t2,test.t1" {
+		t.Fatalf("unexpected expanded tables: %v", keys)
+	}
+
+	var sorted []string
+	for _, v := range SortViewsByDependency(viewSet) {
+		sorted = append(sorted, v.ViewName)
+	}
+	if fmt.Sprint(sorted) != "[v1 v2 v3 v4]" {
+		t.Fatalf("unexpected order of views: %v", sorted)
+	}
+	if ddl := views[1].DDL(); ddl != "CREATE OR REPLACE VIEW `v2` (`p`, `q`) AS select `x`,`d` from `v1` join `db2`.`t2` as `tt` on `v1`.`x` = `tt`.`a`" {
+		t.Fatalf("unexpected DDL: %v", ddl)
+	}
+}
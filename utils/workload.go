@@ -17,9 +17,24 @@ type Query struct { // DQL or DML
 	SchemaName       string
 	Text             string
 	Frequency        int
+	AvgLatency       float64     // average latency in milliseconds, 0 if unknown
+	P99Latency       float64     // p99 latency in milliseconds, 0 if unknown
+	Weight           float64     // user-specified weight of this Query, 0 if not specified
+	Importance       float64     // weight of this Query in the workload cost decided by WeightQueries, 0 if not decided
+	MaxCost          float64     // the max estimated cost of this Query allowed by the regression guard, 0 if no limit
+	TargetReduction  float64     // the required cost reduction percentage of this Query as a target, 0 if not a target
 	IndexableColumns Set[Column] // Indexable columns related to this Query
 }
 
+// CostWeight returns the weight of the Query in the workload cost, which is its Importance if it's decided, or its
+// Frequency.
+func (q Query) CostWeight() float64 {
+	if q.Importance > 0 {
+		return q.Importance
+	}
+	return float64(q.Frequency)
+}
+
 // Key returns the key of the Query.
 func (q Query) Key() string {
 	return q.Text
@@ -62,12 +77,15 @@ func (t TableStats) Key() string {
 	return fmt.Sprintf("%v.%v", t.SchemaName, t.TableName)
 }
 
-// Column represents a column.
+// Column represents a column, or a key part of an index on the column like an expression `lower(email)` or a
+// prefix `url(32)`.
 type Column struct {
 	SchemaName string
 	TableName  string
 	ColumnName string
 	ColumnType *types.FieldType
+	Expression string // the expression of an expression key part, empty for plain columns
+	Length     int    // the length of a prefix key part, 0 for full columns
 }
 
 // NewColumn creates a new column.
@@ -84,14 +102,38 @@ func NewColumns(schemaName, tableName string, columnNames ...string) []Column {
 	return cols
 }
 
+// NewExpressionColumn creates a new expression key part on the column.
+func NewExpressionColumn(schemaName, tableName, columnName, expression string) Column {
+	c := NewColumn(schemaName, tableName, columnName)
+	c.Expression = expression
+	return c
+}
+
 // Key returns the key of the column.
 func (c Column) Key() string {
-	return fmt.Sprintf("%v.%v.%v", c.SchemaName, c.TableName, c.ColumnName)
+	return fmt.Sprintf("%v.%v.%v", c.SchemaName, c.TableName, c.KeyPart())
 }
 
 // String returns the string representation of the column.
 func (c Column) String() string {
-	return fmt.Sprintf("%v.%v.%v", c.SchemaName, c.TableName, c.ColumnName)
+	return fmt.Sprintf("%v.%v.%v", c.SchemaName, c.TableName, c.KeyPart())
+}
+
+// KeyPart returns the column as an index key part, which is the column name, the expression in parentheses or the
+// column name with the prefix length.
+func (c Column) KeyPart() string {
+	if c.Expression != "" {
+		return fmt.Sprintf("(%v)", c.Expression)
+	}
+	if c.Length > 0 {
+		return fmt.Sprintf("%v(%v)", c.ColumnName, c.Length)
+	}
+	return c.ColumnName
+}
+
+// IsPlain returns whether the column is a full column rather than an expression or a prefix key part.
+func (c Column) IsPlain() bool {
+	return c.Expression == "" && c.Length == 0
 }
 
 // Index represents an index.
@@ -100,6 +142,7 @@ type Index struct {
 	TableName  string
 	IndexName  string
 	Columns    []Column
+	Trimmed    string // which suffix columns are trimmed from the recommended index and why, shown in reports
 }
 
 // NewIndex creates a new index.
@@ -107,12 +150,14 @@ func NewIndex(schemaName, tableName, indexName string, columns ...string) Index
 	return Index{SchemaName: strings.ToLower(schemaName), TableName: strings.ToLower(tableName), IndexName: strings.ToLower(indexName), Columns: NewColumns(schemaName, tableName, columns...)}
 }
 
+// NewIndexWithColumns creates a new index with the columns, which may contain expression or prefix key parts.
 func NewIndexWithColumns(indexName string, columns ...Column) Index {
-	names := make([]string, len(columns))
+	cols := make([]Column, len(columns))
 	for i, col := range columns {
-		names[i] = col.ColumnName
+		cols[i] = NewExpressionColumn(col.SchemaName, col.TableName, col.ColumnName, col.Expression)
+		cols[i].Length = col.Length
 	}
-	return NewIndex(columns[0].SchemaName, columns[0].TableName, indexName, names...)
+	return Index{SchemaName: cols[0].SchemaName, TableName: cols[0].TableName, IndexName: strings.ToLower(indexName), Columns: cols}
 }
 
 // ColumnNames returns the column names of the index.
@@ -124,14 +169,23 @@ func (i Index) ColumnNames() []string {
 	return names
 }
 
+// KeyParts returns the key parts of the index, see Column.KeyPart.
+func (i Index) KeyParts() []string {
+	var parts []string
+	for _, col := range i.Columns {
+		parts = append(parts, col.KeyPart())
+	}
+	return parts
+}
+
 // DDL returns the DDL of the index.
 func (i Index) DDL() string {
-	return fmt.Sprintf("CREATE INDEX %v ON %v.%v (%v)", i.IndexName, i.SchemaName, i.TableName, strings.Join(i.ColumnNames(), ", "))
+	return fmt.Sprintf("CREATE INDEX %v ON %v.%v (%v)", i.IndexName, i.SchemaName, i.TableName, strings.Join(i.KeyParts(), ", "))
 }
 
 // Key returns the key of the index.
 func (i Index) Key() string {
-	return fmt.Sprintf("%v.%v(%v)", i.SchemaName, i.TableName, strings.Join(i.ColumnNames(), ","))
+	return fmt.Sprintf("%v.%v(%v)", i.SchemaName, i.TableName, strings.Join(i.KeyParts(), ","))
 }
 
 // PrefixContain returns whether j is a prefix of i.
@@ -140,7 +194,7 @@ func (i Index) PrefixContain(j Index) bool {
 		return false
 	}
 	for k := range j.Columns {
-		if i.Columns[k].ColumnName != j.Columns[k].ColumnName {
+		if i.Columns[k].KeyPart() != j.Columns[k].KeyPart() {
 			return false
 		}
 	}
@@ -222,8 +276,11 @@ func (p Plan) Format() string {
 type WorkloadInfo struct {
 	Queries          Set[Query]
 	TableSchemas     Set[TableSchema]
+	Views            Set[ViewSchema] // views referenced by queries, nil if there is no view
 	TableStats       Set[TableStats]
 	IndexableColumns Set[Column]
+	CandidateIndexes Set[Index] // composite candidate indexes from the indexable columns selection, nil if there is none
+	CostCoverage     float64    // the ratio of the workload cost covered by queries kept by the cost-based compression, 0 if not compressed by cost
 }
 
 // IndexConfCost is the cost of a index configuration.
@@ -231,6 +288,7 @@ type IndexConfCost struct {
 	TotalWorkloadQueryCost    float64
 	TotalNumberOfIndexColumns int
 	IndexKeysStr              string // IndexKeysStr is the string representation of the index keys.
+	NumRegressedQueries       int    // the number of queries whose costs exceed their MaxCost
 }
 
 // Less returns whether the cost of c is less than the cost of other.
@@ -241,6 +299,10 @@ func (c IndexConfCost) Less(other IndexConfCost) bool {
 	if other.TotalWorkloadQueryCost == 0 { // not initialized
 		return true
 	}
+	if c.NumRegressedQueries != other.NumRegressedQueries {
+		// configurations violating the regression guard lose to the ones with fewer violations.
+		return c.NumRegressedQueries < other.NumRegressedQueries
+	}
 	cc, cOther := c.TotalWorkloadQueryCost, other.TotalWorkloadQueryCost
 	if math.Abs(cc-cOther) > 10 && math.Abs(cc-cOther)/math.Max(cc, cOther) > 0.001 {
 		// their cost is very different, then the less cost, the better.
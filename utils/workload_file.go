@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// WorkloadFileVersion is the current version of the structured workload file format.
+const WorkloadFileVersion = 1
+
+// WorkloadFile is the structured workload file format.
+// Unlike a plain SQL file, it keeps the frequency, latency and weight of each Query.
+type WorkloadFile struct {
+	Version int                 `json:"version"`
+	Queries []WorkloadFileQuery `json:"queries"`
+}
+
+// WorkloadFileQuery represents a Query in the structured workload file.
+type WorkloadFileQuery struct {
+	Alias      string  `json:"alias"`
+	SchemaName string  `json:"schema_name"`
+	Text       string  `json:"text"`
+	Frequency  int     `json:"frequency"`
+	AvgLatency float64 `json:"avg_latency_ms,omitempty"` // in milliseconds
+	P99Latency float64 `json:"p99_latency_ms,omitempty"` // in milliseconds
+	StmtType   string  `json:"stmt_type,omitempty"`      // e.g. 'Select'
+	Weight     float64 `json:"weight,omitempty"`         // optional user-specified weight
+}
+
+// IsWorkloadFilePath returns whether the given path is a structured workload file.
+func IsWorkloadFilePath(fpath string) bool {
+	return strings.HasSuffix(strings.ToLower(fpath), ".json")
+}
+
+// SaveWorkloadFile saves these Queries into the given path with the structured workload file format.
+func SaveWorkloadFile(fpath string, queries Set[Query]) error {
+	f := WorkloadFile{Version: WorkloadFileVersion}
+	for _, q := range queries.ToList() {
+		f.Queries = append(f.Queries, WorkloadFileQuery{
+			Alias:      q.Alias,
+			SchemaName: q.SchemaName,
+			Text:       strings.TrimSpace(q.Text),
+			Frequency:  q.Frequency,
+			AvgLatency: q.AvgLatency,
+			P99Latency: q.P99Latency,
+			StmtType:   "Select",
+			Weight:     q.Weight,
+		})
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return SaveContentTo(fpath, string(data))
+}
+
+// LoadWorkloadFile loads Queries from the given structured workload file.
+// Queries without schema name are considered to be under the defaultSchemaName.
+func LoadWorkloadFile(fpath, defaultSchemaName string) ([]Query, error) {
+	data, err := os.ReadFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+	var f WorkloadFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid workload file %v: %v", fpath, err)
+	}
+	if f.Version < 1 || f.Version > WorkloadFileVersion {
+		return nil, fmt.Errorf("unsupported workload file version %v in %v, the supported version is %v",
+			f.Version, fpath, WorkloadFileVersion)
+	}
+
+	var queries []Query
+	for i, fq := range f.Queries {
+		if fq.StmtType != "" && !strings.EqualFold(fq.StmtType, "select") {
+			continue
+		}
+		if fq.StmtType == "" && GetStmtType(fq.Text) != StmtSelect {
+			continue
+		}
+		q := Query{
+			Alias:      fq.Alias,
+			SchemaName: fq.SchemaName,
+			Text:       strings.TrimSuffix(strings.TrimSpace(fq.Text), ";"),
+			Frequency:  fq.Frequency,
+			AvgLatency: fq.AvgLatency,
+			P99Latency: fq.P99Latency,
+			Weight:     fq.Weight,
+		}
+		if q.Alias == "" {
+			q.Alias = fmt.Sprintf("q%v", i+1)
+		}
+		if q.SchemaName == "" {
+			q.SchemaName = defaultSchemaName
+		}
+		if q.Frequency < 1 {
+			q.Frequency = 1
+		}
+		queries = append(queries, q)
+	}
+	return queries, nil
+}
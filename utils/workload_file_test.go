@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"path"
+	"testing"
+)
+
+func TestWorkloadFile(t *testing.T) {
+	queries := NewSet[Query]()
+	queries.Add(Query{Alias: "q1", SchemaName: "db1", Text: "select * from t where a=1", Frequency: 20, AvgLatency: 1.5, P99Latency: 9})
+	queries.Add(Query{Alias: "q2", SchemaName: "db2", Text: "select * from t where b<1", Frequency: 3, Weight: 10})
+
+	fpath := path.Join(t.TempDir(), "queries.json")
+	must(SaveWorkloadFile(fpath, queries))
+	loaded, err := LoadQueries("test", fpath)
+	must(err)
+	if loaded.Size() != 2 {
+		t.Fatalf("expect 2 queries, got %v", loaded.Size())
+	}
+	for _, q := range queries.ToList() {
+		got, ok := loaded.Find(q)
+		if !ok {
+			t.Fatalf("query %v is not loaded", q.Text)
+		}
+		if got.Alias != q.Alias || got.SchemaName != q.SchemaName || got.Frequency != q.Frequency ||
+			got.AvgLatency != q.AvgLatency || got.P99Latency != q.P99Latency || got.Weight != q.Weight {
+			t.Errorf("expect %+v, got %+v", q, got)
+		}
+	}
+}
+
+func TestWorkloadFileDefaultValues(t *testing.T) {
+	fpath := path.Join(t.TempDir(), "queries.json")
+	must(SaveContentTo(fpath, `{"version": 1, "queries": [
+		{"text": "select * from t where a=1;"},
+		{"text": "update t set a=1", "stmt_type": "Update"},
+		{"text": "insert into t values (1)"}]}`))
+	queries, err := LoadWorkloadFile(fpath, "test")
+	must(err)
+	if len(queries) != 1 {
+		t.Fatalf("expect 1 query, got %+v", queries)
+	}
+	q := queries[0]
+	if q.Alias != "q1" || q.SchemaName != "test" || q.Frequency != 1 || q.Text != "select * from t where a=1" {
+		t.Errorf("unexpected query %+v", q)
+	}
+
+	must(SaveContentTo(fpath, `{"version": 100, "queries": []}`))
+	if _, err := LoadWorkloadFile(fpath, "test"); err == nil {
+		t.Errorf("expect an error for unsupported version")
+	}
+}
+
+func TestWeightQueries(t *testing.T) {
+	queries := NewSet[Query]()
+	queries.Add(Query{Text: "q1", Frequency: 10, AvgLatency: 30000, P99Latency: 60000})
+	queries.Add(Query{Text: "q2", Frequency: 10000, AvgLatency: 1, Weight: 2})
+	queries.Add(Query{Text: "q3", Frequency: 100}) // unknown latency
+
+	for _, c := range []struct {
+		strategy string
+		expected map[string]float64
+	}{
+		{WeightByFrequency, map[string]float64{"q1": 10, "q2": 10000, "q3": 100}},
+		{WeightByTotalLatency, map[string]float64{"q1": 300000, "q2": 10000, "q3": 100 * 30001 / 2.0}},
+		{WeightByP99Latency, map[string]float64{"q1": 600000, "q2": 10000, "q3": 100 * 60001 / 2.0}},
+		{WeightByUser, map[string]float64{"q1": 10, "q2": 20000, "q3": 100}},
+	} {
+		must(WeightQueries(queries, c.strategy))
+		for _, q := range queries.ToList() {
+			if q.CostWeight() != c.expected[q.Text] {
+				t.Errorf("%v: expect %v for %v, got %v", c.strategy, c.expected[q.Text], q.Text, q.CostWeight())
+			}
+		}
+	}
+	if err := WeightQueries(queries, "unknown"); err == nil {
+		t.Errorf("expect an error for the unknown strategy")
+	}
+}
+
+func TestParseQueryTargets(t *testing.T) {
+	targets, err := ParseQueryTargets("q1:80, Q2\n# comment:1\nabcdef:30%,", 50)
+	must(err)
+	expected := map[string]float64{"q1": 80, "q2": 50, "abcdef": 30}
+	if len(targets) != len(expected) {
+		t.Fatalf("expect %v, got %v", expected, targets)
+	}
+	for name, reduction := range expected {
+		if targets[name] != reduction {
+			t.Errorf("expect %v for %v, got %v", reduction, name, targets[name])
+		}
+	}
+	for _, text := range []string{"q1:x", "q1:100", "q1:0"} {
+		if _, err := ParseQueryTargets(text, 50); err == nil {
+			t.Errorf("expect an error for %v", text)
+		}
+	}
+
+	queries := NewSet[Query]()
+	queries.Add(Query{Alias: "q1", Text: "select * from t where a = 1"})
+	queries.Add(Query{Alias: "q3", Text: "select * from t where b = 1"})
+	_, digest := NormalizeDigest("select * from t where b = 2")
+	MarkTargetQueries(queries, map[string]float64{"q1": 80, digest: 30})
+	for _, q := range queries.ToList() {
+		if (q.Alias == "q1" && q.TargetReduction != 80) || (q.Alias == "q3" && q.TargetReduction != 30) {
+			t.Errorf("unexpected target reduction %v of %v", q.TargetReduction, q.Alias)
+		}
+	}
+}
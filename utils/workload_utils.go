@@ -3,6 +3,8 @@ package utils
 import (
 	"fmt"
 	"github.com/pingcap/parser/ast"
+	"path"
+	"strconv"
 	"strings"
 )
 
@@ -65,6 +67,9 @@ func LoadQueries(schemaName, queryPath string) (Set[Query], error) {
 			return nil, err
 		}
 		for i, rawSQL := range rawSQLs {
+			if _, err := ParseOneSQL(rawSQL); err != nil {
+				return nil, fmt.Errorf("%v: %v", path.Join(queryPath, names[i]), err)
+			}
 			queries.Add(Query{
 				Alias:      strings.Split(names[i], ".")[0], // q1.sql, 2a.sql, etc.
 				SchemaName: schemaName,                      // Notice: for simplification, assume all Queries are under the same schema here.
@@ -73,55 +78,172 @@ func LoadQueries(schemaName, queryPath string) (Set[Query], error) {
 			})
 		}
 		Infof("load %d queries from dir %s", len(rawSQLs), queryPath)
+	} else if exist, _ := FileExists(queryPath); exist && IsWorkloadFilePath(queryPath) {
+		fileQueries, err := LoadWorkloadFile(queryPath, schemaName)
+		if err != nil {
+			return nil, err
+		}
+		queries.AddList(fileQueries...)
+		Infof("load %d queries from workload file %s", len(fileQueries), queryPath)
 	} else if exist, isDir := FileExists(queryPath); exist || !isDir {
-		rawSQLs, err := ParseStmtsFromFile(queryPath)
+		stmts, err := LoadStmtsFromFile(queryPath)
 		if err != nil {
 			return nil, err
 		}
-		for i, rawSQL := range rawSQLs {
-			stmtType := GetStmtType(rawSQL)
+		for i, stmt := range stmts {
+			stmtType := GetStmtType(stmt.Text)
 			if stmtType == StmtUseDB {
-				schemaName = GetDBNameFromUseDBStmt(rawSQL)
+				schemaName = GetDBNameFromUseDBStmt(stmt.Text)
 			}
 			if stmtType != StmtSelect {
 				continue
 			}
+			if _, err := ParseOneSQL(stmt.Text); err != nil {
+				return nil, fmt.Errorf("%v: %v", stmt.Pos(), err)
+			}
 
 			queries.Add(Query{
 				Alias:      fmt.Sprintf("q%v", i+1),
 				SchemaName: schemaName, // Notice: for simplification, assume all Queries are under the same schema here.
-				Text:       rawSQL,
+				Text:       stmt.Text,
 				Frequency:  1,
 			})
 		}
-		Infof("load %d queries from %s", len(rawSQLs), queryPath)
+		Infof("load %d queries from %s", len(stmts), queryPath)
 	} else {
 		return nil, fmt.Errorf("can not find queries directory or queries.sql file under %s", queryPath)
 	}
 	return queries, nil
 }
 
+// Strategies to weight queries in the workload cost.
+const (
+	WeightByFrequency    = "frequency"     // the execution count
+	WeightByTotalLatency = "total-latency" // the execution count × the average latency
+	WeightByP99Latency   = "p99-latency"   // the execution count × the p99 latency
+	WeightByUser         = "user"          // the execution count × the user-specified weight
+)
+
+// WeightQueries decides the Importance of these Queries by the given strategy. Queries with unknown latencies use the
+// average latency of other Queries, and it falls back to the frequency if there is no latency at all. For p99-latency,
+// the average latency is used if the p99 latency of a Query is unknown. For user, the weight is 1 if unspecified.
+func WeightQueries(queries Set[Query], strategy string) error {
+	latency := func(Query) float64 { return 0 }
+	switch strings.ToLower(strategy) {
+	case WeightByFrequency:
+	case WeightByTotalLatency:
+		latency = func(q Query) float64 { return q.AvgLatency }
+	case WeightByP99Latency:
+		latency = func(q Query) float64 {
+			if q.P99Latency > 0 {
+				return q.P99Latency
+			}
+			return q.AvgLatency
+		}
+	case WeightByUser:
+		for _, q := range queries.ToList() {
+			q.Importance = float64(q.Frequency)
+			if q.Weight > 0 {
+				q.Importance *= q.Weight
+			}
+			queries.Add(q)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown query weighting strategy %v, should be one of '%v', '%v', '%v', '%v'", strategy,
+			WeightByFrequency, WeightByTotalLatency, WeightByP99Latency, WeightByUser)
+	}
+
+	var sum float64
+	var known int
+	for _, q := range queries.ToList() {
+		if l := latency(q); l > 0 {
+			sum += l
+			known++
+		}
+	}
+	if known == 0 && strings.ToLower(strategy) != WeightByFrequency {
+		Warningf("no latency of queries is known, weight queries by %v instead of %v", WeightByFrequency, strategy)
+	}
+	for _, q := range queries.ToList() {
+		l := latency(q)
+		if l <= 0 && known > 0 {
+			l = sum / float64(known)
+		}
+		q.Importance = float64(q.Frequency)
+		if l > 0 {
+			q.Importance *= l
+		}
+		queries.Add(q)
+	}
+	return nil
+}
+
+// ParseQueryTargets parses target queries like `q1:50, q2` separated by commas or new lines, where the number after the
+// alias or digest is the required cost reduction percentage, and defaultReduction is used if it's omitted. Lines
+// starting with `#` are ignored. It returns the required reduction of each target in lower case.
+func ParseQueryTargets(text string, defaultReduction float64) (map[string]float64, error) {
+	targets := make(map[string]float64)
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, item := range strings.Split(line, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			name, reduction := item, defaultReduction
+			if i := strings.LastIndex(item, ":"); i >= 0 {
+				var err error
+				name = strings.TrimSpace(item[:i])
+				if reduction, err = strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(item[i+1:], "%")), 64); err != nil {
+					return nil, fmt.Errorf("invalid target %v: %v", item, err)
+				}
+			}
+			if reduction <= 0 || reduction >= 100 {
+				return nil, fmt.Errorf("invalid target %v: the required cost reduction should be in (0, 100)", item)
+			}
+			targets[strings.ToLower(name)] = reduction
+		}
+	}
+	return targets, nil
+}
+
+// MarkTargetQueries sets TargetReduction of Queries whose aliases or digests are in targets, and warns about targets
+// matching no Query.
+func MarkTargetQueries(queries Set[Query], targets map[string]float64) {
+	found := make(map[string]bool)
+	for _, q := range queries.ToList() {
+		_, digest := NormalizeDigest(q.Text)
+		for _, name := range []string{strings.ToLower(q.Alias), digest} {
+			if reduction, ok := targets[name]; ok {
+				q.TargetReduction = reduction
+				found[name] = true
+				queries.Add(q)
+				break
+			}
+		}
+	}
+	for name := range targets {
+		if !found[name] {
+			Warningf("target query %v is not found in the workload", name)
+		}
+	}
+}
+
 // ParseCreateTableStmt parses a create table statement and returns a TableSchema.
 func ParseCreateTableStmt(schemaName, createTableStmt string) (TableSchema, error) {
 	stmt, err := ParseOneSQL(createTableStmt)
 	if err != nil {
 		return TableSchema{}, err
 	}
-	createTable := stmt.(*ast.CreateTableStmt)
-	t := TableSchema{
-		SchemaName:     schemaName,
-		TableName:      createTable.Table.Name.L,
-		CreateStmtText: createTableStmt,
-	}
-	for _, colDef := range createTable.Cols {
-		t.Columns = append(t.Columns, Column{
-			SchemaName: schemaName,
-			TableName:  createTable.Table.Name.L,
-			ColumnName: colDef.Name.Name.L,
-			ColumnType: colDef.Tp.Clone(),
-		})
+	createTable, ok := stmt.(*ast.CreateTableStmt)
+	if !ok {
+		return TableSchema{}, fmt.Errorf("not a create table statement: %v", createTableStmt)
 	}
-	// TODO: parse indexes
+	t := createTableSchema(schemaName, createTable)
+	t.CreateStmtText = createTableStmt
 	return t, nil
 }
 
@@ -142,10 +264,27 @@ func ParseCreateIndexStmt(createIndexStmt string) (Index, error) {
 		IndexName:  createIndex.IndexName,
 	}
 	for _, col := range createIndex.IndexPartSpecifications {
+		if col.Expr != nil {
+			text, column, ok, err := IndexExpression(col.Expr)
+			if err != nil {
+				return Index{}, err
+			}
+			if !ok {
+				return Index{}, fmt.Errorf("expression key parts should refer to exactly one column")
+			}
+			index.Columns = append(index.Columns, Column{
+				SchemaName: schemaName,
+				TableName:  tableName,
+				ColumnName: column,
+				Expression: text,
+			})
+			continue
+		}
 		index.Columns = append(index.Columns, Column{
 			SchemaName: schemaName,
 			TableName:  tableName,
 			ColumnName: col.Column.Name.O,
+			Length:     Max(col.Length, 0), // -1 if unspecified
 		})
 	}
 	return index, nil